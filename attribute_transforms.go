@@ -0,0 +1,113 @@
+package ldevents
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldattr"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+)
+
+// AttributeTransform is a way of disguising the value of a context attribute in analytics events,
+// rather than removing it as with a private attribute, so that it can still be joined on in
+// analytics without being revealed. See EventsConfiguration.AttributeTransforms.
+//
+// A value that is also private, because of the configuration or the context, is removed as usual,
+// and no transforms are applied if EventsConfiguration.AllAttributesPrivate is true.
+type AttributeTransform interface {
+	// Name identifies the transform in the "_meta" property of the output context.
+	Name() string
+	// Transform returns the value to send instead of the original one. If the second return value is
+	// false, the value is removed just as if it were private: for instance, if a transform for email
+	// addresses is given something that is not one.
+	Transform(value ldvalue.Value) (ldvalue.Value, bool)
+}
+
+// PrivateAttributeTransform associates an AttributeTransform with the attribute or attributes that
+// it applies to. See EventsConfiguration.AttributeTransforms.
+type PrivateAttributeTransform struct {
+	// Attribute is an attribute reference in the same format as EventsConfiguration.PrivateAttributes,
+	// so it can designate a property within an object attribute, or elements of an array.
+	Attribute ldattr.Ref
+	// Transform is the transform to apply.
+	Transform AttributeTransform
+}
+
+type attributeTransformFunc struct {
+	name string
+	fn   func(ldvalue.Value) (ldvalue.Value, bool)
+}
+
+// NewAttributeTransform creates an AttributeTransform from a function.
+func NewAttributeTransform(name string, fn func(ldvalue.Value) (ldvalue.Value, bool)) AttributeTransform {
+	return attributeTransformFunc{name: name, fn: fn}
+}
+
+func (t attributeTransformFunc) Name() string { return t.name }
+
+func (t attributeTransformFunc) Transform(value ldvalue.Value) (ldvalue.Value, bool) {
+	return t.fn(value)
+}
+
+// NewHMACSHA256Transform creates an AttributeTransform that replaces a value with the hex-encoded
+// HMAC-SHA256 digest of it, using the given secret as the key. Equal values always produce the
+// same digest, so they can still be matched with each other, but the values themselves cannot be
+// recovered without the secret.
+//
+// The digest of a string is computed from the string itself; the digest of any other value is
+// computed from its JSON representation.
+func NewHMACSHA256Transform(secret []byte) AttributeTransform {
+	key := append([]byte(nil), secret...)
+	return NewAttributeTransform("hmac-sha256", func(value ldvalue.Value) (ldvalue.Value, bool) {
+		mac := hmac.New(sha256.New, key)
+		if value.Type() == ldvalue.StringType {
+			_, _ = mac.Write([]byte(value.StringValue()))
+		} else {
+			_, _ = mac.Write([]byte(value.JSONString()))
+		}
+		return ldvalue.String(hex.EncodeToString(mac.Sum(nil))), true
+	})
+}
+
+// NewEmailDomainTransform creates an AttributeTransform that replaces an email address with its
+// domain, in lowercase: "Sandy@Example.com" becomes "example.com". A value that is not a string
+// containing "@" is removed.
+func NewEmailDomainTransform() AttributeTransform {
+	return NewAttributeTransform("email-domain", func(value ldvalue.Value) (ldvalue.Value, bool) {
+		at := strings.LastIndex(value.StringValue(), "@")
+		if value.Type() != ldvalue.StringType || at < 0 || at == len(value.StringValue())-1 {
+			return ldvalue.Null(), false
+		}
+		return ldvalue.String(strings.ToLower(value.StringValue()[at+1:])), true
+	})
+}
+
+// NewCoordinateRoundingTransform creates an AttributeTransform that rounds numbers to the given
+// number of decimal places, for coarsening geographic coordinates: with 1 decimal place, a latitude
+// or longitude is accurate to about 10 kilometers. If the value is an object or an array, every
+// number within it is rounded, so it can be applied to an attribute like {"lat": 37.77, "lon": -122.42}
+// as a whole. Any other values are left unchanged.
+func NewCoordinateRoundingTransform(decimalPlaces int) AttributeTransform {
+	scale := math.Pow(10, float64(decimalPlaces))
+	return NewAttributeTransform("round-"+strconv.Itoa(decimalPlaces),
+		func(value ldvalue.Value) (ldvalue.Value, bool) {
+			return roundNumbers(value, scale), true
+		})
+}
+
+func roundNumbers(value ldvalue.Value, scale float64) ldvalue.Value {
+	switch value.Type() {
+	case ldvalue.NumberType:
+		return ldvalue.Float64(math.Round(value.Float64Value()*scale) / scale)
+	case ldvalue.ArrayType, ldvalue.ObjectType:
+		return value.Transform(func(_ int, _ string, element ldvalue.Value) (ldvalue.Value, bool) {
+			return roundNumbers(element, scale), true
+		})
+	default:
+		return value
+	}
+}
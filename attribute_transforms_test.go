@@ -0,0 +1,82 @@
+package ldevents
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHMACSHA256Transform(t *testing.T) {
+	secret := []byte("secret")
+	transform := NewHMACSHA256Transform(secret)
+	assert.Equal(t, "hmac-sha256", transform.Name())
+
+	expectedDigest := func(data string) ldvalue.Value {
+		mac := hmac.New(sha256.New, secret)
+		_, _ = mac.Write([]byte(data))
+		return ldvalue.String(hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	for _, p := range []struct {
+		value ldvalue.Value
+		data  string
+	}{
+		{ldvalue.String("sandy@example.com"), "sandy@example.com"},
+		{ldvalue.Int(123), "123"},
+		{ldvalue.ArrayOf(ldvalue.String("a")), `["a"]`},
+	} {
+		t.Run(p.value.JSONString(), func(t *testing.T) {
+			result, ok := transform.Transform(p.value)
+			assert.True(t, ok)
+			assert.Equal(t, expectedDigest(p.data), result)
+		})
+	}
+
+	other, _ := NewHMACSHA256Transform([]byte("other-secret")).Transform(ldvalue.String("x"))
+	same, _ := transform.Transform(ldvalue.String("x"))
+	assert.NotEqual(t, other, same)
+}
+
+func TestEmailDomainTransform(t *testing.T) {
+	transform := NewEmailDomainTransform()
+	assert.Equal(t, "email-domain", transform.Name())
+
+	result, ok := transform.Transform(ldvalue.String("Sandy@Example.com"))
+	assert.True(t, ok)
+	assert.Equal(t, ldvalue.String("example.com"), result)
+
+	for _, value := range []ldvalue.Value{ldvalue.String("sandy"), ldvalue.String("sandy@"), ldvalue.Int(1)} {
+		_, ok := transform.Transform(value)
+		assert.False(t, ok, value.JSONString())
+	}
+}
+
+func TestCoordinateRoundingTransform(t *testing.T) {
+	transform := NewCoordinateRoundingTransform(1)
+	assert.Equal(t, "round-1", transform.Name())
+
+	result, ok := transform.Transform(ldvalue.Float64(37.7749))
+	assert.True(t, ok)
+	assert.Equal(t, ldvalue.Float64(37.8), result)
+
+	result, _ = transform.Transform(ldvalue.Parse([]byte(`{"lat":37.7749,"lon":-122.4194,"name":"SF","history":[1.25]}`)))
+	assert.JSONEq(t, `{"lat":37.8,"lon":-122.4,"name":"SF","history":[1.3]}`, result.JSONString())
+
+	result, _ = NewCoordinateRoundingTransform(0).Transform(ldvalue.Float64(2.6))
+	assert.Equal(t, ldvalue.Int(3), result)
+}
+
+func TestCustomAttributeTransform(t *testing.T) {
+	transform := NewAttributeTransform("upper", func(value ldvalue.Value) (ldvalue.Value, bool) {
+		return ldvalue.String("X"), value.Type() == ldvalue.StringType
+	})
+	assert.Equal(t, "upper", transform.Name())
+	result, ok := transform.Transform(ldvalue.String("a"))
+	assert.True(t, ok)
+	assert.Equal(t, ldvalue.String("X"), result)
+}
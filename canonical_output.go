@@ -0,0 +1,160 @@
+package ldevents
+
+import (
+	"sort"
+
+	"github.com/launchdarkly/go-jsonstream/v3/jwriter"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldmigration"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+)
+
+// In this file are the helpers for EventsConfiguration.CanonicalOutput, which is meant for
+// golden-file tests and for deduplicating payloads by hash. Wherever the output is built by iterating
+// over a map, such as the flags and counters of summary events, the origins of migration
+// measurements, the properties of JSON object values, and the redactedAttributes of contexts, the
+// formatters collect the keys into a slice first, and sort it only if canonical output is enabled,
+// so that the default output is not slowed down by sorting. Preserialized contexts and raw events
+// are written unchanged, unless EventsConfiguration.RedactPreserializedContexts applies.
+
+// writeValue writes a JSON value. If sorted is true, the properties of every object within it
+// are written in order of their names; otherwise they are in the order of the underlying map.
+func writeValue(w *jwriter.Writer, value ldvalue.Value, sorted bool) {
+	if !sorted {
+		value.WriteToJSONWriter(w)
+		return
+	}
+	switch value.Type() {
+	case ldvalue.ObjectType:
+		obj := w.Object()
+		keys := value.Keys(nil)
+		sort.Strings(keys)
+		for _, key := range keys {
+			obj.Name(key)
+			writeValue(w, value.GetByKey(key), true)
+		}
+		obj.End()
+	case ldvalue.ArrayType:
+		arr := w.Array()
+		for i := 0; i < value.Count(); i++ {
+			writeValue(w, value.GetByIndex(i), true)
+		}
+		arr.End()
+	default:
+		value.WriteToJSONWriter(w)
+	}
+}
+
+func flagSummaryKeys(flags map[string]flagSummary, sorted bool) []string {
+	keys := make([]string, 0, len(flags))
+	for key := range flags {
+		keys = append(keys, key)
+	}
+	if sorted {
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+// counterKeys returns the keys of a flag's counters. Sorted, they are in order of variation and
+// then version, with undefined values first.
+func counterKeys(counters map[counterKey]*counterValue, sorted bool) []counterKey {
+	keys := make([]counterKey, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	if sorted {
+		sort.Slice(keys, func(i, j int) bool {
+			if c := compareOptionalInts(keys[i].variation, keys[j].variation); c != 0 {
+				return c < 0
+			}
+			return compareOptionalInts(keys[i].version, keys[j].version) < 0
+		})
+	}
+	return keys
+}
+
+func compareOptionalInts(a, b ldvalue.OptionalInt) int {
+	switch {
+	case a.IsDefined() != b.IsDefined():
+		if a.IsDefined() {
+			return 1
+		}
+		return -1
+	case a.IntValue() < b.IntValue():
+		return -1
+	case a.IntValue() > b.IntValue():
+		return 1
+	default:
+		return 0
+	}
+}
+
+func contextKindKeys(kinds map[ldcontext.Kind]struct{}, sorted bool) []ldcontext.Kind {
+	keys := make([]ldcontext.Kind, 0, len(kinds))
+	for kind := range kinds {
+		keys = append(keys, kind)
+	}
+	if sorted {
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	}
+	return keys
+}
+
+func reasonKeys(reasons map[reasonKey]int, sorted bool) []reasonKey {
+	keys := make([]reasonKey, 0, len(reasons))
+	for key := range reasons {
+		keys = append(keys, key)
+	}
+	if sorted {
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].kind != keys[j].kind {
+				return keys[i].kind < keys[j].kind
+			}
+			return keys[i].errorKind < keys[j].errorKind
+		})
+	}
+	return keys
+}
+
+func metricKeys(metrics map[metricKey]*metricAggregate, sorted bool) []metricKey {
+	keys := make([]metricKey, 0, len(metrics))
+	for key := range metrics {
+		keys = append(keys, key)
+	}
+	if sorted {
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].key != keys[j].key {
+				return keys[i].key < keys[j].key
+			}
+			return keys[i].contextKind < keys[j].contextKind
+		})
+	}
+	return keys
+}
+
+func originKeys(origins map[ldmigration.Origin]struct{}, sorted bool) []ldmigration.Origin {
+	keys := make([]ldmigration.Origin, 0, len(origins))
+	for origin := range origins {
+		keys = append(keys, origin)
+	}
+	if sorted {
+		sortOrigins(keys)
+	}
+	return keys
+}
+
+func latencyOriginKeys(latencies map[ldmigration.Origin]int, sorted bool) []ldmigration.Origin {
+	keys := make([]ldmigration.Origin, 0, len(latencies))
+	for origin := range latencies {
+		keys = append(keys, origin)
+	}
+	if sorted {
+		sortOrigins(keys)
+	}
+	return keys
+}
+
+func sortOrigins(origins []ldmigration.Origin) {
+	sort.Slice(origins, func(i, j int) bool { return origins[i] < origins[j] })
+}
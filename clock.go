@@ -0,0 +1,62 @@
+package ldevents
+
+import (
+	"time"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+)
+
+// Clock is a source of the current time and of periodic timers.
+//
+// The event processor uses a Clock to compute event timestamps, to decide whether debug mode has
+// expired for a flag, and to schedule flushes, context key resets, and diagnostic events. The
+// default is SystemClock; tests can substitute an implementation whose time only advances when
+// the test says so, such as the FakeClock in the ldeventstest package. A DiagnosticsManager should
+// be created with NewDiagnosticsManagerWithClock to use the same Clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTicker returns a Ticker that delivers the current time on its channel every interval. The
+	// interval must be greater than zero.
+	NewTicker(interval time.Duration) Ticker
+}
+
+// Ticker is a periodic timer created by a Clock. It is equivalent to time.Ticker.
+type Ticker interface {
+	// C returns the channel on which ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the ticker. It does not close the channel.
+	Stop()
+}
+
+type systemClock struct{}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+// SystemClock returns a Clock that uses the standard time package.
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+func (c systemClock) Now() time.Time { return time.Now() }
+
+func (c systemClock) NewTicker(interval time.Duration) Ticker {
+	return systemTicker{time.NewTicker(interval)}
+}
+
+func (t systemTicker) C() <-chan time.Time { return t.ticker.C }
+
+func (t systemTicker) Stop() { t.ticker.Stop() }
+
+func clockOrDefault(clock Clock) Clock {
+	if clock == nil {
+		return systemClock{}
+	}
+	return clock
+}
+
+func unixMillisNow(clock Clock) ldtime.UnixMillisecondTime {
+	return ldtime.UnixMillisFromTime(clock.Now())
+}
@@ -0,0 +1,76 @@
+package ldevents
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+)
+
+// ClockSkewReporter is an optional interface for EventProcessor implementations that estimate the
+// offset between the local clock and the clock of the LaunchDarkly events service. It is
+// implemented by the processors returned by NewDefaultEventProcessor and
+// MultiEnvironmentEventProcessor, and by SynchronousEventProcessor.
+//
+// The estimate is based on the Date header of each successful response from the events service,
+// so it is only available after at least one payload has been delivered. Since that header has a
+// resolution of one second, the estimate is not precise enough to correct small differences, but
+// it can reveal a clock that is minutes off.
+type ClockSkewReporter interface {
+	// ClockSkew returns the estimated amount by which the server's clock is ahead of the local
+	// clock (negative if it is behind), or false if there is no estimate yet.
+	ClockSkew() (time.Duration, bool)
+}
+
+// Each new sample moves the estimate this fraction of the way toward the sample, so that a single
+// slow response does not cause a large jump.
+const clockSkewSmoothingFactor = 0.2
+
+// The Date header is truncated to a whole second, so on average the server's actual time was this
+// much later than what the header says.
+const dateHeaderResolutionMillis = 500
+
+// clockSkewEstimator maintains an exponentially smoothed estimate of the server clock offset. It is
+// updated by the event dispatcher, and may be read from any goroutine.
+type clockSkewEstimator struct {
+	offsetMillis float64
+	hasEstimate  bool
+	lock         sync.Mutex
+}
+
+func (e *clockSkewEstimator) addSample(serverTime, localTime ldtime.UnixMillisecondTime) {
+	sample := float64(serverTime) + dateHeaderResolutionMillis - float64(localTime)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.hasEstimate {
+		e.offsetMillis += clockSkewSmoothingFactor * (sample - e.offsetMillis)
+	} else {
+		e.offsetMillis = sample
+		e.hasEstimate = true
+	}
+}
+
+func (e *clockSkewEstimator) estimate() (time.Duration, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !e.hasEstimate {
+		return 0, false
+	}
+	return time.Duration(math.Round(e.offsetMillis)) * time.Millisecond, true
+}
+
+// adjustTimestamp adds an offset to a timestamp. A zero timestamp means "no time", so it is left
+// alone. If EventsConfiguration.CorrectClockSkew is true, this is applied to the creationDate of
+// events and to the startDate and endDate of summaries, and the estimate is also used to decide
+// when debug mode has expired for a flag.
+func adjustTimestamp(t ldtime.UnixMillisecondTime, offset time.Duration) ldtime.UnixMillisecondTime {
+	if t == 0 || offset == 0 {
+		return t
+	}
+	adjusted := int64(t) + offset.Milliseconds()
+	if adjusted < 1 { // COVERAGE: would require a local clock set to around 1970
+		adjusted = 1
+	}
+	return ldtime.UnixMillisecondTime(adjusted)
+}
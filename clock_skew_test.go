@@ -0,0 +1,192 @@
+package ldevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	m "github.com/launchdarkly/go-test-helpers/v3/matchers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockSkewEstimator(t *testing.T) {
+	var e clockSkewEstimator
+	_, ok := e.estimate()
+	assert.False(t, ok)
+
+	// The first sample is used as is, allowing for the truncation of the Date header
+	e.addSample(fakeTime+10000, fakeTime)
+	offset, ok := e.estimate()
+	assert.True(t, ok)
+	assert.Equal(t, 10500*time.Millisecond, offset)
+
+	// Later samples are smoothed
+	e.addSample(fakeTime+20000, fakeTime)
+	offset, _ = e.estimate()
+	assert.Equal(t, 12500*time.Millisecond, offset)
+
+	e = clockSkewEstimator{}
+	e.addSample(fakeTime-60000, fakeTime)
+	offset, _ = e.estimate()
+	assert.Equal(t, -59500*time.Millisecond, offset)
+}
+
+func TestAdjustTimestamp(t *testing.T) {
+	assert.Equal(t, fakeTime, adjustTimestamp(fakeTime, 0))
+	assert.Equal(t, fakeTime+1500, adjustTimestamp(fakeTime, 1500*time.Millisecond))
+	assert.Equal(t, fakeTime-1500, adjustTimestamp(fakeTime, -1500*time.Millisecond))
+	assert.Equal(t, ldtime.UnixMillisecondTime(0), adjustTimestamp(0, time.Second))
+}
+
+// serverTimeFor returns a value for the Date header of a response that was received when the local
+// clock said localTime, if the server clock is offset by skew.
+func serverTimeFor(localTime ldtime.UnixMillisecondTime, skew time.Duration) ldtime.UnixMillisecondTime {
+	return adjustTimestamp(localTime, skew) - dateHeaderResolutionMillis
+}
+
+func TestClockSkewIsReportedAfterFirstResponse(t *testing.T) {
+	skew := 5 * time.Minute
+
+	t.Run("synchronous", func(t *testing.T) {
+		clock := newTestClock(fakeTime)
+		config := basicConfigWithoutPrivateAttrs()
+		config.Clock = clock
+		ep, es := createSynchronousEventProcessorAndSender(config)
+		defer ep.Close()
+
+		var reporter ClockSkewReporter = ep
+		_, ok := reporter.ClockSkew()
+		assert.False(t, ok)
+
+		es.result = EventSenderResult{Success: true, TimeFromServer: serverTimeFor(fakeTime, skew)}
+		ep.RecordRawEvent(json.RawMessage(`{"kind":"raw"}`))
+		ep.Flush()
+
+		offset, ok := reporter.ClockSkew()
+		assert.True(t, ok)
+		assert.Equal(t, skew, offset)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		clock := newTestClock(fakeTime)
+		config := basicConfigWithoutPrivateAttrs()
+		config.Clock = clock
+		ep, es := createEventProcessorAndSender(config)
+		defer ep.Close()
+
+		reporter, ok := interface{}(ep).(ClockSkewReporter)
+		require.True(t, ok)
+
+		es.result = EventSenderResult{Success: true, TimeFromServer: serverTimeFor(fakeTime, skew)}
+		ep.RecordRawEvent(json.RawMessage(`{"kind":"raw"}`))
+		ep.FlushBlocking(time.Second)
+
+		// The sender result is processed asynchronously after the flush completes
+		require.Eventually(t, func() bool {
+			_, ok := reporter.ClockSkew()
+			return ok
+		}, time.Second, time.Millisecond)
+		offset, _ := reporter.ClockSkew()
+		assert.Equal(t, skew, offset)
+	})
+}
+
+func TestTimestampsAreNotCorrectedByDefault(t *testing.T) {
+	clock := newTestClock(fakeTime)
+	config := basicConfigWithoutPrivateAttrs()
+	config.Clock = clock
+	ep, es := createSynchronousEventProcessorAndSender(config)
+	defer ep.Close()
+
+	es.result = EventSenderResult{Success: true, TimeFromServer: serverTimeFor(fakeTime, time.Minute)}
+	ep.RecordRawEvent(json.RawMessage(`{"kind":"raw"}`))
+	ep.Flush()
+	_ = es.takeEvents()
+
+	ep.RecordIdentifyEvent(NewEventFactoryWithClock(false, clock).NewIdentifyEventData(basicContext(),
+		ldvalue.OptionalInt{}))
+	ep.Flush()
+	m.In(t).Assert(es.takeEvents(), m.Items(
+		m.JSONProperty("creationDate").Should(equalNumericTime(fakeTime)),
+	))
+}
+
+func TestTimestampsAreCorrectedForClockSkew(t *testing.T) {
+	skew := time.Minute
+	clock := newTestClock(fakeTime)
+	config := basicConfigWithoutPrivateAttrs()
+	config.Clock = clock
+	config.CorrectClockSkew = true
+	ep, es := createSynchronousEventProcessorAndSender(config)
+	defer ep.Close()
+
+	factory := NewEventFactoryWithClock(false, clock)
+	flag := FlagEventProperties{Key: "flagkey", Version: 11, RequireFullEvent: true}
+	evaluate := func() {
+		ep.RecordEvaluation(factory.NewEvaluationData(flag, basicContext(), testEvalDetailWithoutReason, false,
+			ldvalue.Null(), "", ldvalue.OptionalInt{}, false))
+		ep.Flush()
+	}
+
+	// No correction is possible until there is an estimate
+	es.result = EventSenderResult{Success: true, TimeFromServer: serverTimeFor(fakeTime, skew)}
+	evaluate()
+	m.In(t).Assert(es.takeEvents(), m.Items(
+		m.JSONProperty("creationDate").Should(equalNumericTime(fakeTime)),
+		m.JSONProperty("creationDate").Should(equalNumericTime(fakeTime)),
+		m.JSONProperty("startDate").Should(equalNumericTime(fakeTime)),
+	))
+
+	ep.ResetContextKeys()
+	evaluate()
+	expected := fakeTime + ldtime.UnixMillisecondTime(skew.Milliseconds())
+	m.In(t).Assert(es.takeEvents(), m.Items(
+		m.AllOf(anyIndexEvent(), m.JSONProperty("creationDate").Should(equalNumericTime(expected))),
+		m.AllOf(eventKindIs("feature"), m.JSONProperty("creationDate").Should(equalNumericTime(expected))),
+		m.AllOf(
+			anySummaryEvent(),
+			m.JSONProperty("startDate").Should(equalNumericTime(expected)),
+			m.JSONProperty("endDate").Should(equalNumericTime(expected)),
+		),
+	))
+}
+
+func TestDebugModeExpiryIsCorrectedForClockSkew(t *testing.T) {
+	// The local clock is ahead of the server clock, so debug mode has expired according to the local
+	// clock but not according to the server.
+	skew := -time.Minute
+	debugUntil := fakeTime + 10000
+	clock := newTestClock(debugUntil + 1000)
+
+	for _, correct := range []bool{false, true} {
+		t.Run(fmt.Sprintf("CorrectClockSkew=%t", correct), func(t *testing.T) {
+			config := basicConfigWithoutPrivateAttrs()
+			config.Clock = clock
+			config.CorrectClockSkew = correct
+			ep, es := createSynchronousEventProcessorAndSender(config)
+			defer ep.Close()
+
+			es.result = EventSenderResult{Success: true, TimeFromServer: serverTimeFor(unixMillisNow(clock), skew)}
+			ep.RecordRawEvent(json.RawMessage(`{"kind":"raw"}`))
+			ep.Flush()
+			_ = es.takeEvents()
+
+			flag := FlagEventProperties{Key: "flagkey", Version: 11, DebugEventsUntilDate: debugUntil}
+			ep.RecordEvaluation(NewEventFactoryWithClock(false, clock).NewEvaluationData(flag, basicContext(),
+				testEvalDetailWithoutReason, false, ldvalue.Null(), "", ldvalue.OptionalInt{}, false))
+			ep.Flush()
+
+			if correct {
+				m.In(t).Assert(es.takeEvents(), m.Items(anyIndexEvent(), eventKindIs("debug"), anySummaryEvent()))
+			} else {
+				m.In(t).Assert(es.takeEvents(), m.Items(anyIndexEvent(), anySummaryEvent()))
+			}
+		})
+	}
+}
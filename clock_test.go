@@ -0,0 +1,211 @@
+package ldevents
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	m "github.com/launchdarkly/go-test-helpers/v3/matchers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClock is a Clock whose time only changes when advance is called. It is similar to
+// ldeventstest.FakeClock, which we can't use here without an import cycle.
+type testClock struct {
+	now             time.Time
+	tickers         []*testTicker
+	tickerCreatedCh chan time.Duration
+	lock            sync.Mutex
+}
+
+type testTicker struct {
+	clock    *testClock
+	ch       chan time.Time
+	interval time.Duration
+	next     time.Time
+	stopped  bool
+}
+
+func newTestClock(now ldtime.UnixMillisecondTime) *testClock {
+	return &testClock{
+		now:             time.UnixMilli(int64(now)),
+		tickerCreatedCh: make(chan time.Duration, 10),
+	}
+}
+
+func (c *testClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *testClock) NewTicker(interval time.Duration) Ticker {
+	c.lock.Lock()
+	t := &testTicker{clock: c, ch: make(chan time.Time), interval: interval, next: c.now.Add(interval)}
+	c.tickers = append(c.tickers, t)
+	c.lock.Unlock()
+	c.tickerCreatedCh <- interval
+	return t
+}
+
+// advance moves the time forward, and sends a tick for each ticker whose interval has elapsed.
+func (c *testClock) advance(d time.Duration) {
+	c.lock.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var due []*testTicker
+	for _, t := range c.tickers {
+		if t.stopped || t.next.After(now) {
+			continue
+		}
+		for !t.next.After(now) {
+			t.next = t.next.Add(t.interval)
+		}
+		due = append(due, t)
+	}
+	c.lock.Unlock()
+	for _, t := range due {
+		// Unlike time.Ticker, wait for the tick to be received, so that the test knows the event
+		// processor has seen it before doing anything else.
+		select {
+		case t.ch <- now:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// awaitTickers waits until the event processor's main loop has created its tickers.
+func (c *testClock) awaitTickers(t *testing.T, count int) {
+	for i := 0; i < count; i++ {
+		select {
+		case <-c.tickerCreatedCh:
+		case <-time.After(time.Second):
+			require.Fail(t, "timed out waiting for tickers to be created")
+		}
+	}
+}
+
+func (t *testTicker) C() <-chan time.Time { return t.ch }
+
+func (t *testTicker) Stop() {
+	t.clock.lock.Lock()
+	t.stopped = true
+	t.clock.lock.Unlock()
+}
+
+func TestSystemClock(t *testing.T) {
+	clock := SystemClock()
+	before := time.Now()
+	now := clock.Now()
+	assert.False(t, now.Before(before))
+
+	ticker := clock.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	select {
+	case <-ticker.C():
+	case <-time.After(time.Second):
+		assert.Fail(t, "timed out waiting for tick")
+	}
+}
+
+func TestEventFactoryWithClock(t *testing.T) {
+	clock := newTestClock(fakeTime)
+	factory := NewEventFactoryWithClock(false, clock)
+	assert.Equal(t, fakeTime, factory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}).CreationDate)
+
+	clock.advance(time.Second)
+	assert.Equal(t, fakeTime+1000, factory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}).CreationDate)
+
+	assert.NotEqual(t, ldtime.UnixMillisecondTime(0),
+		NewEventFactoryWithClock(false, nil).NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}).CreationDate)
+}
+
+func TestEventProcessorFlushesWhenClockTicks(t *testing.T) {
+	clock := newTestClock(fakeTime)
+	config := basicConfigWithoutPrivateAttrs()
+	config.Clock = clock
+	config.FlushInterval = time.Minute
+	ep, es := createEventProcessorAndSender(config)
+	defer ep.Close()
+	clock.awaitTickers(t, 2)
+
+	ep.RecordIdentifyEvent(NewEventFactoryWithClock(false, clock).NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}))
+	clock.advance(time.Second)
+	es.assertNoMoreEvents(t)
+
+	clock.advance(time.Minute)
+	m.In(t).Assert(es.awaitEvent(t), m.AllOf(
+		eventKindIs("identify"),
+		m.JSONProperty("creationDate").Should(equalNumericTime(fakeTime)),
+	))
+}
+
+func TestEventProcessorResetsContextKeysWhenClockTicks(t *testing.T) {
+	clock := newTestClock(fakeTime)
+	config := basicConfigWithoutPrivateAttrs()
+	config.Clock = clock
+	config.UserKeysFlushInterval = time.Minute
+	ep, es := createEventProcessorAndSender(config)
+	defer ep.Close()
+	clock.awaitTickers(t, 2)
+
+	factory := NewEventFactoryWithClock(false, clock)
+	event := factory.NewCustomEventData("event", basicContext(), ldvalue.Null(), false, 0, ldvalue.OptionalInt{})
+	ep.RecordCustomEvent(event)
+	ep.RecordCustomEvent(event)
+	ep.FlushBlocking(time.Second)
+	m.In(t).Assert(es.takeEvents(), m.Items(anyIndexEvent(), anyCustomEvent(), anyCustomEvent()))
+
+	clock.advance(time.Minute)
+	ep.RecordCustomEvent(event)
+	ep.FlushBlocking(time.Second)
+	m.In(t).Assert(es.takeEvents(), m.Items(anyIndexEvent(), anyCustomEvent()))
+}
+
+func TestEventProcessorSendsPeriodicDiagnosticEventWhenClockTicks(t *testing.T) {
+	clock := newTestClock(fakeTime)
+	config := basicConfigWithoutPrivateAttrs()
+	config.Clock = clock
+	config.DiagnosticRecordingInterval = MinimumDiagnosticRecordingInterval
+	config.DiagnosticsManager = NewDiagnosticsManagerWithClock(NewDiagnosticID("sdkkey"), ldvalue.Null(), ldvalue.Null(),
+		clock)
+	ep, es := createEventProcessorAndSender(config)
+	defer ep.Close()
+	clock.awaitTickers(t, 3)
+	m.In(t).Assert(es.awaitDiagnosticEvent(t), eventKindIs("diagnostic-init"))
+
+	clock.advance(MinimumDiagnosticRecordingInterval)
+	m.In(t).Assert(es.awaitDiagnosticEvent(t), m.AllOf(
+		eventKindIs("diagnostic"),
+		m.JSONProperty("creationDate").Should(equalNumericTime(fakeTime+60000)),
+		m.JSONProperty("dataSinceDate").Should(equalNumericTime(fakeTime)),
+	))
+}
+
+func TestDebugModeExpiresAccordingToClock(t *testing.T) {
+	clock := newTestClock(fakeTime)
+	config := basicConfigWithoutPrivateAttrs()
+	config.Clock = clock
+	ep, es := createSynchronousEventProcessorAndSender(config)
+	defer ep.Close()
+
+	factory := NewEventFactoryWithClock(false, clock)
+	flag := FlagEventProperties{Key: "flagkey", Version: 11, DebugEventsUntilDate: fakeTime + 1000}
+	evaluate := func() {
+		ep.RecordEvaluation(factory.NewEvaluationData(flag, basicContext(), testEvalDetailWithoutReason, false,
+			ldvalue.Null(), "", ldvalue.OptionalInt{}, false))
+		ep.Flush()
+	}
+
+	evaluate()
+	m.In(t).Assert(es.takeEvents(), m.Items(anyIndexEvent(), eventKindIs("debug"), anySummaryEvent()))
+
+	clock.advance(time.Second)
+	evaluate()
+	m.In(t).Assert(es.takeEvents(), m.Items(anySummaryEvent()))
+}
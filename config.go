@@ -4,8 +4,8 @@ import (
 	"time"
 
 	"github.com/launchdarkly/go-sdk-common/v3/ldattr"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
 	"github.com/launchdarkly/go-sdk-common/v3/ldlog"
-	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
 )
 
 // DefaultDiagnosticRecordingInterval is the default value for EventsConfiguration.DiagnosticRecordingInterval.
@@ -20,6 +20,19 @@ const DefaultFlushInterval = 5 * time.Second
 // DefaultUserKeysFlushInterval is the default value for EventsConfiguration.UserKeysFlushInterval.
 const DefaultUserKeysFlushInterval = 5 * time.Minute
 
+// KindPrivateAttributes contains private attribute settings for one context kind. See
+// EventsConfiguration.PrivateAttributesByKind.
+type KindPrivateAttributes struct {
+	// If true, all attributes (other than the key) of contexts of this kind are private.
+	AllAttributesPrivate bool
+	// Attribute references that should be considered private for contexts of this kind, in the same
+	// format as EventsConfiguration.PrivateAttributes.
+	PrivateAttributes []ldattr.Ref
+	// If not empty, this allowlist is used for contexts of this kind instead of
+	// EventsConfiguration.AllowedAttributes.
+	AllowedAttributes []ldattr.Ref
+}
+
 // EventsConfiguration contains options affecting the behavior of the events engine.
 type EventsConfiguration struct {
 	// Sets whether or not all user attributes (other than the key) should be hidden from LaunchDarkly. If this
@@ -43,15 +56,89 @@ type EventsConfiguration struct {
 	// True if user keys can be included in log messages.
 	LogUserKeyInErrors bool
 	// PrivateAttributes is a list of attribute references (either simple names, or slash-delimited
-	// paths) that should be considered private.
+	// paths) that should be considered private. A path component can be an array index, or "*" for
+	// every element of an array.
 	PrivateAttributes []ldattr.Ref
+	// AttributeTransforms designate attributes whose values should be disguised, for instance with
+	// NewHMACSHA256Transform, rather than removed. The output context lists them in the
+	// "transformedAttributes" of its "_meta" property.
+	AttributeTransforms []PrivateAttributeTransform
+	// AllowedAttributes, if not empty, designates the only attributes (other than the key, kind, and
+	// anonymous attributes) that are included in output contexts; any others are redacted. See also
+	// KindPrivateAttributes.AllowedAttributes.
+	AllowedAttributes []ldattr.Ref
+	// PrivateAttributesByKind contains additional private attribute settings for individual contexts
+	// of particular kinds. They add to AllAttributesPrivate and PrivateAttributes, rather than
+	// replacing them.
+	PrivateAttributesByKind map[ldcontext.Kind]KindPrivateAttributes
+	// If greater than zero, string values in output contexts, other than the key and kind, are
+	// truncated to this many bytes. See ContextLimitReporter.
+	ContextMaxStringLength int
+	// If greater than zero, object and array values in output contexts are limited to this many
+	// properties or elements. See ContextLimitReporter.
+	ContextMaxCollectionSize int
+	// If greater than zero, the maximum size in bytes of the JSON for each individual context in an
+	// output event. Top-level attributes are dropped, largest first, to fit. See ContextLimitReporter.
+	ContextMaxSize int
+	// PIIDetectors, if not empty, are checked against the string values in output contexts, and any
+	// value that one of them matches is redacted as if it were private. See DefaultPIIDetectors.
+	PIIDetectors []PIIDetector
+	// PIIDetectionHandler, if not nil, is called for each value that PIIDetectors matched. It must be
+	// safe for concurrent use.
+	PIIDetectionHandler func(PIIDetection)
+	// If true, contexts that were given to PreserializedContext are subject to the same private
+	// attribute rules as other contexts. An event whose context cannot be parsed is discarded.
+	RedactPreserializedContexts bool
 	// The number of user keys that the event processor can remember at any one time, so that
 	// duplicate user details will not be sent in analytics events.
 	UserKeysCapacity int
 	// The interval at which the event processor will reset its set of known user keys.
 	UserKeysFlushInterval time.Duration
-	// Used in testing to instrument the current time.
-	currentTimeProvider func() ldtime.UnixMillisecondTime
+	// If true, the timestamps in output events are adjusted by the estimated offset between the local
+	// clock and the clock of the LaunchDarkly events service. See ClockSkewReporter.
+	CorrectClockSkew bool
+	// If true, each flag in a summary event also counts its evaluations by reason kind, and errors by
+	// error kind. Only evaluations whose EvaluationData has a Reason are counted.
+	SummarizeReasons bool
+	// If true, each counter in a summary event also has an estimate of how many distinct contexts
+	// were counted (contextCount), and the HyperLogLog sketch it came from (contextSketch).
+	SummarizeUniqueContexts bool
+	// The maximum number of distinct flags in a summary event; zero means no limit. Evaluations of
+	// other flags during the same flush interval are not in any summary event, but are counted by
+	// SummaryOverflowReporter and in the summaryOverflowEvaluations of periodic diagnostic events.
+	SummaryFlagsCapacity int
+	// The maximum number of counters (distinct combinations of variation and flag version) for each
+	// flag in a summary event; zero means no limit. Evaluations that would need another counter are
+	// left out and counted in the same way as for SummaryFlagsCapacity.
+	SummaryCountersPerFlagCapacity int
+	// If greater than zero, evaluations are summarized separately for each window of this length, so
+	// that a flush can produce several summary events.
+	SummaryBucketInterval time.Duration
+	// If true, any summary event passed to RecordRawEvent is merged into the processor's own summary
+	// data, rather than being delivered as it is.
+	MergeRawSummaryEvents bool
+	// Keys of custom events whose metric values are aggregated into a MetricSummary, rather than sent
+	// in individual custom events. This has no effect unless OnMetricSummary is set or
+	// SendMetricSummaryEvents is true.
+	AggregatedMetricEventKeys []string
+	// The upper bounds of the histogram buckets for AggregatedMetricEventKeys. If empty, the bounds
+	// are 1, 2, 5, 10, 20, 50, and so on up to 100000.
+	MetricHistogramBounds []float64
+	// If non-nil, this is called with the aggregated data for AggregatedMetricEventKeys whenever
+	// events are flushed.
+	OnMetricSummary func(MetricSummary)
+	// If true, the aggregated data for AggregatedMetricEventKeys is also delivered in an event of kind
+	// MetricSummaryEventKind, which the LaunchDarkly events service does not currently accept.
+	SendMetricSummaryEvents bool
+	// If non-nil, every migration operation event is also passed to this aggregator, regardless of
+	// its sampling ratio. The events are still delivered as usual.
+	MigrationOpAggregator *MigrationOpAggregator
+	// If true, the JSON output is canonical: anything whose order would otherwise depend on map
+	// iteration order is sorted, so that identical events always produce identical bytes.
+	CanonicalOutput bool
+	// The source of the current time, and of the timers for periodic tasks. If nil, SystemClock is
+	// used.
+	Clock Clock
 	// Used in testing to set a DiagnosticRecordingInterval that is less than the minimum.
 	forceDiagnosticRecordingInterval time.Duration
 	// Used in testing to override event sampling rules
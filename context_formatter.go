@@ -1,6 +1,10 @@
 package ldevents
 
 import (
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/launchdarkly/go-sdk-common/v3/ldattr"
 	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
 	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
@@ -14,24 +18,86 @@ import (
 type eventContextFormatter struct {
 	allAttributesPrivate bool
 	privateAttributes    map[string]*privateAttrLookupNode
+	allowedAttributes    map[string]*privateAttrLookupNode // nil unless the allowlist is being used
+	attributeTransforms  map[string]*privateAttrLookupNode
+	sorted               bool // see EventsConfiguration.CanonicalOutput
+	limits               contextLimits
+	limitStats           *contextLimitStats // nil if there are no limits
+	piiDetectors         []PIIDetector
+	piiDetectionHandler  func(PIIDetection)
+	redactPreserialized  bool // see EventsConfiguration.RedactPreserializedContexts
+	// kindFormatters has a formatter for each context kind in EventsConfiguration.PrivateAttributesByKind,
+	// whose configuration combines the global private attributes with those for the kind.
+	kindFormatters map[ldcontext.Kind]*eventContextFormatter
 }
 
 type privateAttrLookupNode struct {
 	attribute *ldattr.Ref
+	transform AttributeTransform // only used in attributeTransforms
 	children  map[string]*privateAttrLookupNode
 }
 
+// contextMeta accumulates the contents of the "_meta" property of an output context.
+type contextMeta struct {
+	redactedAttrs    []string
+	transformedAttrs []transformedAttr
+	truncatedAttrs   []string
+	droppedAttrs     []string
+	detectedPII      []PIIDetection // not written to "_meta", but passed to the PIIDetectionHandler
+}
+
+type transformedAttr struct {
+	path      string // in the same format as redactedAttrs
+	transform string
+}
+
 // newEventContextFormatter creates an eventContextFormatter.
 //
 // An instance of this type is owned by the eventOutputFormatter that is responsible for writing all
 // JSON event data. It is created at SDK initialization time based on the SDK configuration.
 func newEventContextFormatter(config EventsConfiguration) eventContextFormatter {
-	ret := eventContextFormatter{allAttributesPrivate: config.AllAttributesPrivate}
+	ret := eventContextFormatter{
+		allAttributesPrivate: config.AllAttributesPrivate,
+		sorted:               config.CanonicalOutput,
+		piiDetectors:         config.PIIDetectors,
+		piiDetectionHandler:  config.PIIDetectionHandler,
+		redactPreserialized:  config.RedactPreserializedContexts,
+		limits: contextLimits{
+			maxStringLength:   config.ContextMaxStringLength,
+			maxCollectionSize: config.ContextMaxCollectionSize,
+			maxContextSize:    config.ContextMaxSize,
+		},
+	}
+	if ret.limits.any() {
+		ret.limitStats = &contextLimitStats{}
+	}
 	if len(config.PrivateAttributes) != 0 {
 		// Reformat the list of private attributes into a map structure that will allow
 		// for faster lookups.
 		ret.privateAttributes = makePrivateAttrLookupData(config.PrivateAttributes)
 	}
+	if len(config.AllowedAttributes) != 0 {
+		ret.allowedAttributes = makePrivateAttrLookupData(config.AllowedAttributes)
+	}
+	if len(config.AttributeTransforms) != 0 {
+		ret.attributeTransforms = makeAttributeTransformLookupData(config.AttributeTransforms)
+	}
+	if len(config.PrivateAttributesByKind) != 0 {
+		ret.kindFormatters = make(map[ldcontext.Kind]*eventContextFormatter, len(config.PrivateAttributesByKind))
+		for kind, kindAttrs := range config.PrivateAttributesByKind {
+			kindConfig := config
+			kindConfig.PrivateAttributesByKind = nil
+			kindConfig.AllAttributesPrivate = config.AllAttributesPrivate || kindAttrs.AllAttributesPrivate
+			kindConfig.PrivateAttributes = append(append([]ldattr.Ref(nil), config.PrivateAttributes...),
+				kindAttrs.PrivateAttributes...)
+			if len(kindAttrs.AllowedAttributes) != 0 {
+				kindConfig.AllowedAttributes = kindAttrs.AllowedAttributes
+			}
+			kindFormatter := newEventContextFormatter(kindConfig)
+			kindFormatter.limitStats = ret.limitStats
+			ret.kindFormatters[kind] = &kindFormatter
+		}
+	}
 	return ret
 }
 
@@ -66,18 +132,39 @@ func makePrivateAttrLookupData(attrRefList []ldattr.Ref) map[string]*privateAttr
 			nextNode := (*parentMap)[name]
 			if nextNode == nil {
 				nextNode = &privateAttrLookupNode{}
-				if i == a.Depth()-1 {
-					aa := a
-					nextNode.attribute = &aa
-				}
 				(*parentMap)[name] = nextNode
 			}
+			if i == a.Depth()-1 && nextNode.attribute == nil {
+				aa := a
+				nextNode.attribute = &aa
+			}
 			parentMap = &nextNode.children
 		}
 	}
 	return ret
 }
 
+// makeAttributeTransformLookupData is like makePrivateAttrLookupData, except that each node for one
+// of the attribute references also has its transform. If there is more than one transform for the
+// same reference, the first one is used.
+func makeAttributeTransformLookupData(transforms []PrivateAttributeTransform) map[string]*privateAttrLookupNode {
+	attrRefList := make([]ldattr.Ref, 0, len(transforms))
+	for _, t := range transforms {
+		attrRefList = append(attrRefList, t.Attribute)
+	}
+	ret := makePrivateAttrLookupData(attrRefList)
+	for _, t := range transforms {
+		node := &privateAttrLookupNode{children: ret}
+		for i := 0; i < t.Attribute.Depth(); i++ {
+			node = node.children[t.Attribute.Component(i)]
+		}
+		if node.transform == nil {
+			node.transform = t.Transform
+		}
+	}
+	return ret
+}
+
 // WriteContext serializes a Context in the format appropriate for an analytics event, redacting
 // private attributes if necessary.
 func (f *eventContextFormatter) WriteContext(w *jwriter.Writer, ec *EventInputContext) {
@@ -93,7 +180,7 @@ func (f *eventContextFormatter) WriteContextRedactAnonymous(w *jwriter.Writer, e
 
 func (f *eventContextFormatter) writeContext(w *jwriter.Writer, ec *EventInputContext, redactAnonymous bool) {
 	if ec.preserialized != nil {
-		w.Raw(ec.preserialized)
+		f.writePreserializedContext(w, ec.preserialized, redactAnonymous)
 		return
 	}
 	if ec.context.Err() != nil {
@@ -101,18 +188,45 @@ func (f *eventContextFormatter) writeContext(w *jwriter.Writer, ec *EventInputCo
 		return
 	}
 	if ec.context.Multiple() {
-		f.writeContextInternalMulti(w, ec, redactAnonymous)
+		f.writeContextInternalMulti(w, &ec.context, redactAnonymous, false)
 	} else {
-		f.writeContextInternalSingle(w, &ec.context, true, redactAnonymous)
+		f.writeContextInternalSingle(w, &ec.context, true, redactAnonymous, false)
 	}
 }
 
+// writeContextInternalSingle writes an individual context. If preRedacted is true, the context was
+// parsed from a preserialized context in the event schema, so its private attributes are the ones
+// that were already redacted; see writePreserializedContext.
 func (f *eventContextFormatter) writeContextInternalSingle(
 	w *jwriter.Writer,
 	c *ldcontext.Context,
 	includeKind,
-	redactAnonymous bool,
+	redactAnonymous,
+	preRedacted bool,
 ) {
+	if kindFormatter := f.kindFormatters[c.Kind()]; kindFormatter != nil {
+		f = kindFormatter
+	}
+	var meta contextMeta
+	if f.limits.maxContextSize > 0 {
+		meta = f.writeContextWithSizeLimit(w, c, includeKind, redactAnonymous, preRedacted)
+	} else {
+		meta = f.writeContextAttributes(w, c, includeKind, redactAnonymous, preRedacted, nil)
+	}
+	f.limitStats.record(meta)
+	f.reportPII(c, meta)
+}
+
+// writeContextAttributes does the work of writeContextInternalSingle, leaving out any top-level
+// attributes that are in dropped, and returns what it wrote to the "_meta" property.
+func (f *eventContextFormatter) writeContextAttributes(
+	w *jwriter.Writer,
+	c *ldcontext.Context,
+	includeKind,
+	redactAnonymous,
+	preRedacted bool,
+	dropped map[string]struct{},
+) contextMeta {
 	redactAll := f.allAttributesPrivate || (c.Anonymous() && redactAnonymous)
 	obj := w.Object()
 	if includeKind {
@@ -122,9 +236,18 @@ func (f *eventContextFormatter) writeContextInternalSingle(
 	obj.Name(ldattr.KeyAttr).String(c.Key())
 
 	optionalAttrNames := make([]string, 0, 50) // arbitrary capacity, expanded if necessary by GetOptionalAttributeNames
-	redactedAttrs := make([]string, 0, 20)
+	meta := contextMeta{redactedAttrs: make([]string, 0, 20)}
+	if preRedacted {
+		for i := 0; i < c.PrivateAttributeCount(); i++ {
+			a, _ := c.PrivateAttributeByIndex(i)
+			meta.redactedAttrs = append(meta.redactedAttrs, a.String())
+		}
+	}
 
 	optionalAttrNames = c.GetOptionalAttributeNames(optionalAttrNames)
+	if f.sorted {
+		sort.Strings(optionalAttrNames)
+	}
 
 	for _, key := range optionalAttrNames {
 		if value := c.GetValue(key); value.IsDefined() {
@@ -135,11 +258,15 @@ func (f *eventContextFormatter) writeContextInternalSingle(
 				// this individual attribute happens to be something like "/a/b"; the easiest way to do that is to
 				// call NewLiteralRef and then convert the Ref to an attribute reference string.
 				escapedAttrName := ldattr.NewLiteralRef(key).String()
-				redactedAttrs = append(redactedAttrs, escapedAttrName)
+				meta.redactedAttrs = append(meta.redactedAttrs, escapedAttrName)
 				continue
 			}
-			path := make([]string, 0, 10)
-			f.writeFilteredAttribute(w, c, &obj, path, key, value, &redactedAttrs)
+			if _, ok := dropped[key]; ok {
+				meta.droppedAttrs = append(meta.droppedAttrs, ldattr.NewLiteralRef(key).String())
+				continue
+			}
+			path := make([]attrPathComponent, 0, 10)
+			f.writeFilteredAttribute(w, c, &obj, path, key, value, &meta)
 		}
 	}
 
@@ -147,37 +274,135 @@ func (f *eventContextFormatter) writeContextInternalSingle(
 		obj.Name(ldattr.AnonymousAttr).Bool(true)
 	}
 
-	anyRedacted := len(redactedAttrs) != 0
-	if anyRedacted {
-		metaJSON := obj.Name("_meta").Object()
-		privateAttrsJSON := metaJSON.Name("redactedAttributes").Array()
-		for _, a := range redactedAttrs {
-			privateAttrsJSON.String(a)
-		}
-		privateAttrsJSON.End()
-		metaJSON.End()
+	if preRedacted {
+		// The values of the attributes that were already redacted are not in the context, but if it
+		// had any anyway, they were redacted again.
+		meta.redactedAttrs = removeDuplicateStrings(meta.redactedAttrs)
+	}
+
+	if !meta.isEmpty() {
+		meta.write(obj.Name("_meta"), f.sorted)
 	}
 
 	obj.End()
+	return meta
+}
+
+func (m *contextMeta) isEmpty() bool {
+	return len(m.redactedAttrs) == 0 && len(m.transformedAttrs) == 0 && len(m.truncatedAttrs) == 0 &&
+		len(m.droppedAttrs) == 0
+}
+
+func (m *contextMeta) write(w *jwriter.Writer, sorted bool) {
+	if sorted {
+		sort.Slice(m.transformedAttrs, func(i, j int) bool {
+			return m.transformedAttrs[i].path < m.transformedAttrs[j].path
+		})
+	}
+	metaJSON := w.Object()
+	writeAttrList(&metaJSON, "redactedAttributes", m.redactedAttrs, sorted)
+	if len(m.transformedAttrs) != 0 {
+		transformedAttrsJSON := metaJSON.Name("transformedAttributes").Object()
+		for _, a := range m.transformedAttrs {
+			transformedAttrsJSON.Name(a.path).String(a.transform)
+		}
+		transformedAttrsJSON.End()
+	}
+	writeAttrList(&metaJSON, "truncatedAttributes", m.truncatedAttrs, sorted)
+	writeAttrList(&metaJSON, "droppedAttributes", m.droppedAttrs, sorted)
+	metaJSON.End()
 }
 
-func (f *eventContextFormatter) writeContextInternalMulti(w *jwriter.Writer, ec *EventInputContext, redactAnonymous bool) {
+func writeAttrList(obj *jwriter.ObjectState, name string, attrs []string, sorted bool) {
+	if len(attrs) == 0 {
+		return
+	}
+	if sorted {
+		sort.Strings(attrs)
+	}
+	arr := obj.Name(name).Array()
+	for _, a := range attrs {
+		arr.String(a)
+	}
+	arr.End()
+}
+
+func (f *eventContextFormatter) writeContextInternalMulti(
+	w *jwriter.Writer,
+	c *ldcontext.Context,
+	redactAnonymous,
+	preRedacted bool,
+) {
 	obj := w.Object()
 	obj.Name(ldattr.KindAttr).String(string(ldcontext.MultiKind))
 
-	for i := 0; i < ec.context.IndividualContextCount(); i++ {
-		if ic := ec.context.IndividualContextByIndex(i); ic.IsDefined() {
+	for i := 0; i < c.IndividualContextCount(); i++ {
+		if ic := c.IndividualContextByIndex(i); ic.IsDefined() {
 			obj.Name(string(ic.Kind()))
-			f.writeContextInternalSingle(w, &ic, false, redactAnonymous)
+			f.writeContextInternalSingle(w, &ic, false, redactAnonymous, preRedacted)
 		}
 	}
 
 	obj.End()
 }
 
+// attrPathComponent is one step of the path to a value within a context: either the name of an
+// attribute or object property, or the index of an array element.
+type attrPathComponent struct {
+	name       string
+	arrayIndex bool
+}
+
+// privateAttrWildcard is an attribute reference component that matches every element of an array.
+// For instance, "/addresses/*/street" designates the street property of each element of an array
+// of addresses. When it is applied to an object rather than an array, it only matches a property
+// whose name is literally "*". The redactedAttributes of an output context list the actual paths
+// that were redacted, such as "/addresses/0/street".
+const privateAttrWildcard = "*"
+
+// matches returns true if a component of an attribute reference designates this path component.
+func (p attrPathComponent) matches(refComponent string) bool {
+	return refComponent == p.name || (p.arrayIndex && refComponent == privateAttrWildcard)
+}
+
+// redactedPathString returns the string that is added to the redactedAttributes list when a
+// private attribute reference ref has matched attrPath.
+//
+// If attrPath does not go through an array, this is the string form of ref, just as it was
+// specified, so for instance "name" rather than "/name". If it does, then ref may have matched
+// more than one value, so instead we report the actual path of the value that was redacted: the
+// slash-delimited attribute reference syntax, with decimal array indices, such as
+// "/addresses/0/street". Indices refer to the original array, even if other elements before that
+// one were also redacted.
+func redactedPathString(ref ldattr.Ref, attrPath []attrPathComponent) string {
+	throughArray := false
+	for _, p := range attrPath {
+		throughArray = throughArray || p.arrayIndex
+	}
+	if !throughArray {
+		return ref.String()
+	}
+	return attrPathString(attrPath)
+}
+
+// attrPathString returns the path to a value as an attribute reference string: either the plain
+// attribute name, escaped if necessary, or a slash-delimited path such as "/address/street".
+func attrPathString(attrPath []attrPathComponent) string {
+	if len(attrPath) == 1 && !attrPath[0].arrayIndex {
+		return ldattr.NewLiteralRef(attrPath[0].name).String()
+	}
+	var b strings.Builder
+	for _, p := range attrPath {
+		b.WriteRune('/')
+		b.WriteString(strings.ReplaceAll(strings.ReplaceAll(p.name, "~", "~0"), "/", "~1"))
+	}
+	return b.String()
+}
+
 // writeFilteredAttribute checks whether a given value should be considered private, and then
 // either writes the attribute to the output JSON object if it is *not* private, or adds the
-// corresponding attribute reference to the redactedAttrs list if it is private.
+// corresponding attribute reference to the redactedAttrs list if it is private. If it has a
+// transform instead, the transformed value is written; see filterValue.
 //
 // The parentPath parameter indicates where we are in the context data structure. If it is empty,
 // we are at the top level and "key" is an attribute name. If it is not empty, we are recursing
@@ -191,85 +416,191 @@ func (f *eventContextFormatter) writeFilteredAttribute(
 	w *jwriter.Writer,
 	c *ldcontext.Context,
 	parentObj *jwriter.ObjectState,
-	parentPath []string,
+	parentPath []attrPathComponent,
 	key string,
 	value ldvalue.Value,
-	redactedAttrs *[]string,
+	meta *contextMeta,
 ) {
-	path := append(parentPath, key) //nolint:gocritic // purposely not assigning to same slice
+	path := append(parentPath, attrPathComponent{name: key}) //nolint:gocritic // purposely not assigning to same slice
 
-	isRedacted, nestedPropertiesAreRedacted := f.maybeRedact(c, path, value.Type(), redactedAttrs)
+	if value, ok, nestedPropertiesAreRedacted := f.filterValue(c, path, value, meta); ok {
+		parentObj.Name(key)
+		f.writeFilteredValue(w, c, path, value, nestedPropertiesAreRedacted, meta)
+	}
+}
 
-	if value.Type() != ldvalue.ObjectType {
-		// For all value types except object, the question is only "is there a private attribute
-		// reference that directly points to this property", since there are no nested properties.
-		if !isRedacted {
-			parentObj.Name(key)
-			value.WriteToJSONWriter(w)
-		}
-		return
+// writeFilteredArrayElement is the same as writeFilteredAttribute, but for an element of an array
+// value. A redacted element is omitted from the output array.
+func (f *eventContextFormatter) writeFilteredArrayElement(
+	w *jwriter.Writer,
+	c *ldcontext.Context,
+	parentPath []attrPathComponent,
+	index int,
+	value ldvalue.Value,
+	meta *contextMeta,
+) {
+	path := append(parentPath, attrPathComponent{name: strconv.Itoa(index), arrayIndex: true}) //nolint:gocritic
+
+	if value, ok, nestedPropertiesAreRedacted := f.filterValue(c, path, value, meta); ok {
+		f.writeFilteredValue(w, c, path, value, nestedPropertiesAreRedacted, meta)
 	}
+}
 
-	// If the value is an object, then there are three possible outcomes: 1. this value is
-	// completely redacted, so drop it and do not recurse; 2. the value is not redacted, and
-	// and neither are any subproperties within it, so output the whole thing as-is; 3. the
-	// value itself is not redacted, but some subproperties within it are, so we'll need to
-	// recurse through it and filter as we go.
+// filterValue calls maybeRedact, and then applies the transform for the value if there is one. It
+// returns the value to write, false if nothing should be written, and whether there are private
+// attribute references within the value. A transform that fails redacts the value.
+//
+// If there are PII detectors, a string value that is not otherwise redacted or transformed is
+// redacted if any of them matches it, and an object or array value is treated as if there were
+// private attribute references within it, so that every string within it is checked in turn.
+func (f *eventContextFormatter) filterValue(
+	c *ldcontext.Context,
+	path []attrPathComponent,
+	value ldvalue.Value,
+	meta *contextMeta,
+) (ldvalue.Value, bool, bool) {
+	isRedacted, transformNode, nestedPropertiesAreRedacted := f.maybeRedact(c, path, value.Type(), meta)
 	if isRedacted {
-		return // outcome 1
+		return value, false, false
 	}
-	parentObj.Name(key)
-	if !nestedPropertiesAreRedacted {
-		value.WriteToJSONWriter(w) // writes the whole value unchanged
-		return                     // outcome 2
+	if transformNode == nil && f.piiDetectors != nil {
+		switch value.Type() {
+		case ldvalue.StringType:
+			if detector := f.detectPII(value.StringValue()); detector != "" {
+				pathString := attrPathString(path)
+				meta.redactedAttrs = append(meta.redactedAttrs, pathString)
+				meta.detectedPII = append(meta.detectedPII, PIIDetection{Attribute: pathString, Detector: detector})
+				return value, false, false
+			}
+		case ldvalue.ObjectType, ldvalue.ArrayType:
+			nestedPropertiesAreRedacted = true
+		}
+	}
+	if transformNode == nil {
+		if !nestedPropertiesAreRedacted && f.limits.any() {
+			value = f.limitValue(path, value, meta)
+		}
+		return value, true, nestedPropertiesAreRedacted
+	}
+	pathString := redactedPathString(*transformNode.attribute, path)
+	transformed, ok := transformNode.transform.Transform(value)
+	if !ok {
+		meta.redactedAttrs = append(meta.redactedAttrs, pathString)
+		return value, false, false
+	}
+	meta.transformedAttrs = append(meta.transformedAttrs,
+		transformedAttr{path: pathString, transform: transformNode.transform.Name()})
+	if f.limits.any() {
+		transformed = f.limitValue(path, transformed, meta)
 	}
-	subObj := w.Object() // writes the opening brace for the output object
+	return transformed, true, false
+}
 
-	objectKeys := make([]string, 0, 50) // arbitrary capacity, expanded if necessary by value.Keys()
-	for _, subKey := range value.Keys(objectKeys) {
-		subValue := value.GetByKey(subKey)
-		// recurse to write or not write each property - outcome 3
-		f.writeFilteredAttribute(w, c, &subObj, path, subKey, subValue, redactedAttrs)
+// writeFilteredValue writes a value that is not itself redacted.
+//
+// For all value types except object and array, there are no nested properties, so we can just
+// write it. If the value is an object or an array, then there are two possible outcomes: 1. the
+// value is not redacted, and neither are any properties or elements within it, so output the
+// whole thing as-is; 2. some properties or elements within it are redacted, so we'll need to
+// recurse through it and filter as we go.
+func (f *eventContextFormatter) writeFilteredValue(
+	w *jwriter.Writer,
+	c *ldcontext.Context,
+	path []attrPathComponent,
+	value ldvalue.Value,
+	nestedPropertiesAreRedacted bool,
+	meta *contextMeta,
+) {
+	if !nestedPropertiesAreRedacted {
+		writeValue(w, value, f.sorted) // writes the whole value unchanged
+		return                         // outcome 1
+	}
+	switch value.Type() {
+	case ldvalue.ObjectType:
+		subObj := w.Object()                // writes the opening brace for the output object
+		objectKeys := make([]string, 0, 50) // arbitrary capacity, expanded if necessary by value.Keys()
+		objectKeys = value.Keys(objectKeys)
+		if f.limits.maxCollectionSize > 0 && len(objectKeys) > f.limits.maxCollectionSize {
+			// keep the properties whose names sort first; see limitValue
+			sort.Strings(objectKeys)
+			objectKeys = objectKeys[:f.limitCollectionSize(path, len(objectKeys), meta)]
+		} else if f.sorted {
+			sort.Strings(objectKeys)
+		}
+		for _, subKey := range objectKeys {
+			subValue := value.GetByKey(subKey)
+			// recurse to write or not write each property - outcome 2
+			f.writeFilteredAttribute(w, c, &subObj, path, subKey, subValue, meta)
+		}
+		subObj.End() // writes the closing brace for the output object
+	case ldvalue.ArrayType:
+		arr := w.Array()
+		count := f.limitCollectionSize(path, value.Count(), meta)
+		for i := 0; i < count; i++ {
+			// recurse to write or not write each element - outcome 2
+			f.writeFilteredArrayElement(w, c, path, i, value.GetByIndex(i), meta)
+		}
+		arr.End()
+	default:
+		// A private attribute reference can designate a property within a value that turns out not
+		// to be an object or array, in which case there is nothing to filter.
+		writeValue(w, f.limitValue(path, value, meta), f.sorted)
 	}
-	subObj.End() // writes the closing brace for the output object
 }
 
-// maybeRedact is called by writeFilteredAttribute to decide whether or not a given value (or,
-// possibly, properties within it) should be considered private, based on the private attribute
-// references in either 1. the eventContextFormatter configuration or 2. this specific Context.
+// maybeRedact is called by writeFilteredAttribute and writeFilteredArrayElement to decide whether
+// or not a given value (or, possibly, properties within it) should be considered private, based on
+// the private attribute references in either 1. the eventContextFormatter configuration or 2. this
+// specific Context.
 //
 // If the value should be private, then the first return value is true, and also the attribute
-// reference is added to redactedAttrs.
+// reference is added to redactedAttrs (see redactedPathString).
+//
+// If the value is not private, but is designated by one of the configured attribute transforms,
+// then the second return value is the lookup node for that transform. If there are private
+// attribute references designating properties within such a value, the transform cannot be
+// trusted to hide them, so the whole value is redacted instead.
 //
-// The second return value indicates whether there are any private attribute references
+// The third return value indicates whether there are any private attribute references
 // designating properties *within* this value. That is, if attrPath is ["address"], and the
 // configuration says that "/address/street" is private, then the second return value will be
 // true, which tells us that we can't just dump the value of the "address" object directly into
 // the output but will need to filter its properties.
 //
-// Note that even though an AttrRef can contain numeric path components to represent an array
-// element lookup, for the purposes of flag evaluations (like "/animals/0" which conceptually
-// represents context.animals[0]), those will not work as private attribute references since
-// we do not recurse to redact anything within an array value. A reference like "/animals/0"
-// would only work if context.animals were an object with a property named "0".
+// An attribute reference with a numeric path component, like "/animals/0", designates either an
+// element of an array (context.animals[0]) or, if the value is an object, a property named "0".
+// The wildcard component "*" designates every element of an array; see privateAttrWildcard.
 //
 // If allAttributesPrivate is true, this method is never called.
 func (f *eventContextFormatter) maybeRedact(
 	c *ldcontext.Context,
-	attrPath []string,
+	attrPath []attrPathComponent,
 	valueType ldvalue.ValueType,
-	redactedAttrs *[]string,
-) (bool, bool) {
-	// First check against the eventContextFormatter configuration.
+	meta *contextMeta,
+) (bool, *privateAttrLookupNode, bool) {
+	shouldCheckForNestedProperties := valueType == ldvalue.ObjectType || valueType == ldvalue.ArrayType
+
+	// If there is an allowlist, then a value that is not on it is redacted, unless there are allowed
+	// properties within it; in that case, we will need to filter those. This protects attributes that
+	// nobody thought to make private. An allowed value is still subject to the private attribute
+	// rules below, and only an allowed value can be transformed.
+	isAllowed, nestedPropertiesAreAllowed := true, false
+	if f.allowedAttributes != nil {
+		isAllowed, nestedPropertiesAreAllowed = checkAllowedAttrLookup(f.allowedAttributes, attrPath)
+		if !isAllowed && !(nestedPropertiesAreAllowed && shouldCheckForNestedProperties) {
+			meta.redactedAttrs = append(meta.redactedAttrs, attrPathString(attrPath))
+			return true, nil, false
+		}
+	}
+
+	// Next check against the private attributes in the eventContextFormatter configuration.
 	redactedAttrRef, nestedPropertiesAreRedacted := f.checkGlobalPrivateAttrRefs(attrPath)
 	if redactedAttrRef != nil {
-		*redactedAttrs = append(*redactedAttrs, redactedAttrRef.String())
-		return true, false
-		// true, false = "this attribute itself is redacted, never mind its children"
+		meta.redactedAttrs = append(meta.redactedAttrs, redactedPathString(*redactedAttrRef, attrPath))
+		return true, nil, false
+		// true, nil, false = "this attribute itself is redacted, never mind its children"
 	}
 
-	shouldCheckForNestedProperties := valueType == ldvalue.ObjectType
-
 	// Now check the per-Context configuration. Unlike the eventContextFormatter configuration, this
 	// does not have a lookup map, just a list of AttrRefs.
 	for i := 0; i < c.PrivateAttributeCount(); i++ {
@@ -285,22 +616,35 @@ func (f *eventContextFormatter) maybeRedact(
 		}
 		match := true
 		for j := 0; j < len(attrPath); j++ {
-			name := a.Component(j)
-			if name != attrPath[j] {
+			if !attrPath[j].matches(a.Component(j)) {
 				match = false
 				break
 			}
 		}
 		if match {
 			if depth == len(attrPath) {
-				*redactedAttrs = append(*redactedAttrs, a.String())
-				return true, false
-				// true, false = "this attribute itself is redacted, never mind its children"
+				meta.redactedAttrs = append(meta.redactedAttrs, redactedPathString(a, attrPath))
+				return true, nil, false
+				// true, nil, false = "this attribute itself is redacted, never mind its children"
 			}
 			nestedPropertiesAreRedacted = true
 		}
 	}
-	return false, nestedPropertiesAreRedacted // false = "this attribute itself is not redacted"
+
+	// Finally, check for a transform.
+	if f.attributeTransforms != nil {
+		transformNode, nestedPropertiesAreTransformed := checkPrivateAttrLookup(f.attributeTransforms, attrPath)
+		if transformNode != nil {
+			if nestedPropertiesAreRedacted || !isAllowed {
+				meta.redactedAttrs = append(meta.redactedAttrs, redactedPathString(*transformNode.attribute, attrPath))
+				return true, nil, false
+			}
+			return false, transformNode, false
+		}
+		nestedPropertiesAreRedacted = nestedPropertiesAreRedacted || nestedPropertiesAreTransformed
+	}
+	// false, nil = "this attribute itself is not redacted or transformed"
+	return false, nil, nestedPropertiesAreRedacted || !isAllowed
 }
 
 // Checks whether the given attribute or subproperty matches any AttrRef that was designated as
@@ -317,31 +661,79 @@ func (f *eventContextFormatter) maybeRedact(
 // The second return value is true if and only if there's at least one configured private
 // attribute reference for *children* of attrPath (and there is not one for attrPath itself, since if
 // there was, we would not bother recursing to write the children). See comments on writeFilteredAttribute.
-func (f eventContextFormatter) checkGlobalPrivateAttrRefs(attrPath []string) (
+func (f eventContextFormatter) checkGlobalPrivateAttrRefs(attrPath []attrPathComponent) (
 	redactedAttrRef *ldattr.Ref, nestedPropertiesAreRedacted bool,
 ) {
-	redactedAttrRef = nil
-	nestedPropertiesAreRedacted = false
-	lookup := f.privateAttributes
-	if lookup == nil {
-		return
+	if f.privateAttributes == nil || len(attrPath) == 0 {
+		return nil, false
+	}
+	node, nestedPropertiesAreRedacted := checkPrivateAttrLookup(f.privateAttributes, attrPath)
+	if node != nil {
+		return node.attribute, false
 	}
-	for i, pathComponent := range attrPath {
-		nextNode := lookup[pathComponent]
-		if nextNode == nil {
-			break
+	return nil, nestedPropertiesAreRedacted
+}
+
+// checkPrivateAttrLookup does the work of checkGlobalPrivateAttrRefs, one level of the lookup map
+// at a time, returning the node of the matching attribute reference rather than the reference. If
+// the first component of the path is an array index, then there may be two nodes that match it,
+// the index itself and the wildcard, so we have to follow both.
+func checkPrivateAttrLookup(lookup map[string]*privateAttrLookupNode, attrPath []attrPathComponent) (
+	*privateAttrLookupNode, bool,
+) {
+	first := attrPath[0]
+	candidates := [2]*privateAttrLookupNode{lookup[first.name], nil}
+	if first.arrayIndex {
+		candidates[1] = lookup[privateAttrWildcard]
+	}
+	nestedPropertiesAreRedacted := false
+	for _, node := range candidates {
+		if node == nil {
+			continue
 		}
-		if i == len(attrPath)-1 {
-			if nextNode.attribute != nil {
-				redactedAttrRef = nextNode.attribute
-				return
+		if len(attrPath) == 1 {
+			if node.attribute != nil {
+				return node, false
 			}
 			nestedPropertiesAreRedacted = true
-			return
-		} else if nextNode.children != nil {
-			lookup = nextNode.children
 			continue
 		}
+		if node.children != nil {
+			found, nested := checkPrivateAttrLookup(node.children, attrPath[1:])
+			if found != nil {
+				return found, false
+			}
+			nestedPropertiesAreRedacted = nestedPropertiesAreRedacted || nested
+		}
+	}
+	return nil, nestedPropertiesAreRedacted
+}
+
+// checkAllowedAttrLookup checks the path against a lookup map of allowed attribute references. The
+// first return value is true if the path, or the path of a value that contains it, is allowed. The
+// second is true if there are allowed references to properties within the value.
+func checkAllowedAttrLookup(lookup map[string]*privateAttrLookupNode, attrPath []attrPathComponent) (bool, bool) {
+	first := attrPath[0]
+	candidates := [2]*privateAttrLookupNode{lookup[first.name], nil}
+	if first.arrayIndex {
+		candidates[1] = lookup[privateAttrWildcard]
+	}
+	nestedPropertiesAreAllowed := false
+	for _, node := range candidates {
+		switch {
+		case node == nil:
+			continue
+		case node.attribute != nil:
+			return true, false
+		case len(attrPath) == 1:
+			nestedPropertiesAreAllowed = true
+		default:
+			allowed, nested := checkAllowedAttrLookup(node.children, attrPath[1:])
+			if allowed {
+				return true, false
+			}
+			nestedPropertiesAreAllowed = nestedPropertiesAreAllowed || nested
+		}
 	}
-	return
+	return false, nestedPropertiesAreAllowed
 }
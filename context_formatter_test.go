@@ -3,6 +3,7 @@ package ldevents
 import (
 	"encoding/json"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/launchdarkly/go-sdk-common/v3/ldattr"
@@ -78,7 +79,16 @@ func TestEventContextFormatterConstructor(t *testing.T) {
 
 func TestCheckGlobalPrivateAttrRefs(t *testing.T) {
 	expectResult := func(t *testing.T, f eventContextFormatter, expectRedactedAttr *ldattr.Ref, expectHasNested bool, path ...string) {
-		redactedAttr, hasNested := f.checkGlobalPrivateAttrRefs(path)
+		attrPath := make([]attrPathComponent, 0, len(path))
+		for _, name := range path {
+			// In this test, a path component that starts with "[" is an array index, like "[0]"
+			if strings.HasPrefix(name, "[") {
+				attrPath = append(attrPath, attrPathComponent{name: strings.Trim(name, "[]"), arrayIndex: true})
+			} else {
+				attrPath = append(attrPath, attrPathComponent{name: name})
+			}
+		}
+		redactedAttr, hasNested := f.checkGlobalPrivateAttrRefs(attrPath)
 		assert.Equal(t, expectRedactedAttr, redactedAttr)
 		assert.Equal(t, expectHasNested, hasNested)
 	}
@@ -118,10 +128,44 @@ func TestCheckGlobalPrivateAttrRefs(t *testing.T) {
 		expectResult(t, f, &attrRef3, false, "address", "city")
 		expectResult(t, f, nil, false, "address", "zip")
 	})
+
+	t.Run("parent listed after nested private", func(t *testing.T) {
+		attrRef1, attrRef2 := ldattr.NewRef("/address/street"), ldattr.NewRef("address")
+		f := newEventContextFormatter(EventsConfiguration{
+			PrivateAttributes: []ldattr.Ref{attrRef1, attrRef2},
+		})
+
+		expectResult(t, f, &attrRef2, false, "address")
+	})
+
+	t.Run("array elements", func(t *testing.T) {
+		attrRef1, attrRef2, attrRef3 := ldattr.NewRef("/addresses/*/street"),
+			ldattr.NewRef("/addresses/1"), ldattr.NewRef("/tags/*")
+		f := newEventContextFormatter(EventsConfiguration{
+			PrivateAttributes: []ldattr.Ref{attrRef1, attrRef2, attrRef3},
+		})
+		require.NotNil(t, f)
+
+		expectResult(t, f, nil, true, "addresses")
+		expectResult(t, f, nil, true, "addresses", "[0]")
+		expectResult(t, f, &attrRef1, false, "addresses", "[0]", "street")
+		expectResult(t, f, nil, false, "addresses", "[0]", "city")
+		expectResult(t, f, &attrRef2, false, "addresses", "[1]")
+		expectResult(t, f, &attrRef3, false, "tags", "[5]")
+		// the wildcard only matches array elements, and an index also matches an object property
+		expectResult(t, f, nil, false, "tags", "5")
+		expectResult(t, f, &attrRef2, false, "addresses", "1")
+	})
 }
 
 func TestEventContextFormatterOutput(t *testing.T) {
 	objectValue := ldvalue.ObjectBuild().SetString("city", "SF").SetString("state", "CA").Build()
+	maskTransform := NewAttributeTransform("mask", func(ldvalue.Value) (ldvalue.Value, bool) {
+		return ldvalue.String("***"), true
+	})
+	addressesValue := ldvalue.ArrayOf(objectValue,
+		ldvalue.ObjectBuild().SetString("city", "Seattle").SetString("state", "WA").Build(),
+		ldvalue.String("unknown"))
 
 	type params struct {
 		desc         string
@@ -297,6 +341,217 @@ func TestEventContextFormatterOutput(t *testing.T) {
 			`{"kind": "org", "key": "my-key",
 				"_meta": {"redactedAttributes": ["/~1a~1b~0c"]}}`,
 		},
+		{
+			"array element properties private globally, with wildcard",
+			ldcontext.NewBuilder("my-key").Kind("org").
+				SetValue("addresses", addressesValue).
+				Build(),
+			EventsConfiguration{PrivateAttributes: []ldattr.Ref{ldattr.NewRef("/addresses/*/city")}},
+			`{"kind": "org", "key": "my-key", "addresses": [{"state": "CA"}, {"state": "WA"}, "unknown"],
+				"_meta": {"redactedAttributes": ["/addresses/0/city", "/addresses/1/city"]}}`,
+		},
+		{
+			"array elements private per context, by index",
+			ldcontext.NewBuilder("my-key").Kind("org").
+				SetValue("addresses", addressesValue).
+				PrivateRef(ldattr.NewRef("/addresses/0"), ldattr.NewRef("/addresses/1/state")).
+				Build(),
+			EventsConfiguration{},
+			`{"kind": "org", "key": "my-key", "addresses": [{"city": "Seattle"}, "unknown"],
+				"_meta": {"redactedAttributes": ["/addresses/0", "/addresses/1/state"]}}`,
+		},
+		{
+			"array elements private per context, with wildcard",
+			ldcontext.NewBuilder("my-key").Kind("org").
+				SetValue("tags", ldvalue.ArrayOf(ldvalue.String("a"), ldvalue.String("b"))).
+				SetValue("address", objectValue).
+				PrivateRef(ldattr.NewRef("/tags/*"), ldattr.NewRef("/address/*")).
+				Build(),
+			EventsConfiguration{},
+			`{"kind": "org", "key": "my-key", "tags": [], "address": {"city": "SF", "state": "CA"},
+				"_meta": {"redactedAttributes": ["/tags/0", "/tags/1"]}}`,
+		},
+		{
+			"attributes transformed globally",
+			ldcontext.NewBuilder("my-key").Kind("org").
+				Name("my-name").
+				SetString("email", "Sandy@Example.com").
+				SetString("phone", "not-an-email").
+				SetValue("addresses", addressesValue).
+				Build(),
+			EventsConfiguration{AttributeTransforms: []PrivateAttributeTransform{
+				{Attribute: ldattr.NewRef("email"), Transform: NewEmailDomainTransform()},
+				{Attribute: ldattr.NewRef("phone"), Transform: NewEmailDomainTransform()},
+				{Attribute: ldattr.NewRef("/addresses/*/city"), Transform: maskTransform},
+				{Attribute: ldattr.NewRef("name"), Transform: maskTransform},
+			}},
+			`{"kind": "org", "key": "my-key", "name": "***", "email": "example.com",
+				"addresses": [{"city": "***", "state": "CA"}, {"city": "***", "state": "WA"}, "unknown"],
+				"_meta": {"redactedAttributes": ["phone"], "transformedAttributes": {"name": "mask",
+					"email": "email-domain", "/addresses/0/city": "mask", "/addresses/1/city": "mask"}}}`,
+		},
+		{
+			"private attributes take precedence over transforms",
+			ldcontext.NewBuilder("my-key").Kind("org").
+				Name("my-name").
+				SetString("attr1", "value1").
+				SetValue("address", objectValue).
+				Private("attr1").
+				Build(),
+			EventsConfiguration{
+				PrivateAttributes: []ldattr.Ref{ldattr.NewRef("name"), ldattr.NewRef("/address/city")},
+				AttributeTransforms: []PrivateAttributeTransform{
+					{Attribute: ldattr.NewRef("name"), Transform: maskTransform},
+					{Attribute: ldattr.NewRef("attr1"), Transform: maskTransform},
+					// the transform can't be trusted to hide the private property within this value
+					{Attribute: ldattr.NewRef("address"), Transform: maskTransform},
+				},
+			},
+			`{"kind": "org", "key": "my-key",
+				"_meta": {"redactedAttributes": ["address", "attr1", "name"]}}`,
+		},
+		{
+			"nested attribute transformed, along with nested private attribute",
+			ldcontext.NewBuilder("my-key").Kind("org").
+				SetValue("address", objectValue).
+				Build(),
+			EventsConfiguration{
+				PrivateAttributes: []ldattr.Ref{ldattr.NewRef("/address/state")},
+				AttributeTransforms: []PrivateAttributeTransform{
+					{Attribute: ldattr.NewRef("/address/city"), Transform: maskTransform},
+				},
+			},
+			`{"kind": "org", "key": "my-key", "address": {"city": "***"},
+				"_meta": {"redactedAttributes": ["/address/state"], "transformedAttributes": {"/address/city": "mask"}}}`,
+		},
+		{
+			"transforms are not applied if all attributes are private",
+			ldcontext.NewBuilder("my-key").Kind("org").
+				Name("my-name").
+				Build(),
+			EventsConfiguration{
+				AllAttributesPrivate: true,
+				AttributeTransforms: []PrivateAttributeTransform{
+					{Attribute: ldattr.NewRef("name"), Transform: maskTransform},
+				},
+			},
+			`{"kind": "org", "key": "my-key", "_meta": {"redactedAttributes": ["name"]}}`,
+		},
+		{
+			"private attributes per kind, multi-kind",
+			ldcontext.NewMulti(
+				ldcontext.NewBuilder("user-key").Name("user-name").SetValue("address", objectValue).Build(),
+				ldcontext.NewBuilder("device-key").Kind("device").Name("device-name").SetString("os", "x").Build(),
+				ldcontext.NewBuilder("org-key").Kind("org").Name("org-name").SetValue("address", objectValue).Build(),
+			),
+			EventsConfiguration{
+				PrivateAttributes: []ldattr.Ref{ldattr.NewRef("/address/state")},
+				PrivateAttributesByKind: map[ldcontext.Kind]KindPrivateAttributes{
+					"user":   {PrivateAttributes: []ldattr.Ref{ldattr.NewRef("name")}},
+					"device": {AllAttributesPrivate: true},
+				},
+			},
+			`{"kind": "multi",
+				"device": {"key": "device-key", "_meta": {"redactedAttributes": ["name", "os"]}},
+				"org": {"key": "org-key", "name": "org-name", "address": {"city": "SF"},
+					"_meta": {"redactedAttributes": ["/address/state"]}},
+				"user": {"key": "user-key", "address": {"city": "SF"},
+					"_meta": {"redactedAttributes": ["/address/state", "name"]}}}`,
+		},
+		{
+			"private attributes per kind, single kind",
+			ldcontext.NewBuilder("user-key").Name("user-name").Build(),
+			EventsConfiguration{
+				PrivateAttributesByKind: map[ldcontext.Kind]KindPrivateAttributes{
+					"user": {PrivateAttributes: []ldattr.Ref{ldattr.NewRef("name")}},
+					"org":  {AllAttributesPrivate: true},
+				},
+			},
+			`{"kind": "user", "key": "user-key", "_meta": {"redactedAttributes": ["name"]}}`,
+		},
+		{
+			"allowlist, single kind",
+			ldcontext.NewBuilder("my-key").Kind("org").
+				Name("my-name").
+				SetString("attr1", "value1").
+				SetString("a/b", "value2").
+				SetValue("address", objectValue).
+				SetValue("addresses", addressesValue).
+				Anonymous(true).
+				Build(),
+			EventsConfiguration{AllowedAttributes: []ldattr.Ref{
+				ldattr.NewRef("name"), ldattr.NewRef("/address/city"), ldattr.NewRef("/addresses/*/state")}},
+			`{"kind": "org", "key": "my-key", "anonymous": true, "name": "my-name", "address": {"city": "SF"},
+				"addresses": [{"state": "CA"}, {"state": "WA"}],
+				"_meta": {"redactedAttributes": ["/address/state", "/addresses/0/city", "/addresses/1/city",
+					"/addresses/2", "a/b", "attr1"]}}`,
+		},
+		{
+			"allowlist with private attributes and transforms",
+			ldcontext.NewBuilder("my-key").Kind("org").
+				Name("my-name").
+				SetString("email", "x@example.com").
+				SetString("phone", "555").
+				SetValue("address", objectValue).
+				Private("phone").
+				Build(),
+			EventsConfiguration{
+				AllowedAttributes: []ldattr.Ref{ldattr.NewRef("name"), ldattr.NewRef("address"),
+					ldattr.NewRef("phone"), ldattr.NewRef("email")},
+				PrivateAttributes: []ldattr.Ref{ldattr.NewRef("/address/state")},
+				AttributeTransforms: []PrivateAttributeTransform{
+					{Attribute: ldattr.NewRef("email"), Transform: NewEmailDomainTransform()},
+					{Attribute: ldattr.NewRef("other"), Transform: maskTransform},
+				},
+			},
+			`{"kind": "org", "key": "my-key", "name": "my-name", "email": "example.com", "address": {"city": "SF"},
+				"_meta": {"redactedAttributes": ["/address/state", "phone"],
+					"transformedAttributes": {"email": "email-domain"}}}`,
+		},
+		{
+			"transforms are not applied to values that are not allowed",
+			ldcontext.NewBuilder("my-key").Kind("org").
+				Name("my-name").
+				SetValue("address", objectValue).
+				Build(),
+			EventsConfiguration{
+				AllowedAttributes: []ldattr.Ref{ldattr.NewRef("/address/city")},
+				AttributeTransforms: []PrivateAttributeTransform{
+					{Attribute: ldattr.NewRef("name"), Transform: maskTransform},
+					{Attribute: ldattr.NewRef("address"), Transform: maskTransform},
+				},
+			},
+			`{"kind": "org", "key": "my-key", "_meta": {"redactedAttributes": ["address", "name"]}}`,
+		},
+		{
+			"allowlist per kind",
+			ldcontext.NewMulti(
+				ldcontext.NewBuilder("user-key").Name("user-name").SetString("country", "us").Build(),
+				ldcontext.NewBuilder("device-key").Kind("device").Name("device-name").SetString("os", "x").
+					SetString("country", "us").Build(),
+				ldcontext.NewBuilder("org-key").Kind("org").Name("org-name").Build(),
+			),
+			EventsConfiguration{
+				AllowedAttributes: []ldattr.Ref{ldattr.NewRef("country")},
+				PrivateAttributesByKind: map[ldcontext.Kind]KindPrivateAttributes{
+					"device": {AllowedAttributes: []ldattr.Ref{ldattr.NewRef("os"), ldattr.NewRef("name")}},
+				},
+			},
+			`{"kind": "multi",
+				"device": {"key": "device-key", "name": "device-name", "os": "x",
+					"_meta": {"redactedAttributes": ["country"]}},
+				"org": {"key": "org-key", "_meta": {"redactedAttributes": ["name"]}},
+				"user": {"key": "user-key", "country": "us", "_meta": {"redactedAttributes": ["name"]}}}`,
+		},
+		{
+			"array element paths are escaped",
+			ldcontext.NewBuilder("my-key").Kind("org").
+				SetValue("a/b", ldvalue.ArrayOf(ldvalue.ObjectBuild().SetString("c~d", "x").Build())).
+				Build(),
+			EventsConfiguration{PrivateAttributes: []ldattr.Ref{ldattr.NewRef("/a~1b/*/c~0d")}},
+			`{"kind": "org", "key": "my-key", "a/b": [{}],
+				"_meta": {"redactedAttributes": ["/a~1b/0/c~0d"]}}`,
+		},
 	} {
 		t.Run(p.desc, func(t *testing.T) {
 			f := newEventContextFormatter(p.options)
@@ -362,6 +617,124 @@ func TestPreserializedEventContextFormatterOutput(t *testing.T) {
 	}
 }
 
+func TestPreserializedContextRedaction(t *testing.T) {
+	placeholder := ldcontext.New("user-key")
+	userContextJSON := `{"kind": "user", "key": "user-key", "name": "my-name", "email": "x@example.com",
+		"address": {"city": "SF", "street": "Main"}, "_meta": {"redactedAttributes": ["phone", "name"]}}`
+	multiContextJSON := `{"kind": "multi",
+	"org": {"key": "org-key", "name": "org-name", "email": "y@example.com"},
+	"user": {"key": "user-key", "email": "x@example.com", "_meta": {"redactedAttributes": ["phone"]}}}`
+
+	type params struct {
+		desc            string
+		contextJSON     string
+		options         EventsConfiguration
+		redactAnonymous bool
+		expectedJSON    string
+	}
+	for _, p := range []params{
+		{
+			"no rules",
+			userContextJSON,
+			EventsConfiguration{},
+			false,
+			userContextJSON, // the "name" property is not redacted, since the JSON is not even parsed
+		},
+		{
+			"private attributes",
+			userContextJSON,
+			EventsConfiguration{PrivateAttributes: []ldattr.Ref{ldattr.NewRef("email"), ldattr.NewRef("/address/street")}},
+			false,
+			`{"kind": "user", "key": "user-key", "address": {"city": "SF"},
+				"_meta": {"redactedAttributes": ["/address/street", "email", "name", "phone"]}}`,
+		},
+		{
+			"all attributes private",
+			userContextJSON,
+			EventsConfiguration{AllAttributesPrivate: true},
+			false,
+			`{"kind": "user", "key": "user-key",
+				"_meta": {"redactedAttributes": ["address", "email", "name", "phone"]}}`,
+		},
+		{
+			"multi-kind",
+			multiContextJSON,
+			EventsConfiguration{PrivateAttributesByKind: map[ldcontext.Kind]KindPrivateAttributes{
+				"org": {PrivateAttributes: []ldattr.Ref{ldattr.NewRef("email")}},
+			}},
+			false,
+			`{"kind": "multi",
+			"org": {"key": "org-key", "name": "org-name", "_meta": {"redactedAttributes": ["email"]}},
+			"user": {"key": "user-key", "email": "x@example.com", "_meta": {"redactedAttributes": ["phone"]}}}`,
+		},
+		{
+			"anonymous context with no rules",
+			`{"kind": "user", "key": "user-key", "name": "my-name", "anonymous": true}`,
+			EventsConfiguration{},
+			true,
+			`{"kind": "user", "key": "user-key", "anonymous": true, "_meta": {"redactedAttributes": ["name"]}}`,
+		},
+		{
+			"non-anonymous context with no rules",
+			`{"kind": "user", "key": "user-key", "name": "my-name"}`,
+			EventsConfiguration{},
+			true,
+			`{"kind": "user", "key": "user-key", "name": "my-name"}`,
+		},
+	} {
+		t.Run(p.desc, func(t *testing.T) {
+			p.options.RedactPreserializedContexts = true
+			p.options.CanonicalOutput = true
+			f := newEventContextFormatter(p.options)
+			ec := PreserializedContext(placeholder, json.RawMessage(p.contextJSON))
+			w := jwriter.NewWriter()
+			f.writeContext(&w, &ec, p.redactAnonymous)
+			require.NoError(t, w.Error())
+			jsonhelpers.AssertEqual(t, p.expectedJSON, w.Bytes())
+		})
+	}
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		f := newEventContextFormatter(EventsConfiguration{
+			AllAttributesPrivate:        true,
+			RedactPreserializedContexts: true,
+		})
+		ec := PreserializedContext(placeholder, json.RawMessage(`{"kind": "user"}`))
+		w := jwriter.NewWriter()
+		f.WriteContext(&w, &ec)
+		assert.Error(t, w.Error())
+	})
+}
+
+func TestEventContextFormatterCanonicalOutput(t *testing.T) {
+	address := ldvalue.ObjectBuild().SetString("street", "Main").SetString("city", "SF").
+		Set("geo", ldvalue.ObjectBuild().SetInt("lon", 2).SetInt("lat", 1).Build()).Build()
+	context := ldcontext.NewBuilder("my-key").
+		Name("my-name").
+		SetString("zip", "94000").
+		SetString("email", "x@example.com").
+		SetValue("address", address).
+		SetString("phone", "555").
+		Private("phone").
+		Build()
+	f := newEventContextFormatter(EventsConfiguration{
+		PrivateAttributes: []ldattr.Ref{ldattr.NewRef("zip"), ldattr.NewRef("/address/street"), ldattr.NewRef("email")},
+		CanonicalOutput:   true,
+	})
+	expectedJSON := `{"kind":"user","key":"my-key","address":{"city":"SF","geo":{"lat":1,"lon":2}},"name":"my-name",` +
+		`"_meta":{"redactedAttributes":["/address/street","email","phone","zip"]}}`
+
+	// Since map iteration order is random, writing the context many times makes it very unlikely that
+	// the output is only sorted by chance.
+	for i := 0; i < 20; i++ {
+		w := jwriter.NewWriter()
+		ec := Context(context)
+		f.WriteContext(&w, &ec)
+		require.NoError(t, w.Error())
+		require.Equal(t, expectedJSON, string(w.Bytes()))
+	}
+}
+
 func TestWriteInvalidContext(t *testing.T) {
 	badContext := ldcontext.New("")
 	f := newEventContextFormatter(EventsConfiguration{})
@@ -0,0 +1,321 @@
+package ldevents
+
+import (
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/launchdarkly/go-jsonstream/v3/jwriter"
+	"github.com/launchdarkly/go-sdk-common/v3/ldattr"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+)
+
+// ContextLimitReporter is an optional interface for EventProcessor implementations that can report
+// how often the context size limits in EventsConfiguration have been applied. It is implemented by
+// the processors returned by NewDefaultEventProcessor and MultiEnvironmentEventProcessor, and by
+// SynchronousEventProcessor.
+//
+// Contexts are written when events are flushed, so the statistics only cover events that have
+// been flushed.
+type ContextLimitReporter interface {
+	// ContextLimitStats returns the totals since the processor was created.
+	ContextLimitStats() ContextLimitStats
+}
+
+// ContextLimitStats contains the statistics reported by ContextLimitReporter.
+type ContextLimitStats struct {
+	// LimitedContexts is the number of times an individual context was written with any values
+	// truncated or dropped. A context that appears in several events is counted each time.
+	LimitedContexts int
+	// TruncatedValues is the number of strings, objects, and arrays that were shortened because of
+	// EventsConfiguration.ContextMaxStringLength or ContextMaxCollectionSize.
+	TruncatedValues int
+	// DroppedAttributes is the number of attributes that were dropped because of
+	// EventsConfiguration.ContextMaxSize.
+	DroppedAttributes int
+}
+
+// contextLimits are the limits set by EventsConfiguration.ContextMaxStringLength,
+// ContextMaxCollectionSize, and ContextMaxSize. The paths of values that were shortened are listed in
+// the "truncatedAttributes" of the "_meta" property of the output context, and the names of dropped
+// attributes in "droppedAttributes".
+type contextLimits struct {
+	maxStringLength   int
+	maxCollectionSize int
+	maxContextSize    int
+}
+
+// contextLimitStats accumulates ContextLimitStats. It is shared by all copies of an
+// eventContextFormatter, which may be used from several flush workers at once.
+type contextLimitStats struct {
+	limitedContexts   int64
+	truncatedValues   int64
+	droppedAttributes int64
+}
+
+func (l contextLimits) any() bool {
+	return l.maxStringLength > 0 || l.maxCollectionSize > 0 || l.maxContextSize > 0
+}
+
+// exceededBy returns true if the value, or any value within it, is over the string length or
+// collection size limit.
+func (l contextLimits) exceededBy(value ldvalue.Value) bool {
+	switch value.Type() {
+	case ldvalue.StringType:
+		return l.maxStringLength > 0 && len(value.StringValue()) > l.maxStringLength
+	case ldvalue.ArrayType:
+		if l.maxCollectionSize > 0 && value.Count() > l.maxCollectionSize {
+			return true
+		}
+		for i := 0; i < value.Count(); i++ {
+			if l.exceededBy(value.GetByIndex(i)) {
+				return true
+			}
+		}
+	case ldvalue.ObjectType:
+		if l.maxCollectionSize > 0 && value.Count() > l.maxCollectionSize {
+			return true
+		}
+		for _, key := range value.Keys(nil) {
+			if l.exceededBy(value.GetByKey(key)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *contextLimitStats) record(meta contextMeta) {
+	if s == nil || (len(meta.truncatedAttrs) == 0 && len(meta.droppedAttrs) == 0) {
+		return
+	}
+	atomic.AddInt64(&s.limitedContexts, 1)
+	atomic.AddInt64(&s.truncatedValues, int64(len(meta.truncatedAttrs)))
+	atomic.AddInt64(&s.droppedAttributes, int64(len(meta.droppedAttrs)))
+}
+
+func (s *contextLimitStats) get() ContextLimitStats {
+	if s == nil {
+		return ContextLimitStats{}
+	}
+	return ContextLimitStats{
+		LimitedContexts:   int(atomic.LoadInt64(&s.limitedContexts)),
+		TruncatedValues:   int(atomic.LoadInt64(&s.truncatedValues)),
+		DroppedAttributes: int(atomic.LoadInt64(&s.droppedAttributes)),
+	}
+}
+
+// limitValue returns a copy of the value that is within the string length and collection size
+// limits, adding the paths of any values that had to be shortened to truncatedAttrs. Strings are
+// cut at a character boundary; arrays keep their first elements; and objects keep the properties
+// whose names sort first, so that the result does not depend on map iteration order.
+func (f *eventContextFormatter) limitValue(
+	path []attrPathComponent,
+	value ldvalue.Value,
+	meta *contextMeta,
+) ldvalue.Value {
+	if !f.limits.exceededBy(value) {
+		return value
+	}
+	switch value.Type() {
+	case ldvalue.StringType:
+		meta.truncatedAttrs = append(meta.truncatedAttrs, attrPathString(path))
+		return ldvalue.String(truncateUTF8(value.StringValue(), f.limits.maxStringLength))
+	case ldvalue.ArrayType:
+		count := f.limitCollectionSize(path, value.Count(), meta)
+		arr := ldvalue.ArrayBuildWithCapacity(count)
+		for i := 0; i < count; i++ {
+			elementPath := append(path, attrPathComponent{name: strconv.Itoa(i), arrayIndex: true}) //nolint:gocritic
+			arr.Add(f.limitValue(elementPath, value.GetByIndex(i), meta))
+		}
+		return arr.Build()
+	default: // exceededBy is only true for strings, arrays, and objects
+		keys := value.Keys(nil)
+		if f.limits.maxCollectionSize > 0 && len(keys) > f.limits.maxCollectionSize {
+			sort.Strings(keys)
+			keys = keys[:f.limitCollectionSize(path, len(keys), meta)]
+		}
+		obj := ldvalue.ObjectBuildWithCapacity(len(keys))
+		for _, key := range keys {
+			propertyPath := append(path, attrPathComponent{name: key}) //nolint:gocritic
+			obj.Set(key, f.limitValue(propertyPath, value.GetByKey(key), meta))
+		}
+		return obj.Build()
+	}
+}
+
+// limitCollectionSize returns the number of elements or properties of an array or object to keep,
+// and adds its path to truncatedAttrs if that is fewer than it has.
+func (f *eventContextFormatter) limitCollectionSize(path []attrPathComponent, count int, meta *contextMeta) int {
+	if f.limits.maxCollectionSize > 0 && count > f.limits.maxCollectionSize {
+		meta.truncatedAttrs = append(meta.truncatedAttrs, attrPathString(path))
+		return f.limits.maxCollectionSize
+	}
+	return count
+}
+
+func truncateUTF8(s string, maxLength int) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	for maxLength > 0 && !utf8.RuneStart(s[maxLength]) {
+		maxLength--
+	}
+	return s[:maxLength]
+}
+
+// writeContextWithSizeLimit writes an individual context, dropping top-level attributes if that is
+// necessary to keep it within EventsConfiguration.ContextMaxSize. The largest attributes are dropped
+// first, with ties broken by name, until the context is small enough.
+//
+// Rather than writing the context again after dropping each attribute, it measures each attribute
+// once, and works out the size of the context from that and from the lists in "_meta".
+func (f *eventContextFormatter) writeContextWithSizeLimit(
+	w *jwriter.Writer,
+	c *ldcontext.Context,
+	includeKind,
+	redactAnonymous,
+	preRedacted bool,
+) contextMeta {
+	scratch := jwriter.NewWriter()
+	meta := f.writeContextAttributes(&scratch, c, includeKind, redactAnonymous, preRedacted, nil)
+	if len(scratch.Bytes()) > f.limits.maxContextSize && scratch.Error() == nil {
+		attrs := f.attributesBySize(c, redactAnonymous)
+		metaSizes := newMetaSizes(meta)
+		size := len(scratch.Bytes())
+		sizeWithoutMeta := size - metaSizes.size()
+		dropped := make(map[string]struct{})
+		i := 0
+		for ; i < len(attrs) && size > f.limits.maxContextSize; i++ {
+			dropped[attrs[i].name] = struct{}{}
+			sizeWithoutMeta -= attrs[i].size
+			metaSizes.remove(attrs[i].meta)
+			metaSizes.add(metaDroppedAttrs, len(jsonString(ldattr.NewLiteralRef(attrs[i].name).String())))
+			size = sizeWithoutMeta + metaSizes.size()
+		}
+		scratch = jwriter.NewWriter()
+		meta = f.writeContextAttributes(&scratch, c, includeKind, redactAnonymous, preRedacted, dropped)
+		// The size can only have been underestimated if a redacted attribute was also listed in a
+		// preserialized context's redactedAttributes, so that dropping it did not remove it from the list.
+		for ; i < len(attrs) && len(scratch.Bytes()) > f.limits.maxContextSize; i++ {
+			dropped[attrs[i].name] = struct{}{}
+			scratch = jwriter.NewWriter()
+			meta = f.writeContextAttributes(&scratch, c, includeKind, redactAnonymous, preRedacted, dropped)
+		}
+	}
+	if err := scratch.Error(); err != nil {
+		w.AddError(err) // COVERAGE: writeContext has already checked for an invalid context
+		return meta
+	}
+	w.Raw(scratch.Bytes())
+	return meta
+}
+
+// attributeSize describes the part of an output context that comes from one top-level attribute.
+type attributeSize struct {
+	name string
+	size int       // including the comma that precedes it
+	meta metaSizes // the entries that the attribute adds to the lists in "_meta"
+}
+
+// attributesBySize returns the attributes that are written for the context, in descending order of
+// their size in the output.
+func (f *eventContextFormatter) attributesBySize(c *ldcontext.Context, redactAnonymous bool) []attributeSize {
+	if f.allAttributesPrivate || (c.Anonymous() && redactAnonymous) {
+		return nil
+	}
+	var attrs []attributeSize
+	for _, key := range c.GetOptionalAttributeNames(nil) {
+		if value := c.GetValue(key); value.IsDefined() {
+			w := jwriter.NewWriter()
+			obj := w.Object()
+			var meta contextMeta
+			f.writeFilteredAttribute(&w, c, &obj, nil, key, value, &meta)
+			obj.End()
+			if size := len(w.Bytes()) - len("{}"); size > 0 {
+				attrs = append(attrs, attributeSize{name: key, size: size + len(","), meta: newMetaSizes(meta)})
+			}
+		}
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].size != attrs[j].size {
+			return attrs[i].size > attrs[j].size
+		}
+		return attrs[i].name < attrs[j].name
+	})
+	return attrs
+}
+
+// The lists in "_meta", in the order that contextMeta.write writes them.
+const (
+	metaRedactedAttrs = iota
+	metaTransformedAttrs
+	metaTruncatedAttrs
+	metaDroppedAttrs
+	metaListCount
+)
+
+// metaSizes describes the lists in the "_meta" property of an output context, so that its size can
+// be worked out as entries are added and removed without writing it again.
+type metaSizes struct {
+	counts [metaListCount]int
+	bytes  [metaListCount]int // the total size of the entries, not including the commas between them
+}
+
+func newMetaSizes(meta contextMeta) metaSizes {
+	var m metaSizes
+	for i, attrs := range [][]string{
+		metaRedactedAttrs:  meta.redactedAttrs,
+		metaTruncatedAttrs: meta.truncatedAttrs,
+		metaDroppedAttrs:   meta.droppedAttrs,
+	} {
+		for _, attr := range attrs {
+			m.add(i, len(jsonString(attr)))
+		}
+	}
+	for _, a := range meta.transformedAttrs {
+		m.add(metaTransformedAttrs, len(jsonString(a.path))+len(":")+len(jsonString(a.transform)))
+	}
+	return m
+}
+
+func (m *metaSizes) add(list, entrySize int) {
+	m.counts[list]++
+	m.bytes[list] += entrySize
+}
+
+func (m *metaSizes) remove(other metaSizes) {
+	for i := range m.counts {
+		m.counts[i] -= other.counts[i]
+		m.bytes[i] -= other.bytes[i]
+	}
+}
+
+// size returns the size of the "_meta" property, including its name and the comma that precedes it.
+func (m metaSizes) size() int {
+	headerSizes := [metaListCount]int{
+		len(`"redactedAttributes":[]`),
+		len(`"transformedAttributes":{}`),
+		len(`"truncatedAttributes":[]`),
+		len(`"droppedAttributes":[]`),
+	}
+	size, lists := 0, 0
+	for i, count := range m.counts {
+		if count > 0 {
+			size += headerSizes[i] + m.bytes[i] + count - 1
+			lists++
+		}
+	}
+	if lists == 0 {
+		return 0
+	}
+	return len(`,"_meta":{}`) + size + lists - 1
+}
+
+func jsonString(s string) []byte {
+	w := jwriter.NewWriter()
+	w.String(s)
+	return w.Bytes()
+}
@@ -0,0 +1,225 @@
+package ldevents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldattr"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	"github.com/launchdarkly/go-jsonstream/v3/jwriter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateUTF8(t *testing.T) {
+	assert.Equal(t, "abc", truncateUTF8("abc", 5))
+	assert.Equal(t, "abc", truncateUTF8("abcdef", 3))
+	assert.Equal(t, "", truncateUTF8("abc", 0))
+	assert.Equal(t, "a", truncateUTF8("aé", 2)) // "é" is two bytes, so it cannot be partly kept
+	assert.Equal(t, "aé", truncateUTF8("aéb", 3))
+}
+
+func TestEventContextFormatterLimits(t *testing.T) {
+	writeContext := func(f eventContextFormatter, c ldcontext.Context) string {
+		w := jwriter.NewWriter()
+		ec := Context(c)
+		f.WriteContext(&w, &ec)
+		require.NoError(t, w.Error())
+		return string(w.Bytes())
+	}
+
+	t.Run("no limits", func(t *testing.T) {
+		f := newEventContextFormatter(EventsConfiguration{CanonicalOutput: true})
+		c := ldcontext.NewBuilder("my-key").Name("a long name").Build()
+		assert.Equal(t, `{"kind":"user","key":"my-key","name":"a long name"}`, writeContext(f, c))
+		assert.Equal(t, ContextLimitStats{}, f.limitStats.get())
+	})
+
+	t.Run("string length", func(t *testing.T) {
+		f := newEventContextFormatter(EventsConfiguration{ContextMaxStringLength: 5, CanonicalOutput: true})
+		c := ldcontext.NewBuilder("my-long-key").
+			Name("abcdefgh").
+			SetString("short", "abc").
+			SetValue("nested", ldvalue.Parse([]byte(`{"s":"123456789","n":1,"a":["xyzxyzxyz"]}`))).
+			Build()
+		assert.Equal(t,
+			`{"kind":"user","key":"my-long-key","name":"abcde","nested":{"a":["xyzxy"],"n":1,"s":"12345"},`+
+				`"short":"abc","_meta":{"truncatedAttributes":["/nested/a/0","/nested/s","name"]}}`,
+			writeContext(f, c))
+		assert.Equal(t, ContextLimitStats{LimitedContexts: 1, TruncatedValues: 3}, f.limitStats.get())
+	})
+
+	t.Run("collection size", func(t *testing.T) {
+		f := newEventContextFormatter(EventsConfiguration{ContextMaxCollectionSize: 2, CanonicalOutput: true})
+		c := ldcontext.NewBuilder("my-key").
+			SetValue("arr", ldvalue.ArrayOf(ldvalue.Int(1), ldvalue.Int(2), ldvalue.Int(3))).
+			SetValue("obj", ldvalue.Parse([]byte(`{"d":4,"c":3,"b":2,"a":1}`))).
+			SetValue("small", ldvalue.ArrayOf(ldvalue.Int(1))).
+			Build()
+		expected := `{"kind":"user","key":"my-key","arr":[1,2],"obj":{"a":1,"b":2},"small":[1],` +
+			`"_meta":{"truncatedAttributes":["arr","obj"]}}`
+
+		// The properties that are kept should not depend on map iteration order
+		for i := 0; i < 20; i++ {
+			require.Equal(t, expected, writeContext(f, c))
+		}
+	})
+
+	t.Run("collection size with nested private attribute", func(t *testing.T) {
+		f := newEventContextFormatter(EventsConfiguration{
+			ContextMaxCollectionSize: 2,
+			PrivateAttributes:        []ldattr.Ref{ldattr.NewRef("/obj/a")},
+			CanonicalOutput:          true,
+		})
+		c := ldcontext.NewBuilder("my-key").
+			SetValue("obj", ldvalue.Parse([]byte(`{"d":4,"c":3,"b":2,"a":1}`))).
+			Build()
+		assert.Equal(t,
+			`{"kind":"user","key":"my-key","obj":{"b":2},`+
+				`"_meta":{"redactedAttributes":["/obj/a"],"truncatedAttributes":["obj"]}}`,
+			writeContext(f, c))
+	})
+
+	t.Run("transformed value is limited", func(t *testing.T) {
+		f := newEventContextFormatter(EventsConfiguration{
+			ContextMaxStringLength: 8,
+			AttributeTransforms: []PrivateAttributeTransform{
+				{Attribute: ldattr.NewRef("email"), Transform: NewHMACSHA256Transform([]byte("secret"))},
+			},
+			CanonicalOutput: true,
+		})
+		c := ldcontext.NewBuilder("my-key").SetString("email", "x@example.com").Build()
+		transformed, _ := NewHMACSHA256Transform([]byte("secret")).Transform(ldvalue.String("x@example.com"))
+		assert.Equal(t,
+			`{"kind":"user","key":"my-key","email":"`+transformed.StringValue()[:8]+`",`+
+				`"_meta":{"transformedAttributes":{"email":"hmac-sha256"},"truncatedAttributes":["email"]}}`,
+			writeContext(f, c))
+	})
+
+	t.Run("context size", func(t *testing.T) {
+		f := newEventContextFormatter(EventsConfiguration{ContextMaxSize: 120, CanonicalOutput: true})
+		c := ldcontext.NewBuilder("my-key").
+			Name("my-name").
+			SetString("bbb", "0123456789").
+			SetString("aaa", "0123456789").
+			SetString("ccc", "0123456789012345678901234567890123456789").
+			Build()
+		assert.Equal(t,
+			`{"kind":"user","key":"my-key","bbb":"0123456789","name":"my-name",`+
+				`"_meta":{"droppedAttributes":["aaa","ccc"]}}`,
+			writeContext(f, c))
+		assert.Equal(t, ContextLimitStats{LimitedContexts: 1, DroppedAttributes: 2}, f.limitStats.get())
+	})
+
+	t.Run("context size drops the same attributes as rewriting after each one", func(t *testing.T) {
+		c := ldcontext.NewBuilder("my-key").
+			Name("my-name").
+			SetString("a/b", "0123456789").
+			SetString("email", "x@example.com").
+			SetValue("obj", ldvalue.Parse([]byte(`{"private":1,"public":"0123456789"}`))).
+			SetString("long", "0123456789012345678901234567890123456789").
+			Build()
+		for _, config := range []EventsConfiguration{
+			{},
+			{PrivateAttributes: []ldattr.Ref{ldattr.NewRef("/obj/private")}},
+			{
+				ContextMaxStringLength: 12,
+				AttributeTransforms: []PrivateAttributeTransform{
+					{Attribute: ldattr.NewRef("email"), Transform: NewHMACSHA256Transform([]byte("secret"))},
+				},
+			},
+		} {
+			for maxSize := 20; maxSize <= 200; maxSize++ {
+				config.ContextMaxSize = maxSize
+				config.CanonicalOutput = true
+				f := newEventContextFormatter(config)
+				dropped := make(map[string]struct{})
+				w := jwriter.NewWriter()
+				f.writeContextAttributes(&w, &c, true, false, false, dropped)
+				for _, attr := range f.attributesBySize(&c, false) {
+					if len(w.Bytes()) <= maxSize {
+						break
+					}
+					dropped[attr.name] = struct{}{}
+					w = jwriter.NewWriter()
+					f.writeContextAttributes(&w, &c, true, false, false, dropped)
+				}
+				require.Equal(t, string(w.Bytes()), writeContext(f, c), "max size %d", maxSize)
+			}
+		}
+	})
+
+	t.Run("context size already within limit", func(t *testing.T) {
+		f := newEventContextFormatter(EventsConfiguration{ContextMaxSize: 1000})
+		c := ldcontext.NewBuilder("my-key").Name("my-name").Build()
+		assert.Equal(t, `{"kind":"user","key":"my-key","name":"my-name"}`, writeContext(f, c))
+		assert.Equal(t, ContextLimitStats{}, f.limitStats.get())
+	})
+
+	t.Run("multi-kind context", func(t *testing.T) {
+		f := newEventContextFormatter(EventsConfiguration{
+			ContextMaxStringLength: 3,
+			PrivateAttributesByKind: map[ldcontext.Kind]KindPrivateAttributes{
+				"org": {PrivateAttributes: []ldattr.Ref{ldattr.NewRef("email")}},
+			},
+			CanonicalOutput: true,
+		})
+		c := ldcontext.NewMulti(
+			ldcontext.NewBuilder("user-key").Name("abcdef").Build(),
+			ldcontext.NewBuilder("org-key").Kind("org").Name("ghijkl").SetString("email", "x").Build(),
+		)
+		assert.Equal(t,
+			`{"kind":"multi","org":{"key":"org-key","name":"ghi",`+
+				`"_meta":{"redactedAttributes":["email"],"truncatedAttributes":["name"]}},`+
+				`"user":{"key":"user-key","name":"abc","_meta":{"truncatedAttributes":["name"]}}}`,
+			writeContext(f, c))
+		assert.Equal(t, ContextLimitStats{LimitedContexts: 2, TruncatedValues: 2}, f.limitStats.get())
+	})
+}
+
+func TestContextLimitStatsAreReported(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.ContextMaxStringLength = 3
+	context := Context(ldcontext.NewBuilder("my-key").Name("my-name").Build())
+	expected := ContextLimitStats{LimitedContexts: 1, TruncatedValues: 1}
+
+	t.Run("synchronous", func(t *testing.T) {
+		ep, _ := createSynchronousEventProcessorAndSender(config)
+		defer ep.Close()
+
+		var reporter ContextLimitReporter = ep
+		ep.RecordIdentifyEvent(NewEventFactory(false, nil).NewIdentifyEventData(context, ldvalue.OptionalInt{}))
+		assert.Equal(t, ContextLimitStats{}, reporter.ContextLimitStats())
+
+		ep.Flush()
+		assert.Equal(t, expected, reporter.ContextLimitStats())
+	})
+
+	t.Run("default", func(t *testing.T) {
+		ep, _ := createEventProcessorAndSender(config)
+		defer ep.Close()
+
+		reporter, ok := interface{}(ep).(ContextLimitReporter)
+		require.True(t, ok)
+
+		ep.RecordIdentifyEvent(NewEventFactory(false, nil).NewIdentifyEventData(context, ldvalue.OptionalInt{}))
+		ep.FlushBlocking(time.Second)
+		assert.Equal(t, expected, reporter.ContextLimitStats())
+	})
+
+	t.Run("multi-environment", func(t *testing.T) {
+		mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+		defer mep.Close()
+		ep, _ := addEnvironmentWithSender(t, mep, "env1", config)
+
+		reporter, ok := interface{}(ep).(ContextLimitReporter)
+		require.True(t, ok)
+
+		ep.RecordIdentifyEvent(NewEventFactory(false, nil).NewIdentifyEventData(context, ldvalue.OptionalInt{}))
+		require.True(t, ep.FlushBlocking(time.Second))
+		assert.Equal(t, expected, reporter.ContextLimitStats())
+	})
+}
@@ -29,6 +29,7 @@ type DiagnosticsManager struct {
 	dataSinceTime     ldtime.UnixMillisecondTime
 	streamInits       []diagnosticStreamInitInfo
 	periodicEventGate <-chan struct{}
+	clock             Clock
 	lock              sync.Mutex
 }
 
@@ -63,10 +64,26 @@ func NewDiagnosticsManager(
 		startTime:         timestamp,
 		dataSinceTime:     timestamp,
 		periodicEventGate: periodicEventGate,
+		clock:             systemClock{},
 	}
 	return m
 }
 
+// NewDiagnosticsManagerWithClock creates an instance of DiagnosticsManager that takes its start
+// time, and the creation date of each periodic event, from the specified Clock. This should be the
+// same Clock as in EventsConfiguration. If clock is nil, SystemClock is used.
+func NewDiagnosticsManagerWithClock(
+	id ldvalue.Value,
+	configData ldvalue.Value,
+	sdkData ldvalue.Value,
+	clock Clock,
+) *DiagnosticsManager {
+	clock = clockOrDefault(clock)
+	m := NewDiagnosticsManager(id, configData, sdkData, clock.Now(), nil)
+	m.clock = clock
+	return m
+}
+
 // RecordStreamInit is called by the stream processor when a stream connection has either succeeded or failed.
 func (m *DiagnosticsManager) RecordStreamInit(
 	timestamp ldtime.UnixMillisecondTime,
@@ -130,9 +147,21 @@ func (m *DiagnosticsManager) CreateStatsEventAndReset(
 	deduplicatedUsers int,
 	eventsInLastBatch int,
 ) ldvalue.Value {
+	return m.createStatsEventAndReset(droppedEvents, deduplicatedUsers, eventsInLastBatch, ldvalue.OptionalInt{})
+}
+
+// createStatsEventAndReset is the same as CreateStatsEventAndReset, but also reports statistics
+// that are only available to the event processor. The summaryOverflowEvaluations property is only
+// included if it is defined, that is, if the summary capacity options are used.
+func (m *DiagnosticsManager) createStatsEventAndReset(
+	droppedEvents int,
+	deduplicatedUsers int,
+	eventsInLastBatch int,
+	summaryOverflowEvaluations ldvalue.OptionalInt,
+) ldvalue.Value {
+	timestamp := unixMillisNow(m.clock)
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	timestamp := ldtime.UnixMillisNow()
 	streamInitsBuilder := ldvalue.ArrayBuildWithCapacity(len(m.streamInits))
 	for _, si := range m.streamInits {
 		streamInitsBuilder.Add(ldvalue.ObjectBuild().
@@ -141,7 +170,7 @@ func (m *DiagnosticsManager) CreateStatsEventAndReset(
 			SetFloat64("durationMillis", float64(si.durationMillis)).
 			Build())
 	}
-	event := ldvalue.ObjectBuild().
+	builder := ldvalue.ObjectBuild().
 		SetString("kind", "diagnostic").
 		Set("id", m.id).
 		SetFloat64("creationDate", float64(timestamp)).
@@ -149,8 +178,11 @@ func (m *DiagnosticsManager) CreateStatsEventAndReset(
 		SetInt("droppedEvents", droppedEvents).
 		SetInt("deduplicatedUsers", deduplicatedUsers).
 		SetInt("eventsInLastBatch", eventsInLastBatch).
-		Set("streamInits", streamInitsBuilder.Build()).
-		Build()
+		Set("streamInits", streamInitsBuilder.Build())
+	if summaryOverflowEvaluations.IsDefined() {
+		builder.SetInt("summaryOverflowEvaluations", summaryOverflowEvaluations.IntValue())
+	}
+	event := builder.Build()
 	m.streamInits = nil
 	m.dataSinceTime = timestamp
 	return event
@@ -41,6 +41,26 @@ func TestDiagnosticInitEventBaseProperties(t *testing.T) {
 	))
 }
 
+func TestDiagnosticsManagerWithClockUsesClockForTimestamps(t *testing.T) {
+	clock := newTestClock(fakeTime)
+	dm := NewDiagnosticsManagerWithClock(NewDiagnosticID("sdkkey"), ldvalue.Null(), ldvalue.Null(), clock)
+	m.In(t).Assert(dm.CreateInitEvent(), m.JSONProperty("creationDate").Should(equalNumericTime(fakeTime)))
+
+	clock.advance(time.Minute)
+	m.In(t).Assert(dm.CreateStatsEventAndReset(0, 0, 0), m.AllOf(
+		m.JSONProperty("creationDate").Should(equalNumericTime(fakeTime+60000)),
+		m.JSONProperty("dataSinceDate").Should(equalNumericTime(fakeTime)),
+	))
+}
+
+func TestDiagnosticStatsEventHasSummaryOverflowEvaluationsOnlyIfDefined(t *testing.T) {
+	dm := NewDiagnosticsManager(NewDiagnosticID("sdkkey"), ldvalue.Null(), ldvalue.Null(), time.Now(), nil)
+	m.In(t).Assert(dm.CreateStatsEventAndReset(0, 0, 0),
+		m.JSONOptProperty("summaryOverflowEvaluations").Should(m.BeNil()))
+	m.In(t).Assert(dm.createStatsEventAndReset(0, 0, 0, ldvalue.NewOptionalInt(2)),
+		m.JSONProperty("summaryOverflowEvaluations").Should(m.Equal(2)))
+}
+
 func TestDiagnosticInitEventConfigData(t *testing.T) {
 	id := NewDiagnosticID("sdkkey")
 	configData := ldvalue.ObjectBuild().SetString("things", "stuff").Build()
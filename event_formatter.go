@@ -0,0 +1,66 @@
+package ldevents
+
+import (
+	"fmt"
+
+	"github.com/launchdarkly/go-jsonstream/v3/jwriter"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+)
+
+// EventFormatter produces the same JSON representation of contexts and events that an event
+// processor with the same configuration would send to LaunchDarkly, in the current events schema
+// (version 4). This is for other components that need to record exactly what would be sent, such
+// as an audit log or a local archive of events, without duplicating the redaction logic.
+//
+// Only the options in EventsConfiguration that affect the output are used: the private attribute,
+// allowlist, transform, PII detection, and size limit options, and CanonicalOutput. An
+// EventFormatter is safe for concurrent use.
+type EventFormatter struct {
+	formatter eventOutputFormatter
+}
+
+// NewEventFormatter creates an EventFormatter. Since the private attribute configuration is
+// preprocessed at this point, it is more efficient to create one EventFormatter and reuse it than to
+// create one for each context or event.
+func NewEventFormatter(config EventsConfiguration) EventFormatter {
+	return EventFormatter{
+		formatter: eventOutputFormatter{
+			contextFormatter: newEventContextFormatter(config),
+			config:           config,
+		},
+	}
+}
+
+// FormatContext returns the JSON representation of a context as it would appear in an identify
+// event, with private attributes redacted. In feature events, the attributes of an anonymous
+// context are also redacted.
+//
+// It returns an error if the context is invalid.
+func (f EventFormatter) FormatContext(context ldcontext.Context) ([]byte, error) {
+	w := jwriter.NewWriter()
+	ec := Context(context)
+	f.formatter.contextFormatter.WriteContext(&w, &ec)
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// FormatEvent returns the JSON representation of a single event, which must be an EvaluationData,
+// CustomEventData, IdentifyEventData, or MigrationOpEventData. An EvaluationData is written as a
+// full feature event, regardless of whether the event processor would have sent one for it.
+//
+// It returns an error if the event is of any other type, or if its context is invalid.
+func (f EventFormatter) FormatEvent(event interface{}) ([]byte, error) {
+	switch event.(type) {
+	case EvaluationData, CustomEventData, IdentifyEventData, MigrationOpEventData:
+	default:
+		return nil, fmt.Errorf("cannot format event of type %T", event)
+	}
+	w := jwriter.NewWriter()
+	f.formatter.writeOutputEvent(&w, event)
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
@@ -0,0 +1,68 @@
+package ldevents
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldattr"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventFormatterMatchesEventProcessorOutput(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.PrivateAttributes = []ldattr.Ref{ldattr.NewRef("name")}
+	ep, es := createSynchronousEventProcessorAndSender(config)
+	defer ep.Close()
+
+	context := Context(ldcontext.NewBuilder(testContextKey).Name("Red").SetString("email", "x").Build())
+	flag := FlagEventProperties{Key: "flagkey", Version: 11, RequireFullEvent: true}
+	events := []interface{}{
+		defaultEventFactory.NewIdentifyEventData(context, ldvalue.OptionalInt{}),
+		defaultEventFactory.NewCustomEventData("eventkey", context, ldvalue.Int(1), false, 0, ldvalue.OptionalInt{}),
+		defaultEventFactory.NewEvaluationData(flag, context, testEvalDetailWithoutReason, false,
+			ldvalue.Null(), "", ldvalue.OptionalInt{}, false),
+	}
+	ep.RecordIdentifyEvent(events[0].(IdentifyEventData))
+	ep.RecordCustomEvent(events[1].(CustomEventData))
+	ep.RecordEvaluation(events[2].(EvaluationData))
+	ep.Flush()
+	output := es.takeEvents()
+	require.Len(t, output, len(events)+1) // the last one is the summary event
+
+	formatter := NewEventFormatter(config)
+	for i, e := range events {
+		data, err := formatter.FormatEvent(e)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(output[i]), string(data))
+	}
+}
+
+func TestEventFormatterFormatContext(t *testing.T) {
+	config := EventsConfiguration{PrivateAttributes: []ldattr.Ref{ldattr.NewRef("email")}}
+	formatter := NewEventFormatter(config)
+	context := ldcontext.NewBuilder("my-key").Name("my-name").SetString("email", "x").Anonymous(true).Build()
+
+	data, err := formatter.FormatContext(context)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(contextJSON(Context(context), config)), string(data))
+	assert.JSONEq(t, `{"kind":"user","key":"my-key","name":"my-name","anonymous":true,`+
+		`"_meta":{"redactedAttributes":["email"]}}`, string(data))
+
+	_, err = formatter.FormatContext(ldcontext.New(""))
+	assert.Error(t, err)
+}
+
+func TestEventFormatterErrors(t *testing.T) {
+	formatter := NewEventFormatter(EventsConfiguration{})
+
+	_, err := formatter.FormatEvent(json.RawMessage(`{"kind":"raw"}`))
+	assert.Error(t, err)
+
+	_, err = formatter.FormatEvent(defaultEventFactory.NewIdentifyEventData(Context(ldcontext.New("")),
+		ldvalue.OptionalInt{}))
+	assert.Error(t, err)
+}
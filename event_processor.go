@@ -1,6 +1,7 @@
 package ldevents
 
 import (
+	"context"
 	"encoding/json"
 	"sync"
 	"time"
@@ -19,6 +20,13 @@ type anyEventOutput interface{}
 
 type defaultEventProcessor struct {
 	inboxCh       chan eventDispatcherMessage
+	closedCh      chan struct{}
+	clockSkew     *clockSkewEstimator
+	limitStats    *contextLimitStats
+	overflowStats *summaryOverflowStats
+	flushes       *flushTracker
+	workers       *flushWorkerPool
+	idleNotifier  idleNotifier
 	inboxFullOnce sync.Once
 	closeOnce     sync.Once
 	loggers       ldlog.Loggers
@@ -27,22 +35,40 @@ type defaultEventProcessor struct {
 type eventDispatcher struct {
 	config               EventsConfiguration
 	outbox               *eventsOutbox
-	flushCh              chan *flushPayload
-	senderResultCh       chan EventSenderResult
-	workersGroup         *sync.WaitGroup
+	formatter            *eventOutputFormatter
+	workers              *flushWorkerPool
+	workersGroup         *flushTracker
 	userKeys             lruCache
 	lastKnownPastTime    ldtime.UnixMillisecondTime
+	clockSkew            *clockSkewEstimator
 	deduplicatedContexts int
 	eventsInLastBatch    int
+	eventsFlushed        int
 	disabled             bool
-	currentTimestampFn   func() ldtime.UnixMillisecondTime
+	clock                Clock
 	sampler              *ldsampling.RatioSampler
 }
 
+// flushWorkerPool is a fixed-size set of goroutines that deliver flush payloads. Each payload
+// carries a reference to the eventDispatcher that produced it, so one pool can be shared by
+// several dispatchers (see multiEnvironmentEventProcessor).
+type flushWorkerPool struct {
+	flushCh        chan *flushPayload
+	senderResultCh chan flushResult
+}
+
 type flushPayload struct {
+	dispatcher      *eventDispatcher
 	diagnosticEvent ldvalue.Value
 	events          []anyEventOutput
-	summary         eventSummary
+	summaries       []eventSummary
+	metrics         metricSummary // also in events, if it is to be sent
+	clockOffset     time.Duration
+}
+
+type flushResult struct {
+	dispatcher *eventDispatcher
+	result     EventSenderResult
 }
 
 // Payload of the inboxCh channel.
@@ -71,10 +97,16 @@ const (
 // NewDefaultEventProcessor creates an instance of the default implementation of analytics event processing.
 func NewDefaultEventProcessor(config EventsConfiguration) EventProcessor {
 	inboxCh := make(chan eventDispatcherMessage, config.Capacity)
-	startEventDispatcher(config, inboxCh)
+	ed := startEventDispatcher(config, inboxCh)
 	return &defaultEventProcessor{
-		inboxCh: inboxCh,
-		loggers: config.Loggers,
+		inboxCh:       inboxCh,
+		closedCh:      make(chan struct{}),
+		clockSkew:     ed.clockSkew,
+		limitStats:    ed.formatter.contextFormatter.limitStats,
+		overflowStats: ed.outbox.summarizer.overflowStats,
+		flushes:       ed.workersGroup,
+		workers:       ed.workers,
+		loggers:       config.Loggers,
 	}
 }
 
@@ -120,6 +152,39 @@ func (ep *defaultEventProcessor) FlushBlocking(timeout time.Duration) bool {
 	}
 }
 
+func (ep *defaultEventProcessor) WaitForIdle(ctx context.Context) error {
+	return waitForIdle(ctx, ep.closedCh, ep.sync, ep.busy)
+}
+
+func (ep *defaultEventProcessor) Idle() <-chan struct{} {
+	return ep.idleNotifier.idle(ep.WaitForIdle)
+}
+
+// sync waits until all events prior to this call have been processed, and all flushes that were
+// in progress have completed and had their results processed.
+func (ep *defaultEventProcessor) sync(ctx context.Context) error {
+	m := syncEventsMessage{replyCh: make(chan struct{}, 1)}
+	return postAndAwaitSync(ctx, ep.inboxCh, ep.closedCh, m, m.replyCh)
+}
+
+// busy returns true if, after a sync, there are events waiting to be processed, a flush in progress,
+// or a flush result waiting to be processed.
+func (ep *defaultEventProcessor) busy() bool {
+	return len(ep.inboxCh) > 0 || ep.flushes.inProgress() || len(ep.workers.senderResultCh) > 0
+}
+
+func (ep *defaultEventProcessor) ClockSkew() (time.Duration, bool) {
+	return ep.clockSkew.estimate()
+}
+
+func (ep *defaultEventProcessor) ContextLimitStats() ContextLimitStats {
+	return ep.limitStats.get()
+}
+
+func (ep *defaultEventProcessor) SummaryOverflowEvaluations() int {
+	return ep.overflowStats.get()
+}
+
 func (ep *defaultEventProcessor) postNonBlockingMessageToInbox(e eventDispatcherMessage) {
 	select {
 	case ep.inboxCh <- e:
@@ -144,6 +209,7 @@ func (ep *defaultEventProcessor) Close() error {
 		m := shutdownEventsMessage{replyCh: make(chan struct{})}
 		ep.inboxCh <- m
 		<-m.replyCh
+		close(ep.closedCh)
 	})
 	return nil
 }
@@ -151,37 +217,79 @@ func (ep *defaultEventProcessor) Close() error {
 func startEventDispatcher(
 	config EventsConfiguration,
 	inboxCh <-chan eventDispatcherMessage,
-) {
-	ed := &eventDispatcher{
-		config:             config,
-		outbox:             newEventsOutbox(config.Capacity, config.Loggers),
-		flushCh:            make(chan *flushPayload, 1),
-		senderResultCh:     make(chan EventSenderResult, maxFlushWorkers),
-		workersGroup:       &sync.WaitGroup{},
-		userKeys:           newLruCache(config.UserKeysCapacity),
-		currentTimestampFn: config.currentTimeProvider,
-		sampler:            ldsampling.NewSampler(),
+) *eventDispatcher {
+	// The flush channel has a buffer size of 1, so at most one payload can be waiting for a free worker.
+	workers := newFlushWorkerPool(maxFlushWorkers, 1)
+	ed := newEventDispatcher(config, workers)
+	go ed.runMainLoop(inboxCh)
+	return ed
+}
+
+func newEventDispatcher(config EventsConfiguration, workers *flushWorkerPool) *eventDispatcher {
+	ed := newEventDispatcherState(config)
+	ed.workers = workers
+
+	if config.DiagnosticsManager != nil {
+		event := config.DiagnosticsManager.CreateInitEvent()
+		ed.sendDiagnosticsEvent(event)
 	}
+	return ed
+}
 
-	if ed.currentTimestampFn == nil {
-		ed.currentTimestampFn = ldtime.UnixMillisNow
+// newEventDispatcherState creates an eventDispatcher that is not yet connected to any flush workers.
+// It is used directly by SynchronousEventProcessor, which does its own delivery.
+func newEventDispatcherState(config EventsConfiguration) *eventDispatcher {
+	ed := &eventDispatcher{
+		config:       config,
+		outbox:       newEventsOutbox(config),
+		workersGroup: &flushTracker{},
+		userKeys:     newLruCache(config.UserKeysCapacity),
+		clock:        clockOrDefault(config.Clock),
+		clockSkew:    &clockSkewEstimator{},
+		sampler:      ldsampling.NewSampler(),
 	}
 
-	formatter := &eventOutputFormatter{
+	ed.formatter = &eventOutputFormatter{
 		contextFormatter: newEventContextFormatter(config),
 		config:           config,
 	}
+	return ed
+}
 
-	// Start a fixed-size pool of workers that wait on flushTriggerCh. This is the
-	// maximum number of flushes we can do concurrently.
-	for i := 0; i < maxFlushWorkers; i++ {
-		go runFlushTask(config, formatter, ed.flushCh, ed.workersGroup, ed.senderResultCh)
+// newFlushWorkerPool starts a fixed-size pool of workers that wait on flushCh. This is the
+// maximum number of flushes we can do concurrently. The queueSize is the number of payloads
+// that can be waiting for a free worker before triggerFlush gives up and retains the events.
+func newFlushWorkerPool(workerCount, queueSize int) *flushWorkerPool {
+	p := &flushWorkerPool{
+		flushCh: make(chan *flushPayload, queueSize),
+		// Every queued or in-progress payload can produce at most one result, so with this capacity
+		// a worker never blocks on reporting a result even if the main loop is busy waiting.
+		senderResultCh: make(chan flushResult, workerCount+queueSize),
 	}
-	if config.DiagnosticsManager != nil {
-		event := config.DiagnosticsManager.CreateInitEvent()
-		ed.sendDiagnosticsEvent(event)
+	for i := 0; i < workerCount; i++ {
+		go runFlushTask(p.flushCh, p.senderResultCh)
 	}
-	go ed.runMainLoop(inboxCh)
+	return p
+}
+
+// drainResults processes any flush results that are waiting, without blocking. It must be called
+// from the main loop.
+func (p *flushWorkerPool) drainResults() {
+	for {
+		select {
+		case r := <-p.senderResultCh:
+			r.dispatcher.handleSenderResult(r.result)
+		default:
+			return
+		}
+	}
+}
+
+// close causes all idle flush workers to terminate. The caller must ensure that no more payloads
+// will be submitted and that all in-progress flushes have completed.
+func (p *flushWorkerPool) close() {
+	close(p.flushCh)
+	close(p.senderResultCh)
 }
 
 func (ed *eventDispatcher) runMainLoop(
@@ -199,10 +307,10 @@ func (ed *eventDispatcher) runMainLoop(
 	if userKeysFlushInterval <= 0 { // COVERAGE: no way to test this logic in unit tests
 		userKeysFlushInterval = DefaultUserKeysFlushInterval
 	}
-	flushTicker := time.NewTicker(flushInterval)
-	usersResetTicker := time.NewTicker(userKeysFlushInterval)
+	flushTicker := ed.clock.NewTicker(flushInterval)
+	usersResetTicker := ed.clock.NewTicker(userKeysFlushInterval)
 
-	var diagnosticsTicker *time.Ticker
+	var diagnosticsTicker Ticker
 	var diagnosticsTickerCh <-chan time.Time
 	diagnosticsManager := ed.config.DiagnosticsManager
 	if diagnosticsManager != nil {
@@ -218,8 +326,8 @@ func (ed *eventDispatcher) runMainLoop(
 				interval = DefaultDiagnosticRecordingInterval
 			}
 		}
-		diagnosticsTicker = time.NewTicker(interval)
-		diagnosticsTickerCh = diagnosticsTicker.C
+		diagnosticsTicker = ed.clock.NewTicker(interval)
+		diagnosticsTickerCh = diagnosticsTicker.C()
 	}
 
 	for {
@@ -238,6 +346,7 @@ func (ed *eventDispatcher) runMainLoop(
 				}
 			case syncEventsMessage:
 				ed.workersGroup.Wait()
+				ed.workers.drainResults()
 				m.replyCh <- struct{}{}
 			case shutdownEventsMessage:
 				flushTicker.Stop()
@@ -246,43 +355,73 @@ func (ed *eventDispatcher) runMainLoop(
 					diagnosticsTicker.Stop()
 				}
 				ed.workersGroup.Wait() // Wait for all in-progress flushes to complete
-				close(ed.flushCh)      // Causes all idle flush workers to terminate
-				close(ed.senderResultCh)
+				ed.workers.close()     // Causes all idle flush workers to terminate
 				m.replyCh <- struct{}{}
 				return
 			}
-		case result := <-ed.senderResultCh:
-			switch {
-			case ed.disabled: // COVERAGE: no way to simulate in unit tests
-				continue
-			case result.MustShutDown:
-				ed.disabled = true
-				ed.outbox.clear()
-			case result.TimeFromServer > 0:
-				ed.lastKnownPastTime = result.TimeFromServer
-			}
-		case <-flushTicker.C:
+		case r := <-ed.workers.senderResultCh:
+			r.dispatcher.handleSenderResult(r.result)
+		case <-flushTicker.C():
 			ed.triggerFlush()
-		case <-usersResetTicker.C:
+		case <-usersResetTicker.C():
 			ed.userKeys.clear()
 		case <-diagnosticsTickerCh:
-			if diagnosticsManager == nil || !diagnosticsManager.CanSendStatsEvent() {
-				// COVERAGE: no way to test this logic in unit tests
-				break
-			}
-			event := diagnosticsManager.CreateStatsEventAndReset(
-				ed.outbox.droppedEvents,
-				ed.deduplicatedContexts,
-				ed.eventsInLastBatch,
-			)
-			ed.outbox.droppedEvents = 0
-			ed.deduplicatedContexts = 0
-			ed.eventsInLastBatch = 0
-			ed.sendDiagnosticsEvent(event)
+			ed.sendStatsEvent()
 		}
 	}
 }
 
+func (ed *eventDispatcher) handleSenderResult(result EventSenderResult) {
+	switch {
+	case ed.disabled: // COVERAGE: no way to simulate in unit tests
+		return
+	case result.MustShutDown:
+		ed.disabled = true
+		ed.outbox.clear()
+	case result.TimeFromServer > 0:
+		ed.lastKnownPastTime = result.TimeFromServer
+		ed.clockSkew.addSample(result.TimeFromServer, unixMillisNow(ed.clock))
+	}
+}
+
+// outputClockOffset returns the amount by which to adjust timestamps in output events: the
+// estimated clock skew if CorrectClockSkew is enabled, or zero otherwise.
+func (ed *eventDispatcher) outputClockOffset() time.Duration {
+	if !ed.config.CorrectClockSkew {
+		return 0
+	}
+	offset, _ := ed.clockSkew.estimate()
+	return offset
+}
+
+// sendStatsEvent sends a periodic diagnostic event, if this dispatcher has a DiagnosticsManager, and
+// resets the counters that it reports. It returns the values of the dropped event and deduplicated
+// context counters prior to the reset.
+func (ed *eventDispatcher) sendStatsEvent() (droppedEvents, deduplicatedContexts int) {
+	diagnosticsManager := ed.config.DiagnosticsManager
+	if diagnosticsManager == nil || !diagnosticsManager.CanSendStatsEvent() {
+		// COVERAGE: no way to test this logic in unit tests
+		return 0, 0
+	}
+	droppedEvents, deduplicatedContexts = ed.outbox.droppedEvents, ed.deduplicatedContexts
+	var summaryOverflowEvaluations ldvalue.OptionalInt
+	if ed.config.SummaryFlagsCapacity > 0 || ed.config.SummaryCountersPerFlagCapacity > 0 {
+		summaryOverflowEvaluations = ldvalue.NewOptionalInt(ed.outbox.summarizer.overflowCount)
+	}
+	event := diagnosticsManager.createStatsEventAndReset(
+		droppedEvents,
+		deduplicatedContexts,
+		ed.eventsInLastBatch,
+		summaryOverflowEvaluations,
+	)
+	ed.outbox.summarizer.overflowCount = 0
+	ed.outbox.droppedEvents = 0
+	ed.deduplicatedContexts = 0
+	ed.eventsInLastBatch = 0
+	ed.sendDiagnosticsEvent(event)
+	return droppedEvents, deduplicatedContexts
+}
+
 func (ed *eventDispatcher) processEvent(evt anyEventInput) {
 	if ed.disabled {
 		return
@@ -336,17 +475,38 @@ func (ed *eventDispatcher) processEvent(evt anyEventInput) {
 
 		eventContext = evt.Context
 		creationDate = evt.CreationDate
+
+		if ed.outbox.metrics.shouldAggregate(evt) {
+			if ed.shouldSample(samplingRatio) {
+				ed.outbox.metrics.aggregate(evt, samplingRatio.OrElse(1))
+			}
+			// The aggregated data does not refer to individual contexts, so there is no index event.
+			return
+		}
 	case MigrationOpEventData:
 		samplingRatio = evt.SamplingRatio
 		if evt.ForceSampling {
 			samplingRatio = ldvalue.NewOptionalInt(1)
 		}
 
+		if ed.config.MigrationOpAggregator != nil {
+			ed.config.MigrationOpAggregator.Record(evt)
+		}
+
 		if ed.shouldSample(samplingRatio) {
 			ed.outbox.addEvent(evt)
 		}
 		// We can halt execution here as a migration event shouldn't generate an index or debug event.
 		return
+	case rawEvent:
+		if ed.config.MergeRawSummaryEvents {
+			if summary, ok := readSummaryEvent(evt.data); ok {
+				ed.outbox.mergeSummary(summary)
+				return
+			}
+		}
+		ed.outbox.addEvent(evt)
+		return
 	default:
 		ed.outbox.addEvent(evt)
 		return
@@ -381,32 +541,21 @@ func (ed *eventDispatcher) shouldDebugEvent(evt *EvaluationData) bool {
 	// earlier than that point is definitely in the past.  If there's any discrepancy, we
 	// want to err on the side of cutting off event debugging sooner.
 	return evt.DebugEventsUntilDate > ed.lastKnownPastTime &&
-		evt.DebugEventsUntilDate > ed.currentTimestampFn()
+		evt.DebugEventsUntilDate > adjustTimestamp(unixMillisNow(ed.clock), ed.outputClockOffset())
 }
 
 // Signal that we would like to do a flush as soon as possible.
 func (ed *eventDispatcher) triggerFlush() {
-	if ed.disabled {
-		return
-	}
-	// Is there anything to flush?
-	payload := ed.outbox.getPayload()
-	totalEventCount := len(payload.events)
-	if payload.summary.hasCounters() {
-		totalEventCount++
-	}
-	if totalEventCount == 0 {
-		ed.eventsInLastBatch = 0
+	payload := ed.preparePayload()
+	if payload == nil {
 		return
 	}
 	ed.workersGroup.Add(1) // Increment the count of active flushes
 	select {
-	case ed.flushCh <- &payload:
+	case ed.workers.flushCh <- payload:
 		// If the channel wasn't full, then there is a worker available who will pick up
-		// this flush payload and send it. The event outbox and summary state can now be
-		// cleared from the main goroutine.
-		ed.eventsInLastBatch = totalEventCount
-		ed.outbox.clear()
+		// this flush payload and send it.
+		ed.payloadQueued(payload)
 	default:
 		// We can't start a flush right now because we're waiting for one of the workers
 		// to pick up the last one.  Do not reset the event outbox or summary state.
@@ -414,13 +563,48 @@ func (ed *eventDispatcher) triggerFlush() {
 	}
 }
 
+// preparePayload returns a flush payload containing the buffered events, or nil if there is
+// nothing to flush.
+func (ed *eventDispatcher) preparePayload() *flushPayload {
+	if ed.disabled {
+		return nil
+	}
+	// Is there anything to flush?
+	payload := ed.outbox.getPayload()
+	if len(payload.events)+len(payload.summaries) == 0 && !payload.metrics.hasData() {
+		ed.eventsInLastBatch = 0
+		return nil
+	}
+	payload.dispatcher = ed
+	payload.clockOffset = ed.outputClockOffset()
+	return &payload
+}
+
+// payloadQueued is called after a payload from preparePayload has been handed to the flush
+// workers. The event outbox and summary state can now be cleared from the main goroutine.
+func (ed *eventDispatcher) payloadQueued(payload *flushPayload) {
+	totalEventCount := len(payload.events) + len(payload.summaries)
+	ed.eventsInLastBatch = totalEventCount
+	ed.eventsFlushed += totalEventCount
+	ed.outbox.clear()
+}
+
+// reportMetricSummary passes the aggregated custom metric data to EventsConfiguration.OnMetricSummary,
+// if there is any. This is done by a flush worker, so that the callback cannot hold up the
+// processing of events; for a SynchronousEventProcessor, it is done by Flush.
+func (ed *eventDispatcher) reportMetricSummary(metrics metricSummary) {
+	if ed.config.OnMetricSummary != nil && metrics.hasData() {
+		ed.config.OnMetricSummary(metrics.export())
+	}
+}
+
 func (ed *eventDispatcher) sendDiagnosticsEvent(
 	event ldvalue.Value,
 ) {
-	payload := flushPayload{diagnosticEvent: event}
+	payload := flushPayload{dispatcher: ed, diagnosticEvent: event}
 	ed.workersGroup.Add(1) // Increment the count of active flushes
 	select {
-	case ed.flushCh <- &payload:
+	case ed.workers.flushCh <- &payload:
 		// If the channel wasn't full, then there is a worker available who will pick up
 		// this flush payload and send it.
 	default:
@@ -440,26 +624,28 @@ func (ed *eventDispatcher) shouldSample(ratio ldvalue.OptionalInt) bool {
 	return ed.sampler.Sample(ratio.OrElse(1))
 }
 
-func runFlushTask(config EventsConfiguration, formatter *eventOutputFormatter, flushCh <-chan *flushPayload,
-	workersGroup *sync.WaitGroup, senderResultCh chan<- EventSenderResult) {
+func runFlushTask(flushCh <-chan *flushPayload, senderResultCh chan<- flushResult) {
 	for {
 		payload, more := <-flushCh
 		if !more {
 			// Channel has been closed - we're shutting down
 			break
 		}
+		ed := payload.dispatcher
 		if !payload.diagnosticEvent.IsNull() {
 			w := jwriter.NewWriter()
 			payload.diagnosticEvent.WriteToJSONWriter(&w)
 			bytes := w.Bytes()
-			_ = config.EventSender.SendEventData(DiagnosticEventDataKind, bytes, 1)
+			_ = ed.config.EventSender.SendEventData(DiagnosticEventDataKind, bytes, 1)
 		} else {
-			bytes, count := formatter.makeOutputEvents(payload.events, payload.summary)
+			ed.reportMetricSummary(payload.metrics)
+			formatter := ed.formatter.withClockOffset(payload.clockOffset)
+			bytes, count := formatter.makeOutputEvents(payload.events, payload.summaries...)
 			if len(bytes) > 0 {
-				result := config.EventSender.SendEventData(AnalyticsEventDataKind, bytes, count)
-				senderResultCh <- result
+				result := ed.config.EventSender.SendEventData(AnalyticsEventDataKind, bytes, count)
+				senderResultCh <- flushResult{dispatcher: ed, result: result}
 			}
 		}
-		workersGroup.Done() // Decrement the count of in-progress flushes
+		ed.workersGroup.Done() // Decrement the count of in-progress flushes
 	}
 }
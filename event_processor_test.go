@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldlog"
+	"github.com/launchdarkly/go-sdk-common/v3/ldlogtest"
 	"github.com/launchdarkly/go-sdk-common/v3/ldmigration"
 	"github.com/launchdarkly/go-sdk-common/v3/ldreason"
 	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
@@ -370,6 +372,61 @@ func TestFeatureEventIsSummarizedAndNotTrackedByDefault(t *testing.T) {
 	})
 }
 
+func TestSummaryEventCountsReasonsIfConfigured(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.SummarizeReasons = true
+	ep, es := createEventProcessorAndSender(config)
+	defer ep.Close()
+
+	flag := FlagEventProperties{Key: "flagkey", Version: 11}
+	detail := ldreason.NewEvaluationDetail(ldvalue.String("value"), 1, ldreason.NewEvalReasonFallthrough())
+	fe := NewEventFactory(true, fakeTimeFn).NewEvaluationData(flag, basicContext(), detail, false, ldvalue.Null(), "",
+		ldvalue.OptionalInt{}, false)
+	ep.RecordEvaluation(fe)
+	ep.Flush()
+
+	assertEventsReceived(t, es,
+		anyIndexEvent(),
+		m.AllOf(
+			anySummaryEvent(),
+			m.JSONProperty("features").Should(m.JSONProperty(flag.Key).Should(
+				m.JSONProperty("reasons").Should(m.JSONStrEqual(`[{"kind":"FALLTHROUGH","count":1}]`)),
+			)),
+		),
+	)
+	es.assertNoMoreEvents(t)
+}
+
+func TestSummaryEventsAreBucketedByTimeIfConfigured(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.SummaryBucketInterval = time.Minute
+	ep, es := createEventProcessorAndSender(config)
+	defer ep.Close()
+
+	flag := FlagEventProperties{Key: "flagkey", Version: 11}
+	for _, minutes := range []int{0, 2, 0} {
+		fe := defaultEventFactory.NewEvaluationData(flag, basicContext(), testEvalDetailWithoutReason, false,
+			ldvalue.Null(), "", ldvalue.OptionalInt{}, false)
+		fe.CreationDate = fakeTime + ldtime.UnixMillisecondTime(minutes*60000)
+		ep.RecordEvaluation(fe)
+	}
+	ep.Flush()
+
+	windowStart := fakeTime - fakeTime%60000
+	assertEventsReceived(t, es,
+		anyIndexEvent(),
+		m.AllOf(
+			summaryEventWithFlag(flag, summaryCounterPropsFromEval(testEvalDetailWithoutReason, 2)),
+			m.JSONProperty("startDate").Should(equalNumericTime(windowStart)),
+		),
+		m.AllOf(
+			summaryEventWithFlag(flag, summaryCounterPropsFromEval(testEvalDetailWithoutReason, 1)),
+			m.JSONProperty("startDate").Should(equalNumericTime(windowStart+120000)),
+		),
+	)
+	es.assertNoMoreEvents(t)
+}
+
 func TestFeatureEventCanBeExcludeFromSummaries(t *testing.T) {
 	withAndWithoutPrivateAttrs(t, func(t *testing.T, config EventsConfiguration) {
 		ep, es := createEventProcessorAndSender(config)
@@ -570,8 +627,8 @@ func TestFeatureEventCanContainReason(t *testing.T) {
 func TestDebugEventIsAddedIfFlagIsTemporarilyInDebugMode(t *testing.T) {
 	fakeTimeNow := ldtime.UnixMillisecondTime(1000000)
 	config := basicConfigWithoutPrivateAttrs()
-	config.currentTimeProvider = func() ldtime.UnixMillisecondTime { return fakeTimeNow }
-	eventFactory := NewEventFactory(false, config.currentTimeProvider)
+	config.Clock = newTestClock(fakeTimeNow)
+	eventFactory := NewEventFactoryWithClock(false, config.Clock)
 
 	ep, es := createEventProcessorAndSender(config)
 	defer ep.Close()
@@ -594,8 +651,8 @@ func TestDebugEventIsAddedIfFlagIsTemporarilyInDebugMode(t *testing.T) {
 func TestEventCanBeBothTrackedAndDebugged(t *testing.T) {
 	fakeTimeNow := ldtime.UnixMillisecondTime(1000000)
 	config := basicConfigWithoutPrivateAttrs()
-	config.currentTimeProvider = func() ldtime.UnixMillisecondTime { return fakeTimeNow }
-	eventFactory := NewEventFactory(false, config.currentTimeProvider)
+	config.Clock = newTestClock(fakeTimeNow)
+	eventFactory := NewEventFactoryWithClock(false, config.Clock)
 
 	ep, es := createEventProcessorAndSender(config)
 	defer ep.Close()
@@ -619,10 +676,10 @@ func TestEventCanBeBothTrackedAndDebugged(t *testing.T) {
 func TestDebugModeExpiresBasedOnClientTimeIfClientTimeIsLater(t *testing.T) {
 	fakeTimeNow := ldtime.UnixMillisecondTime(1000000)
 	config := basicConfigWithoutPrivateAttrs()
-	config.currentTimeProvider = func() ldtime.UnixMillisecondTime { return fakeTimeNow }
-	eventFactory := NewEventFactory(false, config.currentTimeProvider)
+	config.Clock = newTestClock(fakeTimeNow)
+	eventFactory := NewEventFactoryWithClock(false, config.Clock)
 
-	ep, es := createEventProcessorAndSender(basicConfigWithoutPrivateAttrs())
+	ep, es := createEventProcessorAndSender(config)
 	defer ep.Close()
 
 	// Pick a server time that is somewhat behind the client time
@@ -651,10 +708,10 @@ func TestDebugModeExpiresBasedOnClientTimeIfClientTimeIsLater(t *testing.T) {
 func TestDebugModeExpiresBasedOnServerTimeIfServerTimeIsLater(t *testing.T) {
 	fakeTimeNow := ldtime.UnixMillisecondTime(1000000)
 	config := basicConfigWithoutPrivateAttrs()
-	config.currentTimeProvider = func() ldtime.UnixMillisecondTime { return fakeTimeNow }
-	eventFactory := NewEventFactory(false, config.currentTimeProvider)
+	config.Clock = newTestClock(fakeTimeNow)
+	eventFactory := NewEventFactoryWithClock(false, config.Clock)
 
-	ep, es := createEventProcessorAndSender(basicConfigWithoutPrivateAttrs())
+	ep, es := createEventProcessorAndSender(config)
 	defer ep.Close()
 
 	// Pick a server time that is somewhat ahead of the client time
@@ -776,6 +833,183 @@ func TestRawEventIsQueued(t *testing.T) {
 	es.assertNoMoreEvents(t)
 }
 
+func TestRawSummaryEventsAreMergedIfConfigured(t *testing.T) {
+	rawSummary1 := json.RawMessage(`{"kind":"summary","startDate":1000,"endDate":2000,"features":{"flagkey":
+		{"default":"dv","counters":[{"version":11,"variation":1,"value":"a","count":2}],"contextKinds":["user"]}}}`)
+	rawSummary2 := json.RawMessage(`{"kind":"summary","startDate":1500,"endDate":3000,"features":{"flagkey":
+		{"default":"dv","counters":[{"version":11,"variation":1,"value":"a","count":3}],"contextKinds":["org"]}}}`)
+	otherRawEvent := json.RawMessage(`{"kind":"alias","key":"summary"}`)
+	invalidRawSummary := json.RawMessage(`{"kind":"summary","startDate":1000}`)
+
+	t.Run("disabled", func(t *testing.T) {
+		ep, es := createEventProcessorAndSender(basicConfigWithoutPrivateAttrs())
+		defer ep.Close()
+
+		ep.RecordRawEvent(rawSummary1)
+		ep.RecordRawEvent(rawSummary2)
+		ep.Flush()
+
+		assertEventsReceived(t, es, m.JSONEqual(rawSummary1), m.JSONEqual(rawSummary2))
+		es.assertNoMoreEvents(t)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		config := basicConfigWithoutPrivateAttrs()
+		config.MergeRawSummaryEvents = true
+		ep, es := createEventProcessorAndSender(config)
+		defer ep.Close()
+
+		ep.RecordRawEvent(rawSummary1)
+		ep.RecordRawEvent(otherRawEvent)
+		ep.RecordRawEvent(invalidRawSummary)
+		ep.RecordRawEvent(rawSummary2)
+		ep.Flush()
+
+		assertEventsReceived(t, es,
+			m.JSONEqual(otherRawEvent),
+			m.JSONEqual(invalidRawSummary),
+			m.AllOf(
+				anySummaryEvent(),
+				m.JSONProperty("startDate").Should(equalNumericTime(1000)),
+				m.JSONProperty("endDate").Should(equalNumericTime(3000)),
+				m.JSONProperty("features").Should(m.JSONProperty("flagkey").Should(m.MapOf(
+					m.KV("default", m.Equal("dv")),
+					m.KV("counters", m.JSONStrEqual(`[{"version":11,"variation":1,"value":"a","count":5}]`)),
+					m.KV("contextKinds", m.ItemsInAnyOrder(m.Equal("user"), m.Equal("org"))),
+				))),
+			),
+		)
+		es.assertNoMoreEvents(t)
+	})
+}
+
+func TestCustomMetricEventsAreAggregatedIfConfigured(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.Capacity = 2
+	config.AggregatedMetricEventKeys = []string{"latency"}
+	config.MetricHistogramBounds = []float64{100}
+	config.SendMetricSummaryEvents = true
+	// Using a SynchronousEventProcessor, since the inbox of the default one has the same capacity
+	ep, es := createSynchronousEventProcessorAndSender(config)
+	defer ep.Close()
+
+	context := basicContext()
+	for i := 0; i < 10; i++ {
+		ep.RecordCustomEvent(defaultEventFactory.NewCustomEventData("latency", context, ldvalue.Null(), true,
+			float64(i*20), ldvalue.OptionalInt{}))
+	}
+	ce := defaultEventFactory.NewCustomEventData("other", context, ldvalue.Null(), true, 1, ldvalue.OptionalInt{})
+	ep.RecordCustomEvent(ce)
+	ep.Flush()
+
+	assertEventsReceived(t, es,
+		anyIndexEvent(),
+		m.AllOf(eventKindIs("custom"), m.JSONProperty("key").Should(m.Equal("other"))),
+		m.AllOf(
+			eventKindIs(MetricSummaryEventKind),
+			m.JSONProperty("histogramBounds").Should(m.JSONStrEqual(`[100]`)),
+			m.JSONProperty("metrics").Should(m.JSONStrEqual(`[{"key":"latency","contextKind":"user",
+				"count":10,"sum":900,"min":0,"max":180,"histogram":[6,4]}]`)),
+		),
+	)
+	es.assertNoMoreEvents(t)
+
+	// The aggregated data is reset after each flush
+	ep.RecordCustomEvent(ce)
+	ep.Flush()
+	assertEventsReceived(t, es, m.AllOf(eventKindIs("custom"), m.JSONProperty("key").Should(m.Equal("other"))))
+	es.assertNoMoreEvents(t)
+}
+
+func TestCustomMetricSummaryIsPassedToCallbackAndNotSentByDefault(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.AggregatedMetricEventKeys = []string{"latency"}
+	config.MetricHistogramBounds = []float64{100}
+	config.forceSampling = true
+	summaryCh := make(chan MetricSummary, 10)
+	config.OnMetricSummary = func(summary MetricSummary) { summaryCh <- summary }
+	ep, es := createEventProcessorAndSender(config)
+	defer ep.Close()
+
+	context := basicContext()
+	ce := defaultEventFactory.NewCustomEventData("latency", context, ldvalue.Null(), true, 150, ldvalue.OptionalInt{})
+	ep.RecordCustomEvent(ce)
+	ce.SamplingRatio = ldvalue.NewOptionalInt(4) // counted as 4 events, since 3 others were not sampled
+	ep.RecordCustomEvent(ce)
+	ep.Flush()
+
+	select {
+	case summary := <-summaryCh:
+		assert.Equal(t, []float64{100}, summary.HistogramBounds)
+		assert.Equal(t, []MetricAggregate{{Key: "latency", ContextKind: ldcontext.DefaultKind, Count: 5, Sum: 750,
+			Min: 150, Max: 150, Histogram: []int{0, 5}}}, summary.Metrics)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for metric summary")
+	}
+	es.assertNoMoreEvents(t)
+}
+
+func TestCustomMetricEventsAreNotAggregatedWithoutDestination(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.AggregatedMetricEventKeys = []string{"latency"}
+	ep, es := createSynchronousEventProcessorAndSender(config)
+	defer ep.Close()
+
+	ep.RecordCustomEvent(defaultEventFactory.NewCustomEventData("latency", basicContext(), ldvalue.Null(), true, 1,
+		ldvalue.OptionalInt{}))
+	ep.Flush()
+
+	assertEventsReceived(t, es,
+		anyIndexEvent(),
+		m.AllOf(eventKindIs("custom"), m.JSONProperty("metricValue").Should(m.Equal(1))),
+	)
+	es.assertNoMoreEvents(t)
+}
+
+func TestMigrationOpAggregatorSeesUnsampledOperations(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.MigrationOpAggregator = NewMigrationOpAggregator(MigrationOpAggregatorConfig{})
+	ep, es := createSynchronousEventProcessorAndSender(config)
+	defer ep.Close()
+
+	op := newMigrationOp("flag", ldmigration.Write).invoked(ldmigration.Old, 10).failed(ldmigration.New).evt
+	op.SamplingRatio = ldvalue.NewOptionalInt(0)
+	ep.RecordMigrationOpEvent(op)
+	ep.Flush()
+	es.assertNoMoreEvents(t)
+
+	assert.Equal(t, map[MigrationOpKey]MigrationOpStats{
+		{"flag", ldmigration.Write, ldmigration.Old}: {Invocations: 1, LatencySamples: 1,
+			LatencyP50: 10 * time.Millisecond, LatencyP90: 10 * time.Millisecond, LatencyP99: 10 * time.Millisecond},
+		{"flag", ldmigration.Write, ldmigration.New}: {Invocations: 1, Errors: 1},
+	}, config.MigrationOpAggregator.AllStats())
+}
+
+func TestEventWithMalformedPreserializedContextIsDiscarded(t *testing.T) {
+	mockLog := ldlogtest.NewMockLog()
+	config := basicConfigWithoutPrivateAttrs()
+	config.AllAttributesPrivate = true
+	config.RedactPreserializedContexts = true
+	config.Loggers = mockLog.Loggers
+	ep, es := createSynchronousEventProcessorAndSender(config)
+	defer ep.Close()
+
+	malformed := PreserializedContext(ldcontext.New("bad-key"), json.RawMessage(`{"kind":"user"}`))
+	wellFormed := PreserializedContext(ldcontext.New("good-key"), json.RawMessage(`{"kind":"user","key":"good-key"}`))
+	ep.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(wellFormed, ldvalue.OptionalInt{}))
+	ep.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(malformed, ldvalue.OptionalInt{}))
+	ep.RecordCustomEvent(defaultEventFactory.NewCustomEventData("key", wellFormed, ldvalue.Null(), false, 0,
+		ldvalue.OptionalInt{}))
+	ep.Flush()
+
+	assertEventsReceived(t, es,
+		m.AllOf(anyIdentifyEvent(), m.JSONProperty("context").Should(m.JSONProperty("key").Should(m.Equal("good-key")))),
+		m.AllOf(eventKindIs("custom"), m.JSONProperty("key").Should(m.Equal("key"))),
+	)
+	es.assertNoMoreEvents(t)
+	assert.Len(t, mockLog.GetOutput(ldlog.Warn), 1)
+}
+
 func TestShutdownPreventsFurtherEventsFromReachingSender(t *testing.T) {
 	ep, es := createEventProcessorAndSender(basicConfigWithoutPrivateAttrs())
 	defer ep.Close()
@@ -1001,7 +1235,8 @@ func TestDiagnosticPeriodicEventHasEventCounters(t *testing.T) {
 	ep.RecordCustomEvent(defaultEventFactory.NewCustomEventData("key", context, ldvalue.Null(), false, 0, ldvalue.OptionalInt{}))
 	ep.RecordCustomEvent(defaultEventFactory.NewCustomEventData("key", context, ldvalue.Null(), false, 0, ldvalue.OptionalInt{}))
 	ep.RecordCustomEvent(defaultEventFactory.NewCustomEventData("key", context, ldvalue.Null(), false, 0, ldvalue.OptionalInt{}))
-	ep.Flush()
+	// Wait for the flush, since otherwise the diagnostics ticker could be handled before it.
+	require.True(t, ep.FlushBlocking(time.Second))
 
 	periodicEventGate <- struct{}{} // periodic event won't be sent until we do this
 
@@ -1012,6 +1247,7 @@ func TestDiagnosticPeriodicEventHasEventCounters(t *testing.T) {
 		m.JSONProperty("droppedEvents").Should(m.Equal(1)),     // 3rd custom event was dropped
 		m.JSONProperty("deduplicatedUsers").Should(m.Equal(2)),
 	))
+	time1 := requireCreationDate(t, event1)
 
 	periodicEventGate <- struct{}{}
 
@@ -1021,9 +1257,46 @@ func TestDiagnosticPeriodicEventHasEventCounters(t *testing.T) {
 		m.JSONProperty("eventsInLastBatch").Should(m.Equal(0)),
 		m.JSONProperty("droppedEvents").Should(m.Equal(0)),
 		m.JSONProperty("deduplicatedUsers").Should(m.Equal(0)),
+		m.JSONProperty("dataSinceDate").Should(equalNumericTime(time1)),
 	))
 }
 
+func TestDiagnosticPeriodicEventHasSummaryOverflowCount(t *testing.T) {
+	clock := newTestClock(fakeTime)
+	config := basicConfigWithoutPrivateAttrs()
+	config.Clock = clock
+	config.DiagnosticRecordingInterval = MinimumDiagnosticRecordingInterval
+	config.DiagnosticsManager = NewDiagnosticsManagerWithClock(NewDiagnosticID("sdkkey"), ldvalue.Null(), ldvalue.Null(),
+		clock)
+	config.SummaryFlagsCapacity = 1
+	ep, es := createEventProcessorAndSender(config)
+	defer ep.Close()
+	clock.awaitTickers(t, 3)
+	m.In(t).Assert(es.awaitDiagnosticEvent(t), eventKindIs("diagnostic-init"))
+
+	for _, flagKey := range []string{"flag1", "flag2", "flag3"} {
+		ep.RecordEvaluation(defaultEventFactory.NewEvaluationData(FlagEventProperties{Key: flagKey, Version: 1},
+			basicContext(), testEvalDetailWithoutReason, false, ldvalue.Null(), "", ldvalue.OptionalInt{}, false))
+	}
+	ep.FlushBlocking(time.Second)
+	m.In(t).Assert(es.takeEvents(), m.Items(
+		anyIndexEvent(),
+		m.AllOf(anySummaryEvent(), m.JSONProperty("features").Should(m.MapOf(
+			m.KV("flag1", m.Not(m.BeNil())),
+		))),
+	))
+
+	clock.advance(MinimumDiagnosticRecordingInterval)
+	m.In(t).Assert(es.awaitDiagnosticEvent(t), m.AllOf(
+		eventKindIs("diagnostic"),
+		m.JSONProperty("summaryOverflowEvaluations").Should(m.Equal(2)),
+	))
+
+	clock.advance(MinimumDiagnosticRecordingInterval)
+	m.In(t).Assert(es.awaitDiagnosticEvent(t),
+		m.JSONProperty("summaryOverflowEvaluations").Should(m.Equal(0)))
+}
+
 func TestEventsAreKeptInBufferIfAllFlushWorkersAreBusy(t *testing.T) {
 	// Note that in the current implementation, although the intention was that we would cancel a flush
 	// if there's not an available flush worker, instead what happens is that we will queue *one* flush
@@ -1,17 +1,86 @@
 package ldevents
 
 import (
+	"sort"
+	"sync/atomic"
+
 	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldreason"
 	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
 	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
 )
 
+// SummaryOverflowReporter is an optional interface for EventProcessor implementations that can report
+// how many evaluations were left out of summary events because of EventsConfiguration.SummaryFlagsCapacity
+// or SummaryCountersPerFlagCapacity. It is implemented by the processors returned by
+// NewDefaultEventProcessor and MultiEnvironmentEventProcessor, and by SynchronousEventProcessor.
+//
+// Evaluations are left out when they are summarized, so unlike ContextLimitStats, the total includes
+// evaluations that have not been flushed yet.
+type SummaryOverflowReporter interface {
+	// SummaryOverflowEvaluations returns the total since the processor was created.
+	SummaryOverflowEvaluations() int
+}
+
+// summaryOverflowStats accumulates the total for SummaryOverflowReporter. It is updated by the
+// event-processing goroutine, and may be read from any goroutine.
+type summaryOverflowStats struct {
+	evaluations int64
+}
+
+func (s *summaryOverflowStats) add(count int) {
+	if s != nil {
+		atomic.AddInt64(&s.evaluations, int64(count))
+	}
+}
+
+func (s *summaryOverflowStats) get() int {
+	if s == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&s.evaluations))
+}
+
 // Manages the state of summarizable information for the EventProcessor, including the
 // event counters and user deduplication. Note that the methods for this type are
 // deliberately not thread-safe, because they should always be called from EventProcessor's
 // single event-processing goroutine.
 type eventSummarizer struct {
 	eventsState eventSummary
+	// If bucketMillis is nonzero, evaluations are summarized in buckets instead of eventsState, one
+	// for each window of that many milliseconds, keyed by the start time of the window. See
+	// EventsConfiguration.SummaryBucketInterval. This preserves the rate of evaluations over time even
+	// if the flush interval is long, or if events were buffered while the application was offline.
+	// The startDate of each summary is the start of its window, and the endDate is the time of its
+	// last evaluation.
+	bucketMillis ldtime.UnixMillisecondTime
+	buckets      map[ldtime.UnixMillisecondTime]*eventSummary
+	// If true, evaluations are also counted by reason kind, so that error rates are visible without
+	// full feature events; see EventsConfiguration.SummarizeReasons. Evaluations only have a reason if
+	// the EventFactory was created with includeReasons, or for experiments.
+	summarizeReasons bool
+	// If true, each counter also has a sketch of the fully-qualified keys of the contexts that were
+	// counted; see EventsConfiguration.SummarizeUniqueContexts. Sketches from different summaries can
+	// be merged to estimate the distinct contexts across all of them.
+	summarizeUniqueContexts bool
+	// The maximum number of flags, and of counters for each flag, in a summary; zero means no limit.
+	// See EventsConfiguration.SummaryFlagsCapacity and SummaryCountersPerFlagCapacity. If we are using
+	// time buckets, the limits apply to all of the buckets together, since otherwise nothing would
+	// limit the memory used by a long flush interval.
+	flagsCapacity           int
+	countersPerFlagCapacity int
+	// flagEntries is the number of flag summaries in eventsState and all buckets, and counterEntries
+	// is the number of counters for each flag key in all of them. counterEntries is only maintained
+	// if countersPerFlagCapacity is nonzero.
+	flagEntries    int
+	counterEntries map[string]int
+	// overflowCount is the number of evaluations that were left out of the summary because of those
+	// limits. They are not sent in summary events at all, since the events service has no way to
+	// represent them, but only counted in periodic diagnostic events. Unlike eventsState, it is not
+	// reset after each flush, but only when it is reported. overflowStats has the total, which is
+	// never reset; see SummaryOverflowReporter.
+	overflowCount int
+	overflowStats *summaryOverflowStats
 }
 
 type eventSummary struct {
@@ -24,6 +93,9 @@ type flagSummary struct {
 	counters     map[counterKey]*counterValue
 	contextKinds map[ldcontext.Kind]struct{}
 	defaultValue ldvalue.Value
+	reasons      map[reasonKey]int
+	// contextSketches has an entry for each key in counters, if unique contexts are being counted.
+	contextSketches map[counterKey]*hyperLogLog
 }
 
 type counterKey struct {
@@ -31,13 +103,19 @@ type counterKey struct {
 	version   ldvalue.OptionalInt
 }
 
+// reasonKey identifies a reason counter. The errorKind is only set if kind is ldreason.EvalReasonError.
+type reasonKey struct {
+	kind      ldreason.EvalReasonKind
+	errorKind ldreason.EvalErrorKind
+}
+
 type counterValue struct {
 	count     int
 	flagValue ldvalue.Value
 }
 
 func newEventSummarizer() eventSummarizer {
-	return eventSummarizer{eventsState: newEventSummary()}
+	return eventSummarizer{eventsState: newEventSummary(), overflowStats: &summaryOverflowStats{}}
 }
 
 func newEventSummary() eventSummary {
@@ -52,24 +130,30 @@ func (s eventSummary) hasCounters() bool {
 
 // Adds this event to our counters.
 func (s *eventSummarizer) summarizeEvent(ed EvaluationData) {
-	flag, ok := s.eventsState.flags[ed.Key]
+	state := s.summaryFor(ed.CreationDate)
+	if state == nil {
+		s.addOverflow(1)
+		return
+	}
+	flag, ok := s.flagSummaryFor(state, ed.Key, ed.Default)
 	if !ok {
-		flag = flagSummary{
-			counters:     make(map[counterKey]*counterValue),
-			contextKinds: make(map[ldcontext.Kind]struct{}),
-			defaultValue: ed.Default,
-		}
-		s.eventsState.flags[ed.Key] = flag
+		s.addOverflow(1)
+		return
 	}
 
 	counterKey := counterKey{variation: ed.Variation, version: ed.Version}
-	if value, ok := flag.counters[counterKey]; ok {
-		value.count++
-	} else {
-		flag.counters[counterKey] = &counterValue{
-			count:     1,
-			flagValue: ed.Value,
+	if s.addToCounter(ed.Key, flag, counterKey, ed.Value, 1) && flag.contextSketches != nil {
+		flag.sketchFor(counterKey).add(ed.Context.context.FullyQualifiedKey())
+	}
+
+	// The reason is only available if the EventFactory was configured to include reasons, or if
+	// this was an experiment.
+	if flag.reasons != nil && ed.Reason.GetKind() != "" {
+		reasonKey := reasonKey{kind: ed.Reason.GetKind()}
+		if reasonKey.kind == ldreason.EvalReasonError {
+			reasonKey.errorKind = ed.Reason.GetErrorKind()
 		}
+		flag.reasons[reasonKey]++
 	}
 
 	for i := 0; i < ed.Context.context.IndividualContextCount(); i++ {
@@ -78,13 +162,172 @@ func (s *eventSummarizer) summarizeEvent(ed EvaluationData) {
 		}
 	}
 
-	creationDate := ed.CreationDate
-	if s.eventsState.startDate == 0 || creationDate < s.eventsState.startDate {
-		s.eventsState.startDate = creationDate
+	s.extendDates(state, ed.CreationDate, ed.CreationDate)
+}
+
+// Adds the counters from a summary that was produced elsewhere, such as by another SDK whose events
+// are being forwarded by the Relay Proxy, to our counters. The same limits apply as for individual
+// evaluations. If we are using time buckets, the whole summary goes in the bucket for its startDate.
+//
+// Reason counts and context sketches are only merged if we are collecting them ourselves. If the
+// other summary does not have a sketch for a counter, the contexts it counted are not reflected in
+// our contextCount.
+func (s *eventSummarizer) mergeSummary(in eventSummary) {
+	if !in.hasCounters() {
+		return
+	}
+	state := s.summaryFor(in.startDate)
+	for inFlagKey, inFlag := range in.flags {
+		var flag flagSummary
+		ok := false
+		if state != nil {
+			flag, ok = s.flagSummaryFor(state, inFlagKey, inFlag.defaultValue)
+		}
+		if !ok {
+			for _, inValue := range inFlag.counters {
+				s.addOverflow(inValue.count)
+			}
+			continue
+		}
+		for counterKey, inValue := range inFlag.counters {
+			if s.addToCounter(inFlagKey, flag, counterKey, inValue.flagValue, inValue.count) && flag.contextSketches != nil {
+				if inSketch := inFlag.contextSketches[counterKey]; inSketch != nil {
+					flag.sketchFor(counterKey).merge(inSketch)
+				}
+			}
+		}
+		if flag.reasons != nil {
+			for reasonKey, count := range inFlag.reasons {
+				flag.reasons[reasonKey] += count
+			}
+		}
+		for kind := range inFlag.contextKinds {
+			flag.contextKinds[kind] = struct{}{}
+		}
+	}
+	if state != nil {
+		s.extendDates(state, in.startDate, in.endDate)
+	}
+}
+
+// summaryFor returns the summary that an evaluation at the given time should be added to. It
+// returns nil if that would be a new time bucket but the flags capacity has already been reached.
+func (s *eventSummarizer) summaryFor(creationDate ldtime.UnixMillisecondTime) *eventSummary {
+	if s.bucketMillis == 0 {
+		return &s.eventsState
+	}
+	return s.bucketFor(creationDate)
+}
+
+// flagSummaryFor returns the flag summary that evaluations of the given flag should be added to,
+// creating it if necessary. It returns false if the flag is not already in the summary and the
+// summarizer has reached its flags capacity.
+func (s *eventSummarizer) flagSummaryFor(
+	state *eventSummary,
+	flagKey string,
+	defaultValue ldvalue.Value,
+) (flagSummary, bool) {
+	if flag, ok := state.flags[flagKey]; ok {
+		return flag, true
+	}
+	if s.flagsCapacity > 0 && s.flagEntries >= s.flagsCapacity {
+		return flagSummary{}, false
+	}
+	flag := flagSummary{
+		counters:     make(map[counterKey]*counterValue),
+		contextKinds: make(map[ldcontext.Kind]struct{}),
+		defaultValue: defaultValue,
+	}
+	if s.summarizeReasons {
+		flag.reasons = make(map[reasonKey]int)
+	}
+	if s.summarizeUniqueContexts {
+		flag.contextSketches = make(map[counterKey]*hyperLogLog)
+	}
+	state.flags[flagKey] = flag
+	s.flagEntries++
+	return flag, true
+}
+
+// addToCounter adds count evaluations to a counter, creating it if necessary. If the counter does
+// not exist and cannot be created because of the counters-per-flag capacity, they are recorded as
+// overflow instead, and it returns false.
+func (s *eventSummarizer) addToCounter(
+	flagKey string,
+	flag flagSummary,
+	counterKey counterKey,
+	flagValue ldvalue.Value,
+	count int,
+) bool {
+	if value, ok := flag.counters[counterKey]; ok {
+		value.count += count
+		return true
+	}
+	if s.countersPerFlagCapacity > 0 {
+		if s.counterEntries[flagKey] >= s.countersPerFlagCapacity {
+			s.addOverflow(count)
+			return false
+		}
+		if s.counterEntries == nil {
+			s.counterEntries = make(map[string]int)
+		}
+		s.counterEntries[flagKey]++
+	}
+	flag.counters[counterKey] = &counterValue{
+		count:     count,
+		flagValue: flagValue,
+	}
+	return true
+}
+
+// sketchFor returns the context sketch for a counter, creating it if necessary. It must only be
+// called if unique contexts are being counted.
+func (f flagSummary) sketchFor(counterKey counterKey) *hyperLogLog {
+	sketch := f.contextSketches[counterKey]
+	if sketch == nil {
+		sketch = newHyperLogLog()
+		f.contextSketches[counterKey] = sketch
 	}
-	if creationDate > s.eventsState.endDate {
-		s.eventsState.endDate = creationDate
+	return sketch
+}
+
+// extendDates widens the summary's date range, if necessary, to include the given range.
+func (s *eventSummary) extendDates(startDate, endDate ldtime.UnixMillisecondTime) {
+	if s.startDate == 0 || startDate < s.startDate {
+		s.startDate = startDate
 	}
+	if endDate > s.endDate {
+		s.endDate = endDate
+	}
+}
+
+// extendDates widens the date range of a summary that the summarizer has just added to. A time
+// bucket always starts at the start of its window, but ends at the time of its latest evaluation,
+// just as eventsState does.
+func (s *eventSummarizer) extendDates(state *eventSummary, startDate, endDate ldtime.UnixMillisecondTime) {
+	if s.bucketMillis == 0 {
+		state.extendDates(startDate, endDate)
+	} else if endDate > state.endDate {
+		state.endDate = endDate
+	}
+}
+
+func (s *eventSummarizer) bucketFor(creationDate ldtime.UnixMillisecondTime) *eventSummary {
+	windowStart := creationDate - creationDate%s.bucketMillis
+	bucket := s.buckets[windowStart]
+	if bucket == nil {
+		if s.flagsCapacity > 0 && s.flagEntries >= s.flagsCapacity {
+			return nil // the bucket could never have any flags
+		}
+		summary := newEventSummary()
+		summary.startDate, summary.endDate = windowStart, windowStart
+		bucket = &summary
+		if s.buckets == nil {
+			s.buckets = make(map[ldtime.UnixMillisecondTime]*eventSummary)
+		}
+		s.buckets[windowStart] = bucket
+	}
+	return bucket
 }
 
 // Returns a snapshot of the current summarized event data.
@@ -92,6 +335,32 @@ func (s *eventSummarizer) snapshot() eventSummary {
 	return s.eventsState
 }
 
+// Returns a snapshot of each summary that has counters, in order of their start dates. There is
+// only more than one if the summarizer is using time buckets.
+func (s *eventSummarizer) snapshots() []eventSummary {
+	if s.bucketMillis == 0 {
+		if !s.eventsState.hasCounters() {
+			return nil
+		}
+		return []eventSummary{s.eventsState}
+	}
+	ret := make([]eventSummary, 0, len(s.buckets))
+	for _, bucket := range s.buckets {
+		ret = append(ret, *bucket)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].startDate < ret[j].startDate })
+	return ret
+}
+
+// addOverflow records evaluations that were left out of the summary because of the capacity limits.
+func (s *eventSummarizer) addOverflow(count int) {
+	s.overflowCount += count
+	s.overflowStats.add(count)
+}
+
 func (s *eventSummarizer) reset() {
 	s.eventsState = newEventSummary()
+	s.buckets = nil
+	s.flagEntries = 0
+	s.counterEntries = nil
 }
@@ -2,12 +2,15 @@ package ldevents
 
 import (
 	"testing"
+	"time"
 
 	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldreason"
 	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
 	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func makeEvalEventWithContext(context ldcontext.Context, creationDate ldtime.UnixMillisecondTime, flagKey string,
@@ -148,3 +151,329 @@ func TestSummaryContextKindsAreTrackedPerFlag(t *testing.T) {
 	}
 	assert.Equal(t, expectedFlags, data.flags)
 }
+
+func TestSummarizeEventCountsReasons(t *testing.T) {
+	flagKey := "key"
+	version, variation := ldvalue.NewOptionalInt(1), ldvalue.NewOptionalInt(0)
+	eventWithReason := func(reason ldreason.EvaluationReason) EvaluationData {
+		e := makeEvalEvent(0, flagKey, version, variation, "value", "default")
+		e.Reason = reason
+		return e
+	}
+	events := []EvaluationData{
+		eventWithReason(ldreason.NewEvalReasonFallthrough()),
+		eventWithReason(ldreason.NewEvalReasonRuleMatch(0, "rule0")),
+		eventWithReason(ldreason.NewEvalReasonRuleMatch(1, "rule1")),
+		eventWithReason(ldreason.NewEvalReasonError(ldreason.EvalErrorMalformedFlag)),
+		eventWithReason(ldreason.NewEvalReasonError(ldreason.EvalErrorWrongType)),
+		eventWithReason(ldreason.NewEvalReasonError(ldreason.EvalErrorWrongType)),
+		eventWithReason(ldreason.EvaluationReason{}),
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		es := newEventSummarizer()
+		for _, e := range events {
+			es.summarizeEvent(e)
+		}
+		assert.Nil(t, es.snapshot().flags[flagKey].reasons)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		es := newEventSummarizer()
+		es.summarizeReasons = true
+		for _, e := range events {
+			es.summarizeEvent(e)
+		}
+		data := es.snapshot()
+		assert.Equal(t, map[reasonKey]int{
+			{kind: ldreason.EvalReasonFallthrough}:                                       1,
+			{kind: ldreason.EvalReasonRuleMatch}:                                         2,
+			{kind: ldreason.EvalReasonError, errorKind: ldreason.EvalErrorMalformedFlag}: 1,
+			{kind: ldreason.EvalReasonError, errorKind: ldreason.EvalErrorWrongType}:     2,
+		}, data.flags[flagKey].reasons)
+		assert.Equal(t, 7, data.flags[flagKey].counters[counterKey{variation, version}].count)
+
+		es.reset()
+		es.summarizeEvent(events[0])
+		assert.Equal(t, map[reasonKey]int{{kind: ldreason.EvalReasonFallthrough}: 1},
+			es.snapshot().flags[flagKey].reasons)
+	})
+}
+
+func TestSummarizeEventCountsUniqueContexts(t *testing.T) {
+	flagKey := "key"
+	version := ldvalue.NewOptionalInt(1)
+	variation1, variation2 := ldvalue.NewOptionalInt(1), ldvalue.NewOptionalInt(2)
+	events := []EvaluationData{
+		makeEvalEventWithContext(ldcontext.New("a"), 0, flagKey, version, variation1, "value1", "default"),
+		makeEvalEventWithContext(ldcontext.New("a"), 0, flagKey, version, variation1, "value1", "default"),
+		makeEvalEventWithContext(ldcontext.NewWithKind("org", "a"), 0, flagKey, version, variation1, "value1", "default"),
+		makeEvalEventWithContext(ldcontext.New("b"), 0, flagKey, version, variation1, "value1", "default"),
+		makeEvalEventWithContext(ldcontext.New("a"), 0, flagKey, version, variation2, "value2", "default"),
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		es := newEventSummarizer()
+		for _, e := range events {
+			es.summarizeEvent(e)
+		}
+		assert.Nil(t, es.snapshot().flags[flagKey].contextSketches)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		es := newEventSummarizer()
+		es.summarizeUniqueContexts = true
+		for _, e := range events {
+			es.summarizeEvent(e)
+		}
+		sketches := es.snapshot().flags[flagKey].contextSketches
+		assert.Len(t, sketches, 2)
+		assert.Equal(t, 3, sketches[counterKey{variation1, version}].estimate())
+		assert.Equal(t, 1, sketches[counterKey{variation2, version}].estimate())
+	})
+}
+
+func TestSummarizeEventFlagsCapacity(t *testing.T) {
+	es := newEventSummarizer()
+	es.flagsCapacity = 2
+	version, variation := ldvalue.NewOptionalInt(1), ldvalue.NewOptionalInt(0)
+	for _, flagKey := range []string{"key1", "key2", "key3", "key1", "key4", "key3"} {
+		es.summarizeEvent(makeEvalEvent(0, flagKey, version, variation, "value", "default"))
+	}
+	es.summarizeEvent(makeEvalEventWithContext(ldcontext.NewWithKind("org", "x"), 0, "key5", version, variation,
+		"value", "default"))
+	data := es.snapshot()
+
+	assert.Len(t, data.flags, 2)
+	assert.Equal(t, 2, data.flags["key1"].counters[counterKey{variation, version}].count)
+	assert.Equal(t, 1, data.flags["key2"].counters[counterKey{variation, version}].count)
+	assert.Equal(t, 4, es.overflowCount)
+
+	// The overflow count for diagnostic events is not reset with the summary
+	es.reset()
+	es.summarizeEvent(makeEvalEvent(0, "key3", version, variation, "value", "default"))
+	assert.Len(t, es.snapshot().flags, 1)
+	assert.Equal(t, 4, es.overflowCount)
+	assert.Equal(t, 4, es.overflowStats.get())
+
+	// The total for SummaryOverflowReporter is not reset when the diagnostic count is reported
+	es.overflowCount = 0
+	es.summarizeEvent(makeEvalEvent(0, "key4", version, variation, "value", "default"))
+	es.summarizeEvent(makeEvalEvent(0, "key6", version, variation, "value", "default"))
+	assert.Equal(t, 1, es.overflowCount)
+	assert.Equal(t, 5, es.overflowStats.get())
+}
+
+func TestSummarizeEventCountersPerFlagCapacity(t *testing.T) {
+	es := newEventSummarizer()
+	es.countersPerFlagCapacity = 2
+	es.summarizeUniqueContexts = true
+	flagKey := "key"
+	for _, version := range []int{1, 2, 3, 1, 4, 2} {
+		es.summarizeEvent(makeEvalEvent(0, flagKey, ldvalue.NewOptionalInt(version), ldvalue.NewOptionalInt(0),
+			"value", "default"))
+	}
+	flag := es.snapshot().flags[flagKey]
+
+	assert.Len(t, flag.counters, 2)
+	assert.Equal(t, 2, flag.counters[counterKey{ldvalue.NewOptionalInt(0), ldvalue.NewOptionalInt(1)}].count)
+	assert.Equal(t, 2, flag.counters[counterKey{ldvalue.NewOptionalInt(0), ldvalue.NewOptionalInt(2)}].count)
+	assert.Len(t, flag.contextSketches, 2)
+	assert.Equal(t, 2, es.overflowCount)
+}
+
+func TestSummarizeEventInTimeBuckets(t *testing.T) {
+	es := newEventSummarizer()
+	es.bucketMillis = 1000
+	version, variation := ldvalue.NewOptionalInt(1), ldvalue.NewOptionalInt(0)
+	for _, creationDate := range []ldtime.UnixMillisecondTime{5500, 3000, 5999, 3999, 5000, 5500} {
+		es.summarizeEvent(makeEvalEvent(creationDate, "key", version, variation, "value", "default"))
+	}
+	assert.Equal(t, eventSummary{flags: map[string]flagSummary{}}, es.snapshot())
+
+	summaries := es.snapshots()
+	if assert.Len(t, summaries, 2) {
+		assert.Equal(t, ldtime.UnixMillisecondTime(3000), summaries[0].startDate)
+		assert.Equal(t, ldtime.UnixMillisecondTime(3999), summaries[0].endDate)
+		assert.Equal(t, 2, summaries[0].flags["key"].counters[counterKey{variation, version}].count)
+		assert.Equal(t, ldtime.UnixMillisecondTime(5000), summaries[1].startDate)
+		assert.Equal(t, ldtime.UnixMillisecondTime(5999), summaries[1].endDate)
+		assert.Equal(t, 4, summaries[1].flags["key"].counters[counterKey{variation, version}].count)
+	}
+
+	es.reset()
+	assert.Len(t, es.snapshots(), 0)
+}
+
+func TestSummarizeEventInTimeBucketsCapacity(t *testing.T) {
+	es := newEventSummarizer()
+	es.bucketMillis = 1000
+	es.flagsCapacity = 2
+	es.countersPerFlagCapacity = 2
+	variation := ldvalue.NewOptionalInt(0)
+	for i, creationDate := range []ldtime.UnixMillisecondTime{1000, 2000, 3000, 4000} {
+		es.summarizeEvent(makeEvalEvent(creationDate, "key", ldvalue.NewOptionalInt(i), variation, "value", "default"))
+	}
+	es.summarizeEvent(makeEvalEvent(5000, "key", ldvalue.NewOptionalInt(0), variation, "value", "default"))
+
+	// The limits apply to all of the buckets together, and no bucket is created once the flags
+	// capacity has been reached.
+	summaries := es.snapshots()
+	if assert.Len(t, summaries, 2) {
+		assert.Len(t, summaries[0].flags["key"].counters, 1)
+		assert.Len(t, summaries[1].flags["key"].counters, 1)
+	}
+	assert.Equal(t, 3, es.overflowCount)
+
+	es.reset()
+	es.summarizeEvent(makeEvalEvent(5000, "key", ldvalue.NewOptionalInt(0), variation, "value", "default"))
+	assert.Len(t, es.snapshots(), 1)
+}
+
+func TestSummarizerSnapshotsWithoutTimeBuckets(t *testing.T) {
+	es := newEventSummarizer()
+	assert.Len(t, es.snapshots(), 0)
+
+	es.summarizeEvent(makeEvalEvent(1000, "key", ldvalue.NewOptionalInt(1), ldvalue.NewOptionalInt(0), "", ""))
+	assert.Equal(t, []eventSummary{es.snapshot()}, es.snapshots())
+}
+
+func TestMergeSummary(t *testing.T) {
+	version, variation1, variation2 := ldvalue.NewOptionalInt(1), ldvalue.NewOptionalInt(1), ldvalue.NewOptionalInt(2)
+	makeOtherSummary := func() eventSummary {
+		other := newEventSummarizer()
+		other.summarizeReasons = true
+		other.summarizeUniqueContexts = true
+		for _, e := range []EvaluationData{
+			makeEvalEventWithContext(ldcontext.New("b"), 500, "key1", version, variation1, "value1", "default1"),
+			makeEvalEventWithContext(ldcontext.NewWithKind("org", "c"), 3000, "key1", version, variation2, "value2",
+				"default1"),
+			makeEvalEvent(1000, "key2", version, variation1, "value3", "default2"),
+		} {
+			e.Reason = ldreason.NewEvalReasonFallthrough()
+			other.summarizeEvent(e)
+		}
+		return other.snapshot()
+	}
+	ownEvent := makeEvalEventWithContext(ldcontext.New("a"), 1000, "key1", version, variation1, "value1", "default1")
+	ownEvent.Reason = ldreason.NewEvalReasonFallthrough()
+
+	t.Run("counters, context kinds, and dates", func(t *testing.T) {
+		es := newEventSummarizer()
+		es.summarizeEvent(ownEvent)
+		es.mergeSummary(makeOtherSummary())
+		es.mergeSummary(eventSummary{})
+		data := es.snapshot()
+
+		assert.Equal(t, map[string]flagSummary{
+			"key1": {
+				defaultValue: ldvalue.String("default1"),
+				contextKinds: map[ldcontext.Kind]struct{}{ldcontext.DefaultKind: {}, "org": {}},
+				counters: map[counterKey]*counterValue{
+					{variation1, version}: {2, ldvalue.String("value1")},
+					{variation2, version}: {1, ldvalue.String("value2")},
+				},
+			},
+			"key2": {
+				defaultValue: ldvalue.String("default2"),
+				contextKinds: map[ldcontext.Kind]struct{}{ldcontext.DefaultKind: {}},
+				counters: map[counterKey]*counterValue{
+					{variation1, version}: {1, ldvalue.String("value3")},
+				},
+			},
+		}, data.flags)
+		assert.Equal(t, ldtime.UnixMillisecondTime(500), data.startDate)
+		assert.Equal(t, ldtime.UnixMillisecondTime(3000), data.endDate)
+	})
+
+	t.Run("reasons and context sketches", func(t *testing.T) {
+		es := newEventSummarizer()
+		es.summarizeReasons = true
+		es.summarizeUniqueContexts = true
+		es.summarizeEvent(ownEvent)
+		es.mergeSummary(makeOtherSummary())
+		flag := es.snapshot().flags["key1"]
+
+		assert.Equal(t, map[reasonKey]int{{kind: ldreason.EvalReasonFallthrough}: 3}, flag.reasons)
+		assert.Equal(t, 2, flag.contextSketches[counterKey{variation1, version}].estimate())
+		assert.Equal(t, 1, flag.contextSketches[counterKey{variation2, version}].estimate())
+	})
+
+	t.Run("capacity limits", func(t *testing.T) {
+		es := newEventSummarizer()
+		es.flagsCapacity = 1
+		es.countersPerFlagCapacity = 1
+		es.summarizeEvent(ownEvent)
+		es.mergeSummary(makeOtherSummary())
+		es.mergeSummary(es.snapshot())
+		data := es.snapshot()
+
+		assert.Len(t, data.flags, 1)
+		assert.Len(t, data.flags["key1"].counters, 1)
+		assert.Equal(t, 4, data.flags["key1"].counters[counterKey{variation1, version}].count)
+		assert.Equal(t, 2, es.overflowCount) // one for key1's second variation, one for key2
+	})
+
+	t.Run("time buckets", func(t *testing.T) {
+		es := newEventSummarizer()
+		es.bucketMillis = 1000
+		es.mergeSummary(makeOtherSummary())
+		summaries := es.snapshots()
+		if assert.Len(t, summaries, 1) {
+			assert.Equal(t, ldtime.UnixMillisecondTime(0), summaries[0].startDate)
+			assert.Equal(t, ldtime.UnixMillisecondTime(3000), summaries[0].endDate)
+			assert.Len(t, summaries[0].flags, 2)
+		}
+	})
+}
+
+func TestSummaryOverflowEvaluationsAreReported(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.SummaryFlagsCapacity = 1
+	context := Context(ldcontext.New("my-key"))
+	detail := ldreason.NewEvaluationDetail(ldvalue.String("v"), 0, ldreason.EvaluationReason{})
+	recordEvaluations := func(ep EventProcessor) {
+		for _, flagKey := range []string{"key1", "key2", "key3", "key1"} {
+			ep.RecordEvaluation(withoutReasons.NewEvaluationData(FlagEventProperties{Key: flagKey, Version: 1},
+				context, detail, false, ldvalue.Null(), "", ldvalue.OptionalInt{}, false))
+		}
+	}
+
+	t.Run("synchronous", func(t *testing.T) {
+		ep, _ := createSynchronousEventProcessorAndSender(config)
+		defer ep.Close()
+
+		var reporter SummaryOverflowReporter = ep
+		recordEvaluations(ep)
+		assert.Equal(t, 2, reporter.SummaryOverflowEvaluations())
+
+		ep.Flush()
+		recordEvaluations(ep)
+		assert.Equal(t, 4, reporter.SummaryOverflowEvaluations())
+	})
+
+	t.Run("default", func(t *testing.T) {
+		ep, _ := createEventProcessorAndSender(config)
+		defer ep.Close()
+
+		reporter, ok := interface{}(ep).(SummaryOverflowReporter)
+		require.True(t, ok)
+
+		recordEvaluations(ep)
+		ep.FlushBlocking(time.Second)
+		assert.Equal(t, 2, reporter.SummaryOverflowEvaluations())
+	})
+
+	t.Run("multi-environment", func(t *testing.T) {
+		mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+		defer mep.Close()
+		ep, _ := addEnvironmentWithSender(t, mep, "env1", config)
+
+		reporter, ok := interface{}(ep).(SummaryOverflowReporter)
+		require.True(t, ok)
+
+		recordEvaluations(ep)
+		require.True(t, ep.FlushBlocking(time.Second))
+		assert.Equal(t, 2, reporter.SummaryOverflowEvaluations())
+	})
+}
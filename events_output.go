@@ -1,6 +1,8 @@
 package ldevents
 
 import (
+	"time"
+
 	"github.com/launchdarkly/go-jsonstream/v3/jwriter"
 	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
 	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
@@ -19,25 +21,49 @@ const (
 	MigrationOpEventKind    = "migration_op"
 	IndexEventKind          = "index"
 	SummaryEventKind        = "summary"
+	MetricSummaryEventKind  = "metric_summary"
 )
 
 type eventOutputFormatter struct {
 	contextFormatter eventContextFormatter
 	config           EventsConfiguration
+	clockOffset      time.Duration
+}
+
+// withClockOffset returns a copy of the formatter that adds the given offset to every timestamp it
+// writes.
+func (ef eventOutputFormatter) withClockOffset(offset time.Duration) eventOutputFormatter {
+	ef.clockOffset = offset
+	return ef
 }
 
-func (ef eventOutputFormatter) makeOutputEvents(events []anyEventOutput, summary eventSummary) ([]byte, int) {
+func (ef eventOutputFormatter) makeOutputEvents(events []anyEventOutput, summaries ...eventSummary) ([]byte, int) {
 	n := len(events)
 
 	w := jwriter.NewWriter()
 	arr := w.Array()
 
 	for _, e := range events {
+		if ef.contextFormatter.redactPreserialized && hasPreserializedContext(e) {
+			// Parsing the preserialized context could fail, so write the event separately first, to
+			// avoid losing the rest of the payload.
+			scratch := jwriter.NewWriter()
+			ef.writeOutputEvent(&scratch, e)
+			if err := scratch.Error(); err != nil {
+				ef.config.Loggers.Warnf("Discarding an event whose preserialized context could not be parsed: %s", err)
+				n--
+				continue
+			}
+			w.Raw(scratch.Bytes())
+			continue
+		}
 		ef.writeOutputEvent(&w, e)
 	}
-	if summary.hasCounters() {
-		ef.writeSummaryEvent(&w, summary)
-		n++
+	for _, summary := range summaries {
+		if summary.hasCounters() {
+			ef.writeSummaryEvent(&w, summary)
+			n++
+		}
 	}
 
 	if n > 0 {
@@ -61,7 +87,7 @@ func (ef eventOutputFormatter) writeOutputEvent(w *jwriter.Writer, evt anyEventO
 		if evt.debug {
 			kind = FeatureDebugEventKind
 		}
-		beginEventFields(&obj, kind, evt.BaseEvent.CreationDate)
+		ef.beginEventFields(&obj, kind, evt.BaseEvent.CreationDate)
 		obj.Name("key").String(evt.Key)
 		obj.Maybe("version", evt.Version.IsDefined()).Int(evt.Version.IntValue())
 		if evt.debug {
@@ -70,8 +96,8 @@ func (ef eventOutputFormatter) writeOutputEvent(w *jwriter.Writer, evt anyEventO
 			ef.contextFormatter.WriteContextRedactAnonymous(obj.Name("context"), &evt.Context)
 		}
 		obj.Maybe("variation", evt.Variation.IsDefined()).Int(evt.Variation.IntValue())
-		evt.Value.WriteToJSONWriter(obj.Name("value"))
-		evt.Default.WriteToJSONWriter(obj.Name("default"))
+		writeValue(obj.Name("value"), evt.Value, ef.config.CanonicalOutput)
+		writeValue(obj.Name("default"), evt.Default, ef.config.CanonicalOutput)
 		obj.Maybe("prereqOf", evt.PrereqOf.IsDefined()).String(evt.PrereqOf.StringValue())
 		if evt.Reason.GetKind() != "" {
 			evt.Reason.WriteToJSONWriter(obj.Name("reason"))
@@ -80,10 +106,10 @@ func (ef eventOutputFormatter) writeOutputEvent(w *jwriter.Writer, evt anyEventO
 		writeSamplingRatio(&obj, evt.SamplingRatio)
 
 	case CustomEventData:
-		beginEventFields(&obj, CustomEventKind, evt.BaseEvent.CreationDate)
+		ef.beginEventFields(&obj, CustomEventKind, evt.BaseEvent.CreationDate)
 		obj.Name("key").String(evt.Key)
 		if !evt.Data.IsNull() {
-			evt.Data.WriteToJSONWriter(obj.Name("data"))
+			writeValue(obj.Name("data"), evt.Data, ef.config.CanonicalOutput)
 		}
 		writeContextKeys(&obj, &evt.Context.context)
 		obj.Maybe("metricValue", evt.HasMetric).Float64(evt.MetricValue)
@@ -91,12 +117,12 @@ func (ef eventOutputFormatter) writeOutputEvent(w *jwriter.Writer, evt anyEventO
 		writeSamplingRatio(&obj, evt.SamplingRatio)
 
 	case IdentifyEventData:
-		beginEventFields(&obj, IdentifyEventKind, evt.BaseEvent.CreationDate)
+		ef.beginEventFields(&obj, IdentifyEventKind, evt.BaseEvent.CreationDate)
 		ef.contextFormatter.WriteContext(obj.Name("context"), &evt.Context)
 		writeSamplingRatio(&obj, evt.SamplingRatio)
 
 	case MigrationOpEventData:
-		beginEventFields(&obj, MigrationOpEventKind, evt.BaseEvent.CreationDate)
+		ef.beginEventFields(&obj, MigrationOpEventKind, evt.BaseEvent.CreationDate)
 
 		obj.Name("operation").String(string(evt.Op))
 
@@ -105,7 +131,7 @@ func (ef eventOutputFormatter) writeOutputEvent(w *jwriter.Writer, evt anyEventO
 
 		evalObj := obj.Name("evaluation").Object()
 		evalObj.Name("key").String(evt.FlagKey)
-		evt.Evaluation.Value.WriteToJSONWriter(evalObj.Name("value"))
+		writeValue(evalObj.Name("value"), evt.Evaluation.Value, ef.config.CanonicalOutput)
 		evt.Evaluation.Reason.WriteToJSONWriter(evalObj.Name("reason"))
 		obj.Name("default").String(string(evt.Default))
 		evalObj.Maybe("variation", evt.Evaluation.VariationIndex.IsDefined()).Int(evt.Evaluation.VariationIndex.IntValue())
@@ -113,17 +139,32 @@ func (ef eventOutputFormatter) writeOutputEvent(w *jwriter.Writer, evt anyEventO
 		evalObj.End()
 
 		measurementsArr := obj.Name("measurements").Array()
-		writeMigrationOpMeasurements(&measurementsArr, evt)
+		writeMigrationOpMeasurements(&measurementsArr, evt, ef.config.CanonicalOutput)
 		measurementsArr.End()
 
+	case metricSummary:
+		ef.writeMetricSummaryFields(&obj, evt)
+
 	case indexEvent:
-		beginEventFields(&obj, IndexEventKind, evt.BaseEvent.CreationDate)
+		ef.beginEventFields(&obj, IndexEventKind, evt.BaseEvent.CreationDate)
 		ef.contextFormatter.WriteContext(obj.Name("context"), &evt.Context)
 	}
 
 	obj.End()
 }
 
+func hasPreserializedContext(evt anyEventOutput) bool {
+	switch evt := evt.(type) {
+	case EvaluationData:
+		return evt.Context.preserialized != nil
+	case IdentifyEventData:
+		return evt.Context.preserialized != nil
+	case indexEvent:
+		return evt.Context.preserialized != nil
+	}
+	return false
+}
+
 func writeSamplingRatio(obj *jwriter.ObjectState, value ldvalue.OptionalInt) {
 	v, ok := value.Get()
 
@@ -134,18 +175,22 @@ func writeSamplingRatio(obj *jwriter.ObjectState, value ldvalue.OptionalInt) {
 	obj.Name("samplingRatio").Int(v)
 }
 
-func beginEventFields(obj *jwriter.ObjectState, kind string, creationDate ldtime.UnixMillisecondTime) {
+func (ef eventOutputFormatter) beginEventFields(
+	obj *jwriter.ObjectState,
+	kind string,
+	creationDate ldtime.UnixMillisecondTime,
+) {
 	obj.Name("kind").String(kind)
-	obj.Name("creationDate").Float64(float64(creationDate))
+	obj.Name("creationDate").Float64(float64(adjustTimestamp(creationDate, ef.clockOffset)))
 }
 
-func writeMigrationOpMeasurements(measurementsArr *jwriter.ArrayState, evt MigrationOpEventData) {
+func writeMigrationOpMeasurements(measurementsArr *jwriter.ArrayState, evt MigrationOpEventData, sorted bool) {
 	if len(evt.Invoked) > 0 {
 		obj := measurementsArr.Object()
 		obj.Name("key").String("invoked")
 
 		valuesObj := obj.Name("values").Object()
-		for origin := range evt.Invoked {
+		for _, origin := range originKeys(evt.Invoked, sorted) {
 			valuesObj.Name(string(origin)).Bool(true)
 		}
 		valuesObj.End()
@@ -165,8 +210,8 @@ func writeMigrationOpMeasurements(measurementsArr *jwriter.ArrayState, evt Migra
 		obj.Name("key").String("latency_ms")
 
 		valuesObj := obj.Name("values").Object()
-		for origin, value := range evt.Latency {
-			valuesObj.Name(string(origin)).Int(value)
+		for _, origin := range latencyOriginKeys(evt.Latency, sorted) {
+			valuesObj.Name(string(origin)).Int(evt.Latency[origin])
 		}
 		valuesObj.End()
 
@@ -178,7 +223,7 @@ func writeMigrationOpMeasurements(measurementsArr *jwriter.ArrayState, evt Migra
 		obj.Name("key").String("error")
 
 		valuesObj := obj.Name("values").Object()
-		for origin := range evt.Error {
+		for _, origin := range originKeys(evt.Error, sorted) {
 			valuesObj.Name(string(origin)).Bool(true)
 		}
 		valuesObj.End()
@@ -202,17 +247,20 @@ func (ef eventOutputFormatter) writeSummaryEvent(w *jwriter.Writer, snapshot eve
 	obj := w.Object()
 
 	obj.Name("kind").String(SummaryEventKind)
-	obj.Name("startDate").Float64(float64(snapshot.startDate))
-	obj.Name("endDate").Float64(float64(snapshot.endDate))
+	obj.Name("startDate").Float64(float64(adjustTimestamp(snapshot.startDate, ef.clockOffset)))
+	obj.Name("endDate").Float64(float64(adjustTimestamp(snapshot.endDate, ef.clockOffset)))
 
+	sorted := ef.config.CanonicalOutput
 	allFlagsObj := obj.Name("features").Object()
-	for flagKey, flagSummary := range snapshot.flags {
+	for _, flagKey := range flagSummaryKeys(snapshot.flags, sorted) {
+		flagSummary := snapshot.flags[flagKey]
 		flagObj := allFlagsObj.Name(flagKey).Object()
 
-		flagSummary.defaultValue.WriteToJSONWriter(flagObj.Name("default"))
+		writeValue(flagObj.Name("default"), flagSummary.defaultValue, sorted)
 
 		countersArr := flagObj.Name("counters").Array()
-		for counterKey, counterValue := range flagSummary.counters {
+		for _, counterKey := range counterKeys(flagSummary.counters, sorted) {
+			counterValue := flagSummary.counters[counterKey]
 			counterObj := countersArr.Object()
 			counterObj.Maybe("variation", counterKey.variation.IsDefined()).Int(counterKey.variation.IntValue())
 			if counterKey.version.IsDefined() {
@@ -220,21 +268,69 @@ func (ef eventOutputFormatter) writeSummaryEvent(w *jwriter.Writer, snapshot eve
 			} else {
 				counterObj.Name("unknown").Bool(true)
 			}
-			counterValue.flagValue.WriteToJSONWriter(counterObj.Name("value"))
+			writeValue(counterObj.Name("value"), counterValue.flagValue, sorted)
 			counterObj.Name("count").Int(counterValue.count)
+			if sketch := flagSummary.contextSketches[counterKey]; sketch != nil {
+				counterObj.Name("contextCount").Int(sketch.estimate())
+				counterObj.Name("contextSketch").String(sketch.encode())
+			}
 			counterObj.End()
 		}
 		countersArr.End()
 
 		contextKindsArr := flagObj.Name("contextKinds").Array()
-		for kind := range flagSummary.contextKinds {
+		for _, kind := range contextKindKeys(flagSummary.contextKinds, sorted) {
 			contextKindsArr.String(string(kind))
 		}
 		contextKindsArr.End()
 
+		if len(flagSummary.reasons) != 0 {
+			reasonsArr := flagObj.Name("reasons").Array()
+			for _, reasonKey := range reasonKeys(flagSummary.reasons, sorted) {
+				reasonObj := reasonsArr.Object()
+				reasonObj.Name("kind").String(string(reasonKey.kind))
+				reasonObj.Maybe("errorKind", reasonKey.errorKind != "").String(string(reasonKey.errorKind))
+				reasonObj.Name("count").Int(flagSummary.reasons[reasonKey])
+				reasonObj.End()
+			}
+			reasonsArr.End()
+		}
+
 		flagObj.End()
 	}
 	allFlagsObj.End()
 
 	obj.End()
 }
+
+// Transforms the aggregated custom metric data into the format used for event sending.
+func (ef eventOutputFormatter) writeMetricSummaryFields(obj *jwriter.ObjectState, summary metricSummary) {
+	obj.Name("kind").String(MetricSummaryEventKind)
+	obj.Name("startDate").Float64(float64(adjustTimestamp(summary.startDate, ef.clockOffset)))
+	obj.Name("endDate").Float64(float64(adjustTimestamp(summary.endDate, ef.clockOffset)))
+
+	boundsArr := obj.Name("histogramBounds").Array()
+	for _, bound := range summary.bounds {
+		boundsArr.Float64(bound)
+	}
+	boundsArr.End()
+
+	metricsArr := obj.Name("metrics").Array()
+	for _, key := range metricKeys(summary.metrics, ef.config.CanonicalOutput) {
+		agg := summary.metrics[key]
+		metricObj := metricsArr.Object()
+		metricObj.Name("key").String(key.key)
+		metricObj.Name("contextKind").String(string(key.contextKind))
+		metricObj.Name("count").Int(agg.count)
+		metricObj.Name("sum").Float64(agg.sum)
+		metricObj.Name("min").Float64(agg.min)
+		metricObj.Name("max").Float64(agg.max)
+		histogramArr := metricObj.Name("histogram").Array()
+		for _, count := range agg.bucketCounts {
+			histogramArr.Int(count)
+		}
+		histogramArr.End()
+		metricObj.End()
+	}
+	metricsArr.End()
+}
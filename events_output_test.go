@@ -5,7 +5,9 @@ import (
 	"testing"
 
 	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldmigration"
 	"github.com/launchdarkly/go-sdk-common/v3/ldreason"
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
 	"github.com/launchdarkly/go-sdk-common/v3/lduser"
 	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
 
@@ -521,6 +523,115 @@ func TestEventOutputSummaryEvents(t *testing.T) {
 		)))
 	})
 
+	t.Run("summary with reason counts", func(t *testing.T) {
+		es := newEventSummarizer()
+		es.summarizeReasons = true
+		es.summarizeEvent(withReasons.NewEvaluationData(flag1v1, user,
+			ldreason.NewEvaluationDetail(ldvalue.String("a"), 1, ldreason.NewEvalReasonFallthrough()),
+			false, flag1Default, "", ldvalue.OptionalInt{}, false))
+		es.summarizeEvent(withReasons.NewEvaluationData(flag1v1, user,
+			ldreason.NewEvaluationDetail(ldvalue.String("a"), 1, ldreason.NewEvalReasonFallthrough()),
+			false, flag1Default, "", ldvalue.OptionalInt{}, false))
+		es.summarizeEvent(withReasons.NewUnknownFlagEvaluationData(flag2.Key, user, flag2Default,
+			ldreason.NewEvalReasonError(ldreason.EvalErrorFlagNotFound)))
+		es.summarizeEvent(withoutReasons.NewUnknownFlagEvaluationData("flag3", user, flag2Default,
+			ldreason.NewEvalReasonError(ldreason.EvalErrorFlagNotFound)))
+
+		bytes, count := formatter.makeOutputEvents(nil, es.snapshot())
+		require.Equal(t, 1, count)
+
+		m.In(t).Assert(bytes, m.JSONArray().Should(m.Items(
+			m.JSONProperty("features").Should(m.MapOf(
+				m.KV("flag1", m.JSONProperty("reasons").Should(m.JSONStrEqual(
+					`[{"kind":"FALLTHROUGH","count":2}]`))),
+				m.KV("flag2", m.JSONProperty("reasons").Should(m.JSONStrEqual(
+					`[{"kind":"ERROR","errorKind":"FLAG_NOT_FOUND","count":1}]`))),
+				m.KV("flag3", m.JSONOptProperty("reasons").Should(m.BeNil())),
+			)),
+		)))
+	})
+
+	t.Run("summary with unique context counts", func(t *testing.T) {
+		es := newEventSummarizer()
+		es.summarizeUniqueContexts = true
+		for _, key := range []string{"a", "b", "a"} {
+			es.summarizeEvent(withoutReasons.NewEvaluationData(flag1v1, Context(ldcontext.New(key)),
+				ldreason.NewEvaluationDetail(ldvalue.String("a"), 1, noReason),
+				false, flag1Default, "", ldvalue.OptionalInt{}, false))
+		}
+		expectedSketch := newHyperLogLog()
+		expectedSketch.add("a")
+		expectedSketch.add("b")
+
+		bytes, count := formatter.makeOutputEvents(nil, es.snapshot())
+		require.Equal(t, 1, count)
+
+		m.In(t).Assert(bytes, m.JSONArray().Should(m.Items(
+			m.JSONProperty("features").Should(m.JSONProperty("flag1").Should(
+				m.JSONProperty("counters").Should(m.Items(m.MapOf(
+					m.KV("version", m.Equal(100)),
+					m.KV("variation", m.Equal(1)),
+					m.KV("value", m.Equal("a")),
+					m.KV("count", m.Equal(3)),
+					m.KV("contextCount", m.Equal(2)),
+					m.KV("contextSketch", m.Equal(expectedSketch.encode())),
+				))),
+			)),
+		)))
+	})
+
+	t.Run("summary with overflow", func(t *testing.T) {
+		es := newEventSummarizer()
+		es.flagsCapacity = 1
+		es.countersPerFlagCapacity = 1
+		for _, flag := range []FlagEventProperties{flag1v1, flag1v2, flag1v2, flag2} {
+			es.summarizeEvent(withoutReasons.NewEvaluationData(flag, user,
+				ldreason.NewEvaluationDetail(ldvalue.String("a"), 1, noReason),
+				false, flag1Default, "", ldvalue.OptionalInt{}, false))
+		}
+
+		bytes, count := formatter.makeOutputEvents(nil, es.snapshot())
+		require.Equal(t, 1, count)
+
+		// Evaluations beyond the capacity are only reported in diagnostic events.
+		m.In(t).Assert(bytes, m.JSONArray().Should(m.Items(
+			m.JSONProperty("features").Should(m.MapOf(
+				m.KV("flag1", m.JSONProperty("counters").Should(m.Items(
+					m.JSONStrEqual(`{"version":100,"variation":1,"value":"a","count":1}`),
+				))),
+			)),
+		)))
+	})
+
+	t.Run("multiple summaries", func(t *testing.T) {
+		es := newEventSummarizer()
+		es.bucketMillis = 60000
+		for _, creationDate := range []ldtime.UnixMillisecondTime{fakeTime, fakeTime + 60000} {
+			event := withoutReasons.NewEvaluationData(flag1v1, user,
+				ldreason.NewEvaluationDetail(ldvalue.String("a"), 1, noReason),
+				false, flag1Default, "", ldvalue.OptionalInt{}, false)
+			event.CreationDate = creationDate
+			es.summarizeEvent(event)
+		}
+		windowStart := fakeTime - fakeTime%60000
+
+		bytes, count := formatter.makeOutputEvents(nil, es.snapshots()...)
+		require.Equal(t, 2, count)
+
+		m.In(t).Assert(bytes, m.JSONArray().Should(m.Items(
+			m.AllOf(
+				anySummaryEvent(),
+				m.JSONProperty("startDate").Should(equalNumericTime(windowStart)),
+				m.JSONProperty("endDate").Should(equalNumericTime(fakeTime)),
+			),
+			m.AllOf(
+				anySummaryEvent(),
+				m.JSONProperty("startDate").Should(equalNumericTime(windowStart+60000)),
+				m.JSONProperty("endDate").Should(equalNumericTime(fakeTime+60000)),
+			),
+		)))
+	})
+
 	t.Run("empty payload", func(t *testing.T) {
 		bytes, count := formatter.makeOutputEvents([]anyEventOutput{}, eventSummary{})
 		assert.Nil(t, bytes)
@@ -528,6 +639,34 @@ func TestEventOutputSummaryEvents(t *testing.T) {
 	})
 }
 
+func TestEventOutputMetricSummaryEvent(t *testing.T) {
+	formatter := eventOutputFormatter{
+		contextFormatter: newEventContextFormatter(basicConfigWithoutPrivateAttrs()),
+		config:           basicConfigWithoutPrivateAttrs(),
+	}
+	a := newMetricAggregator([]string{"latency"}, []float64{10, 100})
+	a.aggregate(makeMetricEvent("latency", ldcontext.New("u"), fakeTime, 5), 1)
+	a.aggregate(makeMetricEvent("latency", ldcontext.New("u"), fakeTime+1000, 500.5), 1)
+
+	verifyEventOutput(t, formatter, a.snapshot(), m.JSONEqual(map[string]interface{}{
+		"kind":            "metric_summary",
+		"startDate":       fakeTime,
+		"endDate":         fakeTime + 1000,
+		"histogramBounds": []float64{10, 100},
+		"metrics": []interface{}{
+			map[string]interface{}{
+				"key":         "latency",
+				"contextKind": "user",
+				"count":       2,
+				"sum":         505.5,
+				"min":         5,
+				"max":         500.5,
+				"histogram":   []int{1, 0, 1},
+			},
+		},
+	}))
+}
+
 func verifyEventOutput(t *testing.T, formatter eventOutputFormatter, event anyEventInput, jsonMatcher m.Matcher) {
 	t.Helper()
 	bytes, count := formatter.makeOutputEvents([]anyEventOutput{event}, eventSummary{})
@@ -541,3 +680,94 @@ func verifySummaryEventOutput(t *testing.T, formatter eventOutputFormatter, summ
 	require.Equal(t, 1, count)
 	m.In(t).Assert(bytes, m.JSONArray().Should(m.Items(jsonMatcher)))
 }
+
+func TestEventOutputCanonical(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.CanonicalOutput = true
+	formatter := eventOutputFormatter{contextFormatter: newEventContextFormatter(config), config: config}
+	context := Context(ldcontext.NewMulti(ldcontext.New("user-key"), ldcontext.NewWithKind("org", "org-key")))
+	objectValue := ldvalue.ObjectBuild().SetInt("z", 1).SetInt("a", 2).
+		Set("m", ldvalue.ArrayOf(ldvalue.ObjectBuild().SetInt("y", 3).SetInt("b", 4).Build())).Build()
+
+	// Since map iteration order is random, formatting each event many times makes it very unlikely
+	// that the output is only sorted by chance.
+	verifyCanonicalOutput := func(t *testing.T, expectedJSON string, events []anyEventOutput, summaries ...eventSummary) {
+		t.Helper()
+		for i := 0; i < 20; i++ {
+			bytes, _ := formatter.makeOutputEvents(events, summaries...)
+			require.Equal(t, "["+expectedJSON+"]", string(bytes))
+		}
+	}
+
+	t.Run("summary", func(t *testing.T) {
+		es := newEventSummarizer()
+		es.summarizeReasons = true
+		for _, flagKey := range []string{"c", "a", "b"} {
+			for _, variation := range []ldvalue.OptionalInt{ldvalue.NewOptionalInt(2), {}, ldvalue.NewOptionalInt(0)} {
+				for _, version := range []int{3, 1} {
+					event := withReasons.NewEvaluationData(FlagEventProperties{Key: flagKey, Version: version},
+						context, ldreason.EvaluationDetail{Value: objectValue, VariationIndex: variation,
+							Reason: ldreason.NewEvalReasonFallthrough()}, false, objectValue, "", ldvalue.OptionalInt{}, false)
+					es.summarizeEvent(event)
+				}
+			}
+			es.summarizeEvent(withReasons.NewEvaluationData(FlagEventProperties{Key: flagKey, Version: 1},
+				context, ldreason.NewEvaluationDetailForError(ldreason.EvalErrorWrongType, objectValue), false,
+				objectValue, "", ldvalue.OptionalInt{}, false))
+		}
+
+		value := `{"a":2,"m":[{"b":4,"y":3}],"z":1}`
+		flagJSON := `{"default":` + value + `,"counters":[` +
+			`{"version":1,"value":` + value + `,"count":2},` +
+			`{"version":3,"value":` + value + `,"count":1},` +
+			`{"variation":0,"version":1,"value":` + value + `,"count":1},` +
+			`{"variation":0,"version":3,"value":` + value + `,"count":1},` +
+			`{"variation":2,"version":1,"value":` + value + `,"count":1},` +
+			`{"variation":2,"version":3,"value":` + value + `,"count":1}],` +
+			`"contextKinds":["org","user"],` +
+			`"reasons":[{"kind":"ERROR","errorKind":"WRONG_TYPE","count":1},{"kind":"FALLTHROUGH","count":6}]}`
+		verifyCanonicalOutput(t, `{"kind":"summary","startDate":`+jsonNumber(fakeTime)+`,"endDate":`+jsonNumber(fakeTime)+
+			`,"features":{"a":`+flagJSON+`,"b":`+flagJSON+`,"c":`+flagJSON+`}}`, nil, es.snapshot())
+	})
+
+	t.Run("migration op", func(t *testing.T) {
+		op := newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.Old, 10).invoked(ldmigration.New, 20).
+			failed(ldmigration.Old).failed(ldmigration.New).evt
+		op.Context = Context(ldcontext.New("user-key"))
+		op.Evaluation = ldreason.NewEvaluationDetail(objectValue, 1, ldreason.NewEvalReasonFallthrough())
+		op.Default = ldmigration.Off
+		verifyCanonicalOutput(t, `{"kind":"migration_op","creationDate":`+jsonNumber(fakeTime)+`,"operation":"read",`+
+			`"contextKeys":{"user":"user-key"},`+
+			`"evaluation":{"key":"flag","value":{"a":2,"m":[{"b":4,"y":3}],"z":1},"reason":{"kind":"FALLTHROUGH"},`+
+			`"default":"off","variation":1},"measurements":[`+
+			`{"key":"invoked","values":{"new":true,"old":true}},`+
+			`{"key":"latency_ms","values":{"new":20,"old":10}},`+
+			`{"key":"error","values":{"new":true,"old":true}}]}`, []anyEventOutput{op})
+	})
+
+	t.Run("custom event data", func(t *testing.T) {
+		event := withoutReasons.NewCustomEventData("key", context, objectValue, false, 0, ldvalue.OptionalInt{})
+		verifyCanonicalOutput(t, `{"kind":"custom","creationDate":`+jsonNumber(fakeTime)+`,"key":"key",`+
+			`"data":{"a":2,"m":[{"b":4,"y":3}],"z":1},"contextKeys":{"org":"org-key","user":"user-key"}}`,
+			[]anyEventOutput{event})
+	})
+
+	t.Run("metric summary", func(t *testing.T) {
+		a := newMetricAggregator([]string{"b", "a"}, []float64{10})
+		for _, key := range []string{"b", "a"} {
+			a.aggregate(makeMetricEvent(key, ldcontext.NewMulti(ldcontext.New("u"), ldcontext.NewWithKind("org", "o")),
+				fakeTime, 5), 1)
+		}
+		metric := func(key, kind string) string {
+			return `{"key":"` + key + `","contextKind":"` + kind + `","count":1,"sum":5,"min":5,"max":5,"histogram":[1,0]}`
+		}
+		verifyCanonicalOutput(t, `{"kind":"metric_summary","startDate":`+jsonNumber(fakeTime)+`,"endDate":`+
+			jsonNumber(fakeTime)+`,"histogramBounds":[10],"metrics":[`+
+			metric("a", "org")+","+metric("a", "user")+","+metric("b", "org")+","+metric("b", "user")+`]}`,
+			[]anyEventOutput{a.snapshot()})
+	})
+}
+
+func jsonNumber(t ldtime.UnixMillisecondTime) string {
+	return ldvalue.Float64(float64(t)).JSONString()
+}
@@ -0,0 +1,97 @@
+package ldevents
+
+import (
+	"encoding/base64"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// hyperLogLogPrecision is the number of hash bits used to select a register. With 2^10 registers,
+// each sketch takes 1 KiB regardless of how many keys are added, and the standard error of the
+// estimate is about 3%.
+const hyperLogLogPrecision = 10
+
+const hyperLogLogRegisters = 1 << hyperLogLogPrecision
+
+// hyperLogLog is a HyperLogLog sketch for estimating the number of distinct strings that have been
+// added to it. Two sketches can be merged, giving the same result as if every string had been added
+// to one sketch. It is not thread-safe.
+type hyperLogLog struct {
+	registers [hyperLogLogRegisters]uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{}
+}
+
+func (h *hyperLogLog) add(key string) {
+	hash := hashKeyForSketch(key)
+	index := hash >> (64 - hyperLogLogPrecision)
+	// The rank is the position of the first 1 bit in the rest of the hash. The sentinel bit keeps it
+	// within the number of bits that are left.
+	rest := hash<<hyperLogLogPrecision | 1<<(hyperLogLogPrecision-1)
+	rank := uint8(bits.LeadingZeros64(rest) + 1)
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+func (h *hyperLogLog) estimate() int {
+	const m = float64(hyperLogLogRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros != 0 {
+		// For small cardinalities, linear counting of the empty registers is more accurate.
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(e))
+}
+
+// encode returns the registers in base64, for the contextSketch property of a summary counter.
+func (h *hyperLogLog) encode() string {
+	return base64.StdEncoding.EncodeToString(h.registers[:])
+}
+
+func decodeHyperLogLog(s string) (*hyperLogLog, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != hyperLogLogRegisters {
+		return nil, errors.New("context sketch has the wrong number of registers")
+	}
+	h := newHyperLogLog()
+	copy(h.registers[:], data)
+	return h, nil
+}
+
+// hashKeyForSketch computes a 64-bit FNV-1a hash, followed by the MurmurHash3 finalizer; FNV alone
+// does not spread similar keys evenly enough across the high bits that select a register.
+func hashKeyForSketch(key string) uint64 {
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(key))
+	x := f.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
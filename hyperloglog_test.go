@@ -0,0 +1,71 @@
+package ldevents
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertEstimateNear(t *testing.T, expected int, h *hyperLogLog) {
+	t.Helper()
+	// Allow for about three standard errors
+	assert.InDelta(t, expected, h.estimate(), float64(expected)*0.1+1)
+}
+
+func TestHyperLogLogEmptySketch(t *testing.T) {
+	assert.Equal(t, 0, newHyperLogLog().estimate())
+}
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	for _, n := range []int{1, 10, 100, 1000, 10000, 100000} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			h := newHyperLogLog()
+			for i := 0; i < n; i++ {
+				h.add(fmt.Sprintf("user:key%d", i))
+			}
+			assertEstimateNear(t, n, h)
+		})
+	}
+}
+
+func TestHyperLogLogIgnoresDuplicates(t *testing.T) {
+	h := newHyperLogLog()
+	for i := 0; i < 1000; i++ {
+		h.add(fmt.Sprintf("key%d", i%10))
+	}
+	assert.Equal(t, 10, h.estimate())
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	h1, h2, all := newHyperLogLog(), newHyperLogLog(), newHyperLogLog()
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if i < 2000 {
+			h1.add(key)
+		}
+		if i >= 1000 {
+			h2.add(key)
+		}
+		all.add(key)
+	}
+	h1.merge(h2)
+	assert.Equal(t, all.registers, h1.registers)
+	assertEstimateNear(t, 3000, h1)
+}
+
+func TestHyperLogLogEncoding(t *testing.T) {
+	h := newHyperLogLog()
+	for i := 0; i < 500; i++ {
+		h.add(fmt.Sprintf("key%d", i))
+	}
+	decoded, err := decodeHyperLogLog(h.encode())
+	require.NoError(t, err)
+	assert.Equal(t, h.registers, decoded.registers)
+
+	_, err = decodeHyperLogLog("not base64!")
+	assert.Error(t, err)
+	_, err = decodeHyperLogLog("AAAA")
+	assert.Error(t, err)
+}
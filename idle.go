@@ -0,0 +1,147 @@
+package ldevents
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// IdleWaiter is an optional interface for EventProcessor implementations that can report when they
+// have no work in progress. It is implemented by the processors returned by NewDefaultEventProcessor
+// and MultiEnvironmentEventProcessor, and by SynchronousEventProcessor.
+//
+// A processor is idle when every event that was recorded before the check has been processed, no
+// more events are waiting to be processed, and no flush is in progress. Being idle does not mean
+// that buffered events have been delivered; to deliver them, call Flush before waiting. A processor
+// that has been closed is always idle.
+//
+// For an environment of a MultiEnvironmentEventProcessor, events waiting to be processed for any
+// environment count against being idle, since all environments share the same inbox.
+type IdleWaiter interface {
+	// WaitForIdle blocks until the processor is idle, or until the context is done, in which case
+	// it returns the context's error. It does not cause a flush.
+	//
+	// If events are being recorded continuously, the processor may never be idle, so the context
+	// should normally have a deadline.
+	WaitForIdle(ctx context.Context) error
+
+	// Idle returns a channel that will be closed the next time the processor is idle.
+	Idle() <-chan struct{}
+}
+
+// waitForIdle implements WaitForIdle. The syncFn must block until the dispatcher has processed
+// everything that was in the inbox when syncFn was called, and has no flushes in progress. The
+// busyFn must return true if there is work that the sync may not have covered: events that arrived
+// in the meantime, or a flush that the flush timer started after the sync. In that case, we need to
+// do it again.
+func waitForIdle(
+	ctx context.Context,
+	closedCh <-chan struct{},
+	syncFn func(context.Context) error,
+	busyFn func() bool,
+) error {
+	for {
+		if err := syncFn(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-closedCh:
+			return nil // anything left in the inbox will never be processed
+		default:
+		}
+		if !busyFn() {
+			return nil
+		}
+	}
+}
+
+// idleNotifier implements Idle. Callers that are waiting at the same time share one channel and one
+// goroutine, so calling Idle repeatedly while the processor is busy does not start more goroutines.
+// The goroutine exits when the processor is idle or closed.
+type idleNotifier struct {
+	idleCh chan struct{}
+	joined bool
+	lock   sync.Mutex
+}
+
+// idle implements Idle, given an implementation of WaitForIdle that returns when the processor is
+// closed.
+func (n *idleNotifier) idle(waitFn func(context.Context) error) <-chan struct{} {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.idleCh != nil {
+		// The wait that is in progress may have started before this caller recorded its events, so
+		// make sure there is another one after it.
+		n.joined = true
+		return n.idleCh
+	}
+	idleCh := make(chan struct{})
+	n.idleCh = idleCh
+	go func() {
+		for {
+			_ = waitFn(context.Background())
+			n.lock.Lock()
+			if !n.joined {
+				n.idleCh = nil
+				n.lock.Unlock()
+				close(idleCh)
+				return
+			}
+			n.joined = false
+			n.lock.Unlock()
+		}
+	}()
+	return idleCh
+}
+
+// flushTracker counts the flushes in progress for an eventDispatcher. The main goroutine can wait
+// for them to complete, as with a sync.WaitGroup, and other goroutines can check whether there are
+// any.
+type flushTracker struct {
+	group sync.WaitGroup
+	count int64
+}
+
+func (f *flushTracker) Add(delta int) {
+	atomic.AddInt64(&f.count, int64(delta))
+	f.group.Add(delta)
+}
+
+func (f *flushTracker) Done() {
+	atomic.AddInt64(&f.count, -1)
+	f.group.Done()
+}
+
+func (f *flushTracker) Wait() {
+	f.group.Wait()
+}
+
+func (f *flushTracker) inProgress() bool {
+	return atomic.LoadInt64(&f.count) > 0
+}
+
+// postAndAwaitSync posts a control message to an inbox and waits for it to be answered. If the
+// processor is closed first, it returns nil, since a closed processor is idle.
+func postAndAwaitSync(
+	ctx context.Context,
+	inboxCh chan<- eventDispatcherMessage,
+	closedCh <-chan struct{},
+	m eventDispatcherMessage,
+	replyCh <-chan struct{},
+) error {
+	select {
+	case inboxCh <- m:
+	case <-closedCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-replyCh:
+		return nil
+	case <-closedCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
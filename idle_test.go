@@ -0,0 +1,152 @@
+package ldevents
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	m "github.com/launchdarkly/go-test-helpers/v3/matchers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func contextWithTimeout(t *testing.T, timeout time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func assertIdleSignal(t *testing.T, ep EventProcessor) {
+	t.Helper()
+	select {
+	case <-ep.(IdleWaiter).Idle():
+	case <-time.After(time.Second):
+		assert.Fail(t, "timed out waiting for idle signal")
+	}
+}
+
+// testIdleWaiter runs tests that apply equally to every asynchronous implementation of IdleWaiter.
+func testIdleWaiter(t *testing.T, makeProcessor func(*testing.T) (EventProcessor, *mockEventSender)) {
+	t.Run("does not flush", func(t *testing.T) {
+		ep, es := makeProcessor(t)
+		ep.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}))
+		require.NoError(t, ep.(IdleWaiter).WaitForIdle(contextWithTimeout(t, time.Second)))
+		assert.Len(t, es.takeEvents(), 0)
+	})
+
+	t.Run("waits for flush to complete", func(t *testing.T) {
+		ep, es := makeProcessor(t)
+		ep.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}))
+		ep.Flush()
+		require.NoError(t, ep.(IdleWaiter).WaitForIdle(contextWithTimeout(t, time.Second)))
+		m.In(t).Assert(es.takeEvents(), m.Items(anyIdentifyEvent()))
+	})
+
+	t.Run("waits for flush results to be processed", func(t *testing.T) {
+		ep, es := makeProcessor(t)
+		es.result = EventSenderResult{Success: true, TimeFromServer: fakeTime}
+		ep.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}))
+		ep.Flush()
+		require.NoError(t, ep.(IdleWaiter).WaitForIdle(contextWithTimeout(t, time.Second)))
+		_, ok := ep.(ClockSkewReporter).ClockSkew()
+		assert.True(t, ok)
+	})
+
+	t.Run("returns context error if flush does not complete", func(t *testing.T) {
+		ep, es := makeProcessor(t)
+		senderGateCh, senderWaitingCh := make(chan struct{}, 1), make(chan struct{}, 1)
+		es.setGate(senderGateCh, senderWaitingCh)
+
+		ep.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}))
+		ep.Flush()
+		<-senderWaitingCh
+		err := ep.(IdleWaiter).WaitForIdle(contextWithTimeout(t, 50*time.Millisecond))
+		assert.Equal(t, context.DeadlineExceeded, err)
+
+		idleCh := ep.(IdleWaiter).Idle()
+		select {
+		case <-idleCh:
+			require.Fail(t, "should not have been idle yet")
+		case <-time.After(20 * time.Millisecond):
+		}
+		senderGateCh <- struct{}{}
+		assertIdleSignal(t, ep)
+		require.NoError(t, ep.(IdleWaiter).WaitForIdle(contextWithTimeout(t, time.Second)))
+	})
+
+	t.Run("repeated Idle calls share one goroutine", func(t *testing.T) {
+		ep, es := makeProcessor(t)
+		senderGateCh, senderWaitingCh := make(chan struct{}, 1), make(chan struct{}, 1)
+		es.setGate(senderGateCh, senderWaitingCh)
+
+		ep.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}))
+		ep.Flush()
+		<-senderWaitingCh
+		idleCh := ep.(IdleWaiter).Idle()
+		before := runtime.NumGoroutine()
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, idleCh, ep.(IdleWaiter).Idle())
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+
+		senderGateCh <- struct{}{}
+		select {
+		case <-idleCh:
+		case <-time.After(time.Second):
+			assert.Fail(t, "timed out waiting for idle signal")
+		}
+	})
+
+	t.Run("closed processor is idle", func(t *testing.T) {
+		ep, _ := makeProcessor(t)
+		require.NoError(t, ep.Close())
+		assert.NoError(t, ep.(IdleWaiter).WaitForIdle(contextWithTimeout(t, time.Second)))
+		assertIdleSignal(t, ep)
+	})
+}
+
+func TestDefaultEventProcessorIdleWaiter(t *testing.T) {
+	testIdleWaiter(t, func(t *testing.T) (EventProcessor, *mockEventSender) {
+		ep, es := createEventProcessorAndSender(basicConfigWithoutPrivateAttrs())
+		t.Cleanup(func() { _ = ep.Close() })
+		return ep, es
+	})
+}
+
+func TestMultiEnvironmentEventProcessorIdleWaiter(t *testing.T) {
+	testIdleWaiter(t, func(t *testing.T) (EventProcessor, *mockEventSender) {
+		mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+		t.Cleanup(func() { _ = mep.Close() })
+		_, _ = addEnvironmentWithSender(t, mep, "other-env", basicConfigWithoutPrivateAttrs())
+		return addEnvironmentWithSender(t, mep, "env", basicConfigWithoutPrivateAttrs())
+	})
+}
+
+func TestWaitForIdleRechecksFlushStartedAfterSync(t *testing.T) {
+	var flushes flushTracker
+	syncCount := 0
+	syncFn := func(context.Context) error {
+		syncCount++
+		if syncCount == 1 {
+			flushes.Add(1) // as if the flush timer fired right after the sync was answered
+		} else {
+			flushes.Done() // the next sync waits for that flush
+		}
+		return nil
+	}
+	require.NoError(t, waitForIdle(contextWithTimeout(t, time.Second), nil, syncFn, flushes.inProgress))
+	assert.Equal(t, 2, syncCount)
+}
+
+func TestSynchronousEventProcessorIsAlwaysIdle(t *testing.T) {
+	ep, _ := createSynchronousEventProcessorAndSender(basicConfigWithoutPrivateAttrs())
+	defer ep.Close()
+
+	var waiter IdleWaiter = ep
+	assert.NoError(t, waiter.WaitForIdle(context.Background()))
+	assertIdleSignal(t, ep)
+}
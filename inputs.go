@@ -32,8 +32,10 @@ func Context(context ldcontext.Context) EventInputContext {
 }
 
 // PreserializedContext creates an EventInputContext that contains both a Context and its already-computed
-// JSON representation. This representation will be written directly to the output with no further
-// processing. The properties of the wrapped Context are not important except for its Kind, Key, and
+// JSON representation. By default, this representation is written directly to the output with no further
+// processing. If EventsConfiguration.RedactPreserializedContexts is true, it is instead parsed, redacted
+// according to the configuration, and written again; an event whose context cannot be parsed is
+// discarded. The properties of the wrapped Context are not important except for its Kind, Key, and
 // FullyQualifiedKey, which are used for context deduplication.
 func PreserializedContext(context ldcontext.Context, jsonData json.RawMessage) EventInputContext {
 	return EventInputContext{context: context, preserialized: jsonData}
@@ -167,7 +169,8 @@ type EventFactory struct {
 //
 // The includeReasons parameter is true if evaluation events should always include the EvaluationReason (this is
 // used by the SDK when one of the "VariationDetail" methods is called). The timeFn parameter is normally nil but
-// can be used to instrument the EventFactory with a source of time data other than the standard clock.
+// can be used to instrument the EventFactory with a source of time data other than the standard clock; see also
+// NewEventFactoryWithClock.
 //
 // The isExperimentFn parameter is necessary to provide the additional experimentation behavior that is
 func NewEventFactory(includeReasons bool, timeFn func() ldtime.UnixMillisecondTime) EventFactory {
@@ -177,6 +180,14 @@ func NewEventFactory(includeReasons bool, timeFn func() ldtime.UnixMillisecondTi
 	return EventFactory{includeReasons, timeFn}
 }
 
+// NewEventFactoryWithClock creates an EventFactory that gets the creation date of each event from
+// the given Clock. This is the same as NewEventFactory, except that it uses the same kind of time
+// source as EventsConfiguration.Clock. If clock is nil, SystemClock is used.
+func NewEventFactoryWithClock(includeReasons bool, clock Clock) EventFactory {
+	clock = clockOrDefault(clock)
+	return NewEventFactory(includeReasons, func() ldtime.UnixMillisecondTime { return unixMillisNow(clock) })
+}
+
 // NewUnknownFlagEvaluationData creates EvaluationData for a missing flag.
 func (f EventFactory) NewUnknownFlagEvaluationData(
 	key string,
@@ -0,0 +1,140 @@
+package ldeventstest
+
+import (
+	"sync"
+	"time"
+
+	ldevents "github.com/launchdarkly/go-sdk-events/v3"
+)
+
+// FakeClock is an implementation of ldevents.Clock whose time only changes when the test says so.
+// It is safe for concurrent use.
+//
+// Set EventsConfiguration.Clock to a FakeClock to control when the event processor flushes, resets
+// its set of known context keys, and sends periodic diagnostic events. Since the event processor
+// creates its tickers asynchronously when it starts, use AwaitTickers before calling Advance.
+type FakeClock struct {
+	now            time.Time
+	tickers        []*fakeTicker
+	tickersChanged chan struct{}
+	lock           sync.Mutex
+}
+
+type fakeTicker struct {
+	clock    *FakeClock
+	ch       chan time.Time
+	interval time.Duration
+	next     time.Time
+	stopped  bool
+}
+
+// NewFakeClock creates a FakeClock whose current time is now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, tickersChanged: make(chan struct{})}
+}
+
+// Now returns the current time.
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// NewTicker returns a Ticker that delivers a tick whenever Advance moves the time past the end of
+// its next interval.
+func (c *FakeClock) NewTicker(interval time.Duration) ldevents.Ticker {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := &fakeTicker{clock: c, ch: make(chan time.Time), interval: interval, next: c.now.Add(interval)}
+	c.tickers = append(c.tickers, t)
+	c.notifyTickersChanged()
+	return t
+}
+
+// Advance moves the current time forward by d. For each ticker whose interval has elapsed at least
+// once, it then delivers a single tick, like time.Ticker does for a slow receiver.
+//
+// Unlike time.Ticker, Advance waits for each tick to be received, for up to DefaultAwaitTimeout.
+// That way, once Advance returns, the event processor has already seen the tick, and anything the
+// test does afterward will be processed after whatever the tick triggered.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var due []*fakeTicker
+	for _, t := range c.tickers {
+		if t.stopped || t.next.After(now) {
+			continue
+		}
+		for !t.next.After(now) {
+			t.next = t.next.Add(t.interval)
+		}
+		due = append(due, t)
+	}
+	c.lock.Unlock()
+
+	for _, t := range due {
+		deadline := time.NewTimer(DefaultAwaitTimeout)
+		select {
+		case t.ch <- now:
+		case <-deadline.C:
+		}
+		deadline.Stop()
+	}
+}
+
+// ActiveTickers returns the number of tickers that have been created and not stopped.
+func (c *FakeClock) ActiveTickers() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.activeTickers()
+}
+
+// AwaitTickers waits until at least count tickers are active, and returns false if that does not
+// happen within the timeout. A DefaultEventProcessor creates two tickers, or three if it has a
+// DiagnosticsManager.
+func (c *FakeClock) AwaitTickers(count int, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		c.lock.Lock()
+		active, changedCh := c.activeTickers(), c.tickersChanged
+		c.lock.Unlock()
+		if active >= count {
+			return true
+		}
+		select {
+		case <-changedCh:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// activeTickers must be called with the lock held.
+func (c *FakeClock) activeTickers() int {
+	count := 0
+	for _, t := range c.tickers {
+		if !t.stopped {
+			count++
+		}
+	}
+	return count
+}
+
+// notifyTickersChanged must be called with the lock held.
+func (c *FakeClock) notifyTickersChanged() {
+	close(c.tickersChanged)
+	c.tickersChanged = make(chan struct{})
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTicker) Stop() {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	if !t.stopped {
+		t.stopped = true
+		t.clock.notifyTickersChanged()
+	}
+}
@@ -0,0 +1,83 @@
+package ldeventstest
+
+import (
+	"testing"
+	"time"
+
+	ldevents "github.com/launchdarkly/go-sdk-events/v3"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	m "github.com/launchdarkly/go-test-helpers/v3/matchers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClockAdvance(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	assert.Equal(t, start, c.Now())
+
+	ticker := c.NewTicker(time.Minute)
+	ticksCh := make(chan time.Time, 10)
+	go func() {
+		for tick := range ticker.C() {
+			ticksCh <- tick
+		}
+	}()
+
+	c.Advance(30 * time.Second)
+	assert.Equal(t, start.Add(30*time.Second), c.Now())
+	assert.Len(t, ticksCh, 0)
+
+	c.Advance(30 * time.Second)
+	assert.Equal(t, start.Add(time.Minute), <-ticksCh)
+
+	c.Advance(5 * time.Minute) // several intervals elapsed, but only one tick is delivered
+	assert.Equal(t, start.Add(6*time.Minute), <-ticksCh)
+	assert.Len(t, ticksCh, 0)
+
+	ticker.Stop()
+	c.Advance(time.Hour)
+	assert.Len(t, ticksCh, 0)
+	assert.Equal(t, 0, c.ActiveTickers())
+}
+
+func TestFakeClockAwaitTickers(t *testing.T) {
+	c := NewFakeClock(time.Now())
+	assert.False(t, c.AwaitTickers(1, 10*time.Millisecond))
+
+	go c.NewTicker(time.Second)
+	assert.True(t, c.AwaitTickers(1, time.Second))
+	assert.Equal(t, 1, c.ActiveTickers())
+}
+
+func TestFakeClockWithEventProcessor(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	s := NewRecordingEventSender()
+	ep := ldevents.NewDefaultEventProcessor(ldevents.EventsConfiguration{
+		Capacity:              100,
+		Clock:                 c,
+		EventSender:           s,
+		FlushInterval:         time.Minute,
+		UserKeysCapacity:      100,
+		UserKeysFlushInterval: time.Hour,
+	})
+	defer ep.Close()
+	require.True(t, c.AwaitTickers(2, time.Second))
+
+	factory := ldevents.NewEventFactoryWithClock(false, c)
+	context := ldevents.Context(ldcontext.New("user-key"))
+	ep.RecordIdentifyEvent(factory.NewIdentifyEventData(context, ldvalue.OptionalInt{}))
+
+	c.Advance(time.Minute)
+	m.In(t).Assert(s.AwaitEvent(t), m.AllOf(
+		IdentifyEventForContextKey("user-key"),
+		m.JSONProperty("creationDate").Should(EqualNumericTime(ldtime.UnixMillisFromTime(start))),
+	))
+	s.AssertNoMoreEvents(t)
+}
@@ -0,0 +1,256 @@
+package ldeventstest
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	ldevents "github.com/launchdarkly/go-sdk-events/v3"
+)
+
+// SenderFault describes a way in which FaultInjectingEventSender should misbehave for one payload.
+// The zero value means no fault: the payload is passed to the inner sender.
+type SenderFault struct {
+	// Latency is how long to wait before doing anything else.
+	Latency time.Duration
+	// Fail causes an unsuccessful result to be returned without calling the inner sender, as if
+	// delivery had failed with a recoverable error.
+	Fail bool
+	// MustShutDown causes a result with MustShutDown set to be returned without calling the inner
+	// sender, as if the SDK key had been rejected.
+	MustShutDown bool
+	// Hang causes the call to block until ReleaseHangs is called, and then to fail as if Fail were set.
+	Hang bool
+}
+
+// SenderFaultProbabilities describes faults that FaultInjectingEventSender should inject at random.
+//
+// For each payload, at most one of MustShutDown, Fail, or Hang is chosen, so the sum of their
+// probabilities should not exceed 1. Latency is chosen independently of those.
+type SenderFaultProbabilities struct {
+	// Latency is the delay to add when latency is chosen.
+	Latency time.Duration
+	// LatencyProbability is the probability, from 0 to 1, of adding Latency.
+	LatencyProbability float64
+	// FailureProbability is the probability of a SenderFault with Fail set.
+	FailureProbability float64
+	// MustShutDownProbability is the probability of a SenderFault with MustShutDown set.
+	MustShutDownProbability float64
+	// HangProbability is the probability of a SenderFault with Hang set.
+	HangProbability float64
+	// Seed initializes the random number generator, so that a given configuration always produces
+	// the same sequence of faults.
+	Seed int64
+}
+
+// FaultInjectionStats contains counts of what FaultInjectingEventSender has done so far.
+type FaultInjectionStats struct {
+	// Sends is the total number of calls to SendEventData.
+	Sends int
+	// Delegated is the number of calls that were passed to the inner sender.
+	Delegated int
+	// Delayed is the number of calls to which latency was added.
+	Delayed int
+	// Failed is the number of calls that failed because of Fail or Hang.
+	Failed int
+	// MustShutDown is the number of calls that returned MustShutDown because of an injected fault.
+	MustShutDown int
+	// Hung is the number of calls that blocked because of Hang, including any that are still blocked.
+	Hung int
+}
+
+// FaultInjectingEventSender is an implementation of ldevents.EventSender that wraps another
+// EventSender and injects faults, for testing how an application behaves when event delivery
+// misbehaves. It is safe for concurrent use.
+//
+// For each payload, the fault to apply is chosen as follows: the next fault queued with QueueFaults,
+// if any; otherwise the fault set with SetFault, if it applies to the kind of payload; otherwise a
+// fault chosen at random according to SetFaultProbabilities. Setting a fault for only one kind of
+// payload with SetFault simulates a partial outage.
+//
+// A hanging call blocks a flush worker until ReleaseHangs is called, so a test that injects hangs
+// should make sure to call ReleaseHangs before closing the event processor.
+type FaultInjectingEventSender struct {
+	inner         ldevents.EventSender
+	faultQueue    []SenderFault
+	fault         SenderFault
+	faultKinds    []ldevents.EventDataKind
+	probabilities SenderFaultProbabilities
+	rand          *rand.Rand
+	stats         FaultInjectionStats
+	hangCh        chan struct{}
+	hungCh        chan struct{}
+	lock          sync.Mutex
+}
+
+// NewFaultInjectingEventSender creates a FaultInjectingEventSender that delegates to inner. Initially,
+// it does not inject any faults.
+func NewFaultInjectingEventSender(inner ldevents.EventSender) *FaultInjectingEventSender {
+	return &FaultInjectingEventSender{
+		inner:  inner,
+		rand:   rand.New(rand.NewSource(0)), //nolint:gosec // this is not for security purposes
+		hangCh: make(chan struct{}),
+		hungCh: make(chan struct{}),
+	}
+}
+
+// QueueFaults adds faults to a queue. Each payload takes the next fault from the queue, if any. Use
+// SenderFault{} to script a payload that should be delivered normally.
+func (s *FaultInjectingEventSender) QueueFaults(faults ...SenderFault) {
+	s.lock.Lock()
+	s.faultQueue = append(s.faultQueue, faults...)
+	s.lock.Unlock()
+}
+
+// SetFault applies a fault to every payload for which no fault has been queued, until ClearFaults
+// is called. If any kinds are specified, the fault only applies to payloads of those kinds.
+func (s *FaultInjectingEventSender) SetFault(fault SenderFault, kinds ...ldevents.EventDataKind) {
+	s.lock.Lock()
+	s.fault = fault
+	s.faultKinds = kinds
+	s.lock.Unlock()
+}
+
+// SetFaultProbabilities causes faults to be chosen at random for any payload that is not affected
+// by QueueFaults or SetFault, until ClearFaults is called.
+func (s *FaultInjectingEventSender) SetFaultProbabilities(probabilities SenderFaultProbabilities) {
+	s.lock.Lock()
+	s.probabilities = probabilities
+	s.rand = rand.New(rand.NewSource(probabilities.Seed)) //nolint:gosec // this is not for security purposes
+	s.lock.Unlock()
+}
+
+// ClearFaults removes all queued faults, any fault set with SetFault, and any probabilities set with
+// SetFaultProbabilities. It does not release calls that are already hanging.
+func (s *FaultInjectingEventSender) ClearFaults() {
+	s.lock.Lock()
+	s.faultQueue = nil
+	s.fault = SenderFault{}
+	s.faultKinds = nil
+	s.probabilities = SenderFaultProbabilities{}
+	s.lock.Unlock()
+}
+
+// ReleaseHangs unblocks every call that is currently hanging because of a Hang fault.
+func (s *FaultInjectingEventSender) ReleaseHangs() {
+	s.lock.Lock()
+	close(s.hangCh)
+	s.hangCh = make(chan struct{})
+	s.lock.Unlock()
+}
+
+// AwaitHang waits until at least count calls have hung since the sender was created, and returns
+// false if that does not happen within the timeout.
+func (s *FaultInjectingEventSender) AwaitHang(count int, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.lock.Lock()
+		hung, hungCh := s.stats.Hung, s.hungCh
+		s.lock.Unlock()
+		if hung >= count {
+			return true
+		}
+		select {
+		case <-hungCh:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// Stats returns counts of what the sender has done so far.
+func (s *FaultInjectingEventSender) Stats() FaultInjectionStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stats
+}
+
+// SendEventData applies a fault, if any, and otherwise delegates to the inner sender. It is called
+// by the event processor.
+func (s *FaultInjectingEventSender) SendEventData(
+	kind ldevents.EventDataKind,
+	data []byte,
+	eventCount int,
+) ldevents.EventSenderResult {
+	s.lock.Lock()
+	fault := s.nextFault(kind)
+	s.stats.Sends++
+	if fault.Latency > 0 {
+		s.stats.Delayed++
+	}
+	hangCh := s.hangCh
+	if fault.Hang {
+		s.stats.Hung++
+		close(s.hungCh)
+		s.hungCh = make(chan struct{})
+	}
+	s.lock.Unlock()
+
+	if fault.Latency > 0 {
+		time.Sleep(fault.Latency)
+	}
+	if fault.Hang {
+		<-hangCh
+	}
+
+	var result ldevents.EventSenderResult
+	s.lock.Lock()
+	switch {
+	case fault.MustShutDown:
+		s.stats.MustShutDown++
+		result.MustShutDown = true
+	case fault.Fail || fault.Hang:
+		s.stats.Failed++
+	default:
+		s.stats.Delegated++
+	}
+	s.lock.Unlock()
+
+	if fault.MustShutDown || fault.Fail || fault.Hang {
+		return result
+	}
+	return s.inner.SendEventData(kind, data, eventCount)
+}
+
+// nextFault must be called with the lock held.
+func (s *FaultInjectingEventSender) nextFault(kind ldevents.EventDataKind) SenderFault {
+	if len(s.faultQueue) > 0 {
+		fault := s.faultQueue[0]
+		s.faultQueue = s.faultQueue[1:]
+		return fault
+	}
+	if s.fault != (SenderFault{}) && faultAppliesToKind(s.faultKinds, kind) {
+		return s.fault
+	}
+	var fault SenderFault
+	p := s.probabilities
+	if p == (SenderFaultProbabilities{Seed: p.Seed}) {
+		return fault
+	}
+	if p.LatencyProbability > 0 && s.rand.Float64() < p.LatencyProbability {
+		fault.Latency = p.Latency
+	}
+	r := s.rand.Float64()
+	switch {
+	case r < p.MustShutDownProbability:
+		fault.MustShutDown = true
+	case r < p.MustShutDownProbability+p.FailureProbability:
+		fault.Fail = true
+	case r < p.MustShutDownProbability+p.FailureProbability+p.HangProbability:
+		fault.Hang = true
+	}
+	return fault
+}
+
+func faultAppliesToKind(kinds []ldevents.EventDataKind, kind ldevents.EventDataKind) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package ldeventstest
+
+import (
+	"testing"
+	"time"
+
+	ldevents "github.com/launchdarkly/go-sdk-events/v3"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sendArbitraryPayload(s ldevents.EventSender, kind ldevents.EventDataKind) ldevents.EventSenderResult {
+	data := arbitraryPayload
+	if kind == ldevents.DiagnosticEventDataKind {
+		data = []byte(`{"kind":"diagnostic"}`)
+	}
+	return s.SendEventData(kind, data, 2)
+}
+
+func TestFaultInjectingEventSenderDelegatesByDefault(t *testing.T) {
+	inner := NewRecordingEventSender()
+	s := NewFaultInjectingEventSender(inner)
+
+	result := sendArbitraryPayload(s, ldevents.AnalyticsEventDataKind)
+	assert.Equal(t, ldevents.EventSenderResult{Success: true}, result)
+	assert.Len(t, inner.AwaitEvents(t, 2), 2)
+	assert.Equal(t, FaultInjectionStats{Sends: 1, Delegated: 1}, s.Stats())
+}
+
+func TestFaultInjectingEventSenderQueuedFaults(t *testing.T) {
+	inner := NewRecordingEventSender()
+	s := NewFaultInjectingEventSender(inner)
+	s.QueueFaults(SenderFault{Fail: true}, SenderFault{}, SenderFault{MustShutDown: true})
+
+	assert.Equal(t, ldevents.EventSenderResult{}, sendArbitraryPayload(s, ldevents.AnalyticsEventDataKind))
+	assert.Equal(t, ldevents.EventSenderResult{Success: true}, sendArbitraryPayload(s, ldevents.AnalyticsEventDataKind))
+	assert.Equal(t, ldevents.EventSenderResult{MustShutDown: true},
+		sendArbitraryPayload(s, ldevents.AnalyticsEventDataKind))
+	assert.Equal(t, ldevents.EventSenderResult{Success: true}, sendArbitraryPayload(s, ldevents.AnalyticsEventDataKind))
+
+	assert.Equal(t, 2, inner.PayloadCount())
+	assert.Equal(t, FaultInjectionStats{Sends: 4, Delegated: 2, Failed: 1, MustShutDown: 1}, s.Stats())
+}
+
+func TestFaultInjectingEventSenderPartialOutage(t *testing.T) {
+	inner := NewRecordingEventSender()
+	s := NewFaultInjectingEventSender(inner)
+	s.SetFault(SenderFault{Fail: true}, ldevents.DiagnosticEventDataKind)
+
+	assert.Equal(t, ldevents.EventSenderResult{}, sendArbitraryPayload(s, ldevents.DiagnosticEventDataKind))
+	assert.True(t, sendArbitraryPayload(s, ldevents.AnalyticsEventDataKind).Success)
+
+	s.ClearFaults()
+	assert.True(t, sendArbitraryPayload(s, ldevents.DiagnosticEventDataKind).Success)
+	assert.Len(t, inner.DiagnosticEvents(), 1)
+}
+
+func TestFaultInjectingEventSenderLatency(t *testing.T) {
+	s := NewFaultInjectingEventSender(NewRecordingEventSender())
+	s.QueueFaults(SenderFault{Latency: 50 * time.Millisecond})
+
+	start := time.Now()
+	result := sendArbitraryPayload(s, ldevents.AnalyticsEventDataKind)
+	assert.True(t, result.Success)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, FaultInjectionStats{Sends: 1, Delegated: 1, Delayed: 1}, s.Stats())
+}
+
+func TestFaultInjectingEventSenderHang(t *testing.T) {
+	s := NewFaultInjectingEventSender(NewRecordingEventSender())
+	s.QueueFaults(SenderFault{Hang: true})
+
+	resultCh := make(chan ldevents.EventSenderResult, 1)
+	go func() { resultCh <- sendArbitraryPayload(s, ldevents.AnalyticsEventDataKind) }()
+
+	require.True(t, s.AwaitHang(1, time.Second))
+	select {
+	case <-resultCh:
+		require.Fail(t, "SendEventData should not have returned")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	s.ReleaseHangs()
+	assert.Equal(t, ldevents.EventSenderResult{}, <-resultCh)
+	assert.Equal(t, FaultInjectionStats{Sends: 1, Failed: 1, Hung: 1}, s.Stats())
+	assert.False(t, s.AwaitHang(2, 10*time.Millisecond))
+}
+
+func TestFaultInjectingEventSenderProbabilities(t *testing.T) {
+	probabilities := SenderFaultProbabilities{
+		FailureProbability:      0.3,
+		MustShutDownProbability: 0.2,
+		Seed:                    1,
+	}
+	run := func() ([]ldevents.EventSenderResult, FaultInjectionStats) {
+		s := NewFaultInjectingEventSender(NewRecordingEventSender())
+		s.SetFaultProbabilities(probabilities)
+		var results []ldevents.EventSenderResult
+		for i := 0; i < 1000; i++ {
+			results = append(results, sendArbitraryPayload(s, ldevents.AnalyticsEventDataKind))
+		}
+		return results, s.Stats()
+	}
+
+	results1, stats := run()
+	results2, _ := run()
+	assert.Equal(t, results1, results2, "the same seed should produce the same faults")
+
+	assert.Equal(t, 1000, stats.Sends)
+	assert.InDelta(t, 300, stats.Failed, 60)
+	assert.InDelta(t, 200, stats.MustShutDown, 60)
+	assert.Equal(t, stats.Sends-stats.Failed-stats.MustShutDown, stats.Delegated)
+}
+
+func TestFaultInjectingEventSenderWithEventProcessor(t *testing.T) {
+	makeProcessor := func(sender ldevents.EventSender) ldevents.EventProcessor {
+		return ldevents.NewDefaultEventProcessor(ldevents.EventsConfiguration{
+			Capacity:         100,
+			EventSender:      sender,
+			FlushInterval:    time.Hour,
+			UserKeysCapacity: 100,
+		})
+	}
+	identify := func(ep ldevents.EventProcessor, key string) {
+		context := ldevents.Context(ldcontext.New(key))
+		ep.RecordIdentifyEvent(ldevents.NewEventFactory(false, nil).NewIdentifyEventData(context, ldvalue.OptionalInt{}))
+	}
+
+	t.Run("hang causes FlushBlocking to time out", func(t *testing.T) {
+		inner := NewRecordingEventSender()
+		s := NewFaultInjectingEventSender(inner)
+		s.QueueFaults(SenderFault{Hang: true})
+		ep := makeProcessor(s)
+		defer ep.Close()
+		defer s.ReleaseHangs()
+
+		identify(ep, "a")
+		assert.False(t, ep.FlushBlocking(50*time.Millisecond))
+		require.True(t, s.AwaitHang(1, time.Second))
+
+		s.ReleaseHangs()
+		identify(ep, "b")
+		assert.True(t, ep.FlushBlocking(time.Second))
+		inner.AssertEventsReceived(t, IdentifyEventForContextKey("b"))
+	})
+
+	t.Run("MustShutDown disables the processor", func(t *testing.T) {
+		inner := NewRecordingEventSender()
+		s := NewFaultInjectingEventSender(inner)
+		s.QueueFaults(SenderFault{MustShutDown: true})
+		ep := makeProcessor(s)
+		defer ep.Close()
+
+		identify(ep, "a")
+		assert.True(t, ep.FlushBlocking(time.Second))
+		identify(ep, "b")
+		assert.True(t, ep.FlushBlocking(time.Second))
+
+		assert.Equal(t, FaultInjectionStats{Sends: 1, MustShutDown: 1}, s.Stats())
+		assert.Len(t, inner.Events(), 0)
+	})
+}
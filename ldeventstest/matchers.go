@@ -0,0 +1,233 @@
+package ldeventstest
+
+import (
+	"encoding/json"
+
+	ldevents "github.com/launchdarkly/go-sdk-events/v3"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldmigration"
+	"github.com/launchdarkly/go-sdk-common/v3/ldreason"
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	"github.com/launchdarkly/go-test-helpers/v3/jsonhelpers"
+	m "github.com/launchdarkly/go-test-helpers/v3/matchers"
+)
+
+// The matchers in this file operate on the JSON representation of a single output event, as
+// returned by RecordingEventSender. They can be applied to any value that can be converted to
+// JSON, such as json.RawMessage or []byte.
+
+// EqualNumericTime is a matcher for a JSON number that represents the given Unix millisecond time.
+func EqualNumericTime(unixTime ldtime.UnixMillisecondTime) m.Matcher {
+	// To avoid problems with mismatch of numeric types, it's simplest to use JSONEqual which compares as a JSON number
+	return m.JSONEqual(unixTime)
+}
+
+// EventKindIs matches an event whose "kind" property is the given string.
+func EventKindIs(kind string) m.Matcher {
+	return m.JSONProperty("kind").Should(m.Equal(kind))
+}
+
+// AnyFeatureEvent matches any feature event.
+func AnyFeatureEvent() m.Matcher { return EventKindIs(ldevents.FeatureRequestEventKind) }
+
+// AnyDebugEvent matches any debug event.
+func AnyDebugEvent() m.Matcher { return EventKindIs(ldevents.FeatureDebugEventKind) }
+
+// AnyCustomEvent matches any custom event.
+func AnyCustomEvent() m.Matcher { return EventKindIs(ldevents.CustomEventKind) }
+
+// AnyIdentifyEvent matches any identify event.
+func AnyIdentifyEvent() m.Matcher { return EventKindIs(ldevents.IdentifyEventKind) }
+
+// AnyIndexEvent matches any index event.
+func AnyIndexEvent() m.Matcher { return EventKindIs(ldevents.IndexEventKind) }
+
+// AnySummaryEvent matches any summary event.
+func AnySummaryEvent() m.Matcher { return EventKindIs(ldevents.SummaryEventKind) }
+
+// AnyMigrationOpEvent matches any migration operation event.
+func AnyMigrationOpEvent() m.Matcher { return EventKindIs(ldevents.MigrationOpEventKind) }
+
+// IdentifyEventForContextKey matches an identify event for a single-kind context with the given key.
+func IdentifyEventForContextKey(key string) m.Matcher {
+	return m.AllOf(
+		AnyIdentifyEvent(),
+		m.JSONProperty("context").Should(m.JSONProperty("key").Should(m.Equal(key))),
+	)
+}
+
+// IndexEventForContextKey matches an index event for a single-kind context with the given key.
+func IndexEventForContextKey(key string) m.Matcher {
+	return m.AllOf(
+		AnyIndexEvent(),
+		m.JSONProperty("context").Should(m.JSONProperty("key").Should(m.Equal(key))),
+	)
+}
+
+// FeatureEventForFlag matches a feature event for the given flag key.
+func FeatureEventForFlag(flagKey string) m.Matcher {
+	return m.AllOf(
+		AnyFeatureEvent(),
+		m.JSONProperty("key").Should(m.Equal(flagKey)),
+	)
+}
+
+// DebugEventForFlag matches a debug event for the given flag key.
+func DebugEventForFlag(flagKey string) m.Matcher {
+	return m.AllOf(
+		AnyDebugEvent(),
+		m.JSONProperty("key").Should(m.Equal(flagKey)),
+	)
+}
+
+// FeatureEventWithAllProperties matches a feature event that was produced from the given input
+// event, with no additional or missing properties. The contextJSON parameter is the expected
+// JSON representation of the context, after any private attributes have been redacted.
+func FeatureEventWithAllProperties(sourceEvent ldevents.EvaluationData, contextJSON json.RawMessage) m.Matcher {
+	return matchFeatureOrDebugEvent(sourceEvent, ldevents.FeatureRequestEventKind, contextJSON)
+}
+
+// DebugEventWithAllProperties is the same as FeatureEventWithAllProperties, but for a debug event.
+func DebugEventWithAllProperties(sourceEvent ldevents.EvaluationData, contextJSON json.RawMessage) m.Matcher {
+	return matchFeatureOrDebugEvent(sourceEvent, ldevents.FeatureDebugEventKind, contextJSON)
+}
+
+func matchFeatureOrDebugEvent(sourceEvent ldevents.EvaluationData, kind string, contextJSON json.RawMessage) m.Matcher {
+	props := map[string]interface{}{
+		"kind":         kind,
+		"key":          sourceEvent.Key,
+		"context":      contextJSON,
+		"creationDate": sourceEvent.CreationDate,
+		"value":        sourceEvent.Value,
+		"default":      sourceEvent.Default,
+	}
+	if sourceEvent.Version.IsDefined() {
+		props["version"] = sourceEvent.Version.IntValue()
+	}
+	if sourceEvent.Variation.IsDefined() {
+		props["variation"] = sourceEvent.Variation.IntValue()
+	}
+	if sourceEvent.PrereqOf.IsDefined() {
+		props["prereqOf"] = sourceEvent.PrereqOf.StringValue()
+	}
+	if sourceEvent.Reason.GetKind() != "" {
+		props["reason"] = json.RawMessage(jsonhelpers.ToJSON(sourceEvent.Reason))
+	}
+	if v, ok := sourceEvent.SamplingRatio.Get(); ok && v != 1 {
+		props["samplingRatio"] = v
+	}
+	return m.JSONEqual(props)
+}
+
+// CustomEventWithEventKey matches a custom event with the given event key.
+func CustomEventWithEventKey(eventKey string) m.Matcher {
+	return m.AllOf(
+		AnyCustomEvent(),
+		m.JSONProperty("key").Should(m.Equal(eventKey)),
+	)
+}
+
+// CustomEventWithMetricValue matches a custom event with the given event key and metric value.
+func CustomEventWithMetricValue(eventKey string, metricValue float64) m.Matcher {
+	return m.AllOf(
+		CustomEventWithEventKey(eventKey),
+		m.JSONProperty("metricValue").Should(m.JSONEqual(metricValue)),
+	)
+}
+
+// ContextKeysAre matches an event whose "contextKeys" property, as used in custom and migration
+// operation events, has exactly the keys of the given context.
+func ContextKeysAre(context ldcontext.Context) m.Matcher {
+	return m.JSONProperty("contextKeys").Should(m.JSONEqual(ExpectedContextKeys(context)))
+}
+
+// ExpectedContextKeys returns a map of context kinds to keys for the given context, in the form
+// used by the "contextKeys" property.
+func ExpectedContextKeys(context ldcontext.Context) map[string]string {
+	ret := make(map[string]string)
+	for i := 0; i < context.IndividualContextCount(); i++ {
+		if ic := context.IndividualContextByIndex(i); ic.IsDefined() {
+			ret[string(ic.Kind())] = ic.Key()
+		}
+	}
+	return ret
+}
+
+// MigrationOpEventForFlag matches a migration operation event for the given flag key and operation.
+func MigrationOpEventForFlag(flagKey string, op ldmigration.Operation) m.Matcher {
+	return m.AllOf(
+		AnyMigrationOpEvent(),
+		m.JSONProperty("operation").Should(m.Equal(string(op))),
+		m.JSONProperty("evaluation").Should(m.JSONProperty("key").Should(m.Equal(flagKey))),
+	)
+}
+
+// MigrationOpMeasurement matches a migration operation event that has a measurement with the
+// given key (such as "latency_ms") whose properties match the given matchers.
+func MigrationOpMeasurement(key string, matchers ...m.Matcher) m.Matcher {
+	return m.JSONProperty("measurements").Should(m.JSONArray().Should(
+		m.New(
+			func(value interface{}) bool {
+				for _, item := range value.([]interface{}) {
+					if pass, _ := m.AllOf(append(matchers, m.JSONProperty("key").Should(m.Equal(key)))...).
+						Test(item); pass {
+						return true
+					}
+				}
+				return false
+			},
+			func() string {
+				return "has a measurement with key " + key + " that matches all expectations"
+			},
+			nil,
+		),
+	))
+}
+
+// SummaryEventWithFlag matches a summary event that has an entry for the given flag key, whose
+// counters match the given sets of counter matchers in any order. Use SummaryCounterProps to
+// create each set.
+func SummaryEventWithFlag(flagKey string, counterProps ...[]m.Matcher) m.Matcher {
+	counters := make([]m.Matcher, 0, len(counterProps))
+	for _, cp := range counterProps {
+		counters = append(counters, m.AllOf(cp...))
+	}
+	return m.AllOf(
+		AnySummaryEvent(),
+		m.JSONProperty("features").Should(
+			m.JSONProperty(flagKey).Should(
+				m.JSONProperty("counters").Should(m.ItemsInAnyOrder(counters...)),
+			),
+		),
+	)
+}
+
+// SummaryCounterProps returns matchers for one summary counter with the given properties. The
+// version should be empty if the counter is for an unknown flag.
+func SummaryCounterProps(
+	variation ldvalue.OptionalInt,
+	version ldvalue.OptionalInt,
+	value ldvalue.Value,
+	count int,
+) []m.Matcher {
+	ret := []m.Matcher{
+		m.JSONProperty("value").Should(m.JSONEqual(value)),
+		m.JSONProperty("count").Should(m.Equal(count)),
+		m.JSONOptProperty("variation").Should(m.JSONEqual(variation)),
+	}
+	if version.IsDefined() {
+		ret = append(ret, m.JSONProperty("version").Should(m.Equal(version.IntValue())))
+	} else {
+		ret = append(ret, m.JSONProperty("unknown").Should(m.Equal(true)))
+	}
+	return ret
+}
+
+// SummaryCounterPropsFromEval is a shortcut for SummaryCounterProps using the properties of an
+// EvaluationDetail.
+func SummaryCounterPropsFromEval(detail ldreason.EvaluationDetail, version int, count int) []m.Matcher {
+	return SummaryCounterProps(detail.VariationIndex, ldvalue.NewOptionalInt(version), detail.Value, count)
+}
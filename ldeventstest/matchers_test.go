@@ -0,0 +1,118 @@
+package ldeventstest
+
+import (
+	"encoding/json"
+	"testing"
+
+	ldevents "github.com/launchdarkly/go-sdk-events/v3"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldmigration"
+	"github.com/launchdarkly/go-sdk-common/v3/ldreason"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	m "github.com/launchdarkly/go-test-helpers/v3/matchers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertMatches(t *testing.T, matcher m.Matcher, eventJSON string) {
+	t.Helper()
+	m.In(t).Assert(json.RawMessage(eventJSON), matcher)
+}
+
+func assertDoesNotMatch(t *testing.T, matcher m.Matcher, eventJSON string) {
+	t.Helper()
+	pass, _ := matcher.Test(json.RawMessage(eventJSON))
+	assert.False(t, pass, "should not have matched: %s", eventJSON)
+}
+
+func TestEventKindMatchers(t *testing.T) {
+	for kind, matcher := range map[string]m.Matcher{
+		"feature":      AnyFeatureEvent(),
+		"debug":        AnyDebugEvent(),
+		"custom":       AnyCustomEvent(),
+		"identify":     AnyIdentifyEvent(),
+		"index":        AnyIndexEvent(),
+		"summary":      AnySummaryEvent(),
+		"migration_op": AnyMigrationOpEvent(),
+	} {
+		t.Run(kind, func(t *testing.T) {
+			assertMatches(t, matcher, `{"kind":"`+kind+`"}`)
+			assertDoesNotMatch(t, matcher, `{"kind":"other"}`)
+		})
+	}
+}
+
+func TestContextKeyMatchers(t *testing.T) {
+	assertMatches(t, IndexEventForContextKey("a"), `{"kind":"index","context":{"kind":"user","key":"a"}}`)
+	assertDoesNotMatch(t, IndexEventForContextKey("a"), `{"kind":"index","context":{"kind":"user","key":"b"}}`)
+	assertMatches(t, IdentifyEventForContextKey("a"), `{"kind":"identify","context":{"kind":"user","key":"a"}}`)
+	assertDoesNotMatch(t, IdentifyEventForContextKey("a"), `{"kind":"index","context":{"kind":"user","key":"a"}}`)
+
+	context := ldcontext.NewMulti(ldcontext.New("a"), ldcontext.NewWithKind("org", "b"))
+	assertMatches(t, ContextKeysAre(context), `{"contextKeys":{"user":"a","org":"b"}}`)
+	assertDoesNotMatch(t, ContextKeysAre(context), `{"contextKeys":{"user":"a"}}`)
+}
+
+func TestFeatureEventWithAllProperties(t *testing.T) {
+	event := ldevents.EvaluationData{
+		BaseEvent: ldevents.BaseEvent{CreationDate: 1000},
+		Key:       "flag-key",
+		Version:   ldvalue.NewOptionalInt(2),
+		Variation: ldvalue.NewOptionalInt(1),
+		Value:     ldvalue.String("x"),
+		Default:   ldvalue.String("y"),
+		Reason:    ldreason.NewEvalReasonFallthrough(),
+		PrereqOf:  ldvalue.NewOptionalString("other"),
+	}
+	contextJSON := json.RawMessage(`{"kind":"user","key":"a"}`)
+	eventJSON := `{"kind":"feature","creationDate":1000,"key":"flag-key","version":2,"variation":1,"value":"x",` +
+		`"default":"y","prereqOf":"other","reason":{"kind":"FALLTHROUGH"},"context":{"kind":"user","key":"a"}}`
+
+	assertMatches(t, FeatureEventWithAllProperties(event, contextJSON), eventJSON)
+	assertMatches(t, FeatureEventForFlag("flag-key"), eventJSON)
+	assertDoesNotMatch(t, DebugEventWithAllProperties(event, contextJSON), eventJSON)
+	assertDoesNotMatch(t, FeatureEventForFlag("other-key"), eventJSON)
+
+	debugJSON := `{"kind":"debug","creationDate":1000,"key":"flag-key","version":2,"variation":1,"value":"x",` +
+		`"default":"y","prereqOf":"other","reason":{"kind":"FALLTHROUGH"},"context":{"kind":"user","key":"a"}}`
+	assertMatches(t, DebugEventWithAllProperties(event, contextJSON), debugJSON)
+	assertMatches(t, DebugEventForFlag("flag-key"), debugJSON)
+}
+
+func TestCustomEventMatchers(t *testing.T) {
+	eventJSON := `{"kind":"custom","key":"e","metricValue":1.5}`
+	assertMatches(t, CustomEventWithEventKey("e"), eventJSON)
+	assertMatches(t, CustomEventWithMetricValue("e", 1.5), eventJSON)
+	assertDoesNotMatch(t, CustomEventWithMetricValue("e", 2), eventJSON)
+	assertDoesNotMatch(t, CustomEventWithEventKey("f"), eventJSON)
+}
+
+func TestSummaryEventMatchers(t *testing.T) {
+	eventJSON := `{"kind":"summary","startDate":1,"endDate":2,"features":{"flag-key":{"default":"d","counters":[` +
+		`{"variation":1,"version":2,"value":"x","count":3},{"unknown":true,"value":"d","count":1}],"contextKinds":["user"]}}}`
+
+	detail := ldreason.NewEvaluationDetail(ldvalue.String("x"), 1, ldreason.NewEvalReasonFallthrough())
+	assertMatches(t, SummaryEventWithFlag("flag-key",
+		SummaryCounterPropsFromEval(detail, 2, 3),
+		SummaryCounterProps(ldvalue.OptionalInt{}, ldvalue.OptionalInt{}, ldvalue.String("d"), 1),
+	), eventJSON)
+	assertDoesNotMatch(t, SummaryEventWithFlag("flag-key",
+		SummaryCounterPropsFromEval(detail, 2, 4),
+		SummaryCounterProps(ldvalue.OptionalInt{}, ldvalue.OptionalInt{}, ldvalue.String("d"), 1),
+	), eventJSON)
+}
+
+func TestMigrationOpEventMatchers(t *testing.T) {
+	eventJSON := `{"kind":"migration_op","operation":"write","evaluation":{"key":"flag-key"},"measurements":[` +
+		`{"key":"invoked","values":{"old":true}},{"key":"latency_ms","values":{"old":10}}]}`
+
+	assertMatches(t, MigrationOpEventForFlag("flag-key", ldmigration.Write), eventJSON)
+	assertDoesNotMatch(t, MigrationOpEventForFlag("flag-key", ldmigration.Read), eventJSON)
+	assertMatches(t, MigrationOpMeasurement("latency_ms",
+		m.JSONProperty("values").Should(m.JSONEqual(map[string]int{"old": 10}))), eventJSON)
+	assertDoesNotMatch(t, MigrationOpMeasurement("latency_ms",
+		m.JSONProperty("values").Should(m.JSONEqual(map[string]int{"old": 11}))), eventJSON)
+	assertDoesNotMatch(t, MigrationOpMeasurement("error"), eventJSON)
+}
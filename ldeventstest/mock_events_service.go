@@ -0,0 +1,356 @@
+package ldeventstest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	ldevents "github.com/launchdarkly/go-sdk-events/v3"
+)
+
+const (
+	eventSchemaHeader = "X-LaunchDarkly-Event-Schema"
+	payloadIDHeader   = "X-LaunchDarkly-Payload-ID"
+	bulkPath          = "/bulk"
+	diagnosticPath    = "/diagnostic"
+)
+
+// Fault describes a way in which MockEventsService should misbehave when handling a request. The
+// zero value means no fault: the request is handled normally.
+type Fault struct {
+	// Delay is how long to wait before responding.
+	Delay time.Duration
+	// Status, if non-zero, is an HTTP status to respond with instead of accepting the payload.
+	Status int
+	// RetryAfter, if non-zero, is added to the response as a Retry-After header. Since the header
+	// is in whole seconds, it is rounded up to the next second.
+	RetryAfter time.Duration
+	// DropConnection causes the connection to be closed without any response, after any Delay.
+	DropConnection bool
+}
+
+// FaultStatus returns a Fault that responds with the given HTTP status.
+func FaultStatus(status int) Fault {
+	return Fault{Status: status}
+}
+
+// FaultUnauthorized returns a Fault that responds with a 401 error, which the SDK treats as an
+// invalid SDK key.
+func FaultUnauthorized() Fault {
+	return FaultStatus(http.StatusUnauthorized)
+}
+
+// FaultPayloadTooLarge returns a Fault that responds with a 413 error.
+func FaultPayloadTooLarge() Fault {
+	return FaultStatus(http.StatusRequestEntityTooLarge)
+}
+
+// FaultTooManyRequests returns a Fault that responds with a 429 error and a Retry-After header.
+func FaultTooManyRequests(retryAfter time.Duration) Fault {
+	return Fault{Status: http.StatusTooManyRequests, RetryAfter: retryAfter}
+}
+
+// FaultServiceUnavailable returns a Fault that responds with a 503 error.
+func FaultServiceUnavailable() Fault {
+	return FaultStatus(http.StatusServiceUnavailable)
+}
+
+// FaultSlowResponse returns a Fault that handles the request normally, but only after a delay.
+func FaultSlowResponse(delay time.Duration) Fault {
+	return Fault{Delay: delay}
+}
+
+// FaultDropConnection returns a Fault that closes the connection without responding.
+func FaultDropConnection() Fault {
+	return Fault{DropConnection: true}
+}
+
+// TestingT is the subset of the testing.T methods that MockEventsService uses. It has the same
+// methods as TestingT in the go-test-helpers matchers package.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+	FailNow()
+}
+
+// ReceivedRequest describes a request that MockEventsService received, whether or not it was
+// accepted.
+type ReceivedRequest struct {
+	// Kind is AnalyticsEventDataKind for /bulk, or DiagnosticEventDataKind for /diagnostic,
+	// regardless of whether the request was accepted. It is empty for any other path.
+	Kind ldevents.EventDataKind
+	// Header contains the request headers.
+	Header http.Header
+	// Body is the request body.
+	Body []byte
+	// Status is the HTTP status of the response, or zero if the connection was dropped.
+	Status int
+}
+
+// PayloadID returns the value of the X-LaunchDarkly-Payload-ID header.
+func (r ReceivedRequest) PayloadID() string {
+	return r.Header.Get(payloadIDHeader)
+}
+
+// SchemaVersion returns the value of the X-LaunchDarkly-Event-Schema header.
+func (r ReceivedRequest) SchemaVersion() string {
+	return r.Header.Get(eventSchemaHeader)
+}
+
+// Events returns the individual events in an analytics payload, or a single-element slice for a
+// diagnostic payload.
+func (r ReceivedRequest) Events() []json.RawMessage {
+	if r.Kind == ldevents.DiagnosticEventDataKind {
+		return []json.RawMessage{r.Body}
+	}
+	var events []json.RawMessage
+	_ = json.Unmarshal(r.Body, &events) // the body was already validated when the request was received
+	return events
+}
+
+// MockEventsService is an HTTP server that imitates the LaunchDarkly events service, for use in
+// integration tests. Point EventSenderConfiguration.BaseURI at URL to use it.
+//
+// Like the real service, it accepts POST requests to /bulk and /diagnostic with a 202 status and a
+// Date header. A /bulk request must have a numeric X-LaunchDarkly-Event-Schema header and a
+// body that is a JSON array; a /diagnostic request must have a body that is a JSON object. If
+// RequireAuthorization has been called, every request must also have that Authorization header.
+// Invalid requests receive a 400, 401, 404, or 405 error.
+//
+// Accepted payloads are recorded by payload ID, so a payload that is retried after a failure is
+// only recorded once. Every request, including rejected ones, is also recorded separately.
+//
+// Faults can be injected with QueueFaults, which applies each fault to one request in turn, or
+// with SetFault, which applies a fault to every request until ClearFaults is called.
+type MockEventsService struct {
+	server        *httptest.Server
+	requests      []ReceivedRequest
+	payloads      []ReceivedRequest
+	payloadIDs    map[string]int
+	faultQueue    []Fault
+	fault         Fault
+	authorization string
+	serverTimeFn  func() time.Time
+	receivedCh    chan struct{}
+	payloadsRead  int
+	lock          sync.Mutex
+}
+
+// NewMockEventsService starts a MockEventsService. The caller is responsible for calling Close.
+func NewMockEventsService() *MockEventsService {
+	s := &MockEventsService{
+		payloadIDs:   make(map[string]int),
+		serverTimeFn: time.Now,
+		receivedCh:   make(chan struct{}),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// URL returns the base URI of the service.
+func (s *MockEventsService) URL() string {
+	return s.server.URL
+}
+
+// Client returns an HTTP client that is configured to talk to the service.
+func (s *MockEventsService) Client() *http.Client {
+	return s.server.Client()
+}
+
+// SenderConfig returns an EventSenderConfiguration that will deliver events to the service.
+func (s *MockEventsService) SenderConfig() ldevents.EventSenderConfiguration {
+	return ldevents.EventSenderConfiguration{
+		Client:     s.Client(),
+		BaseURI:    s.URL(),
+		RetryDelay: time.Millisecond,
+	}
+}
+
+// Close shuts down the service.
+func (s *MockEventsService) Close() {
+	s.server.CloseClientConnections()
+	s.server.Close()
+}
+
+// RequireAuthorization causes the service to reject any request whose Authorization header is
+// not equal to key, with a 401 error.
+func (s *MockEventsService) RequireAuthorization(key string) {
+	s.lock.Lock()
+	s.authorization = key
+	s.lock.Unlock()
+}
+
+// SetServerTime changes the source of the time reported in the Date header of each response.
+func (s *MockEventsService) SetServerTime(timeFn func() time.Time) {
+	s.lock.Lock()
+	s.serverTimeFn = timeFn
+	s.lock.Unlock()
+}
+
+// QueueFaults adds faults to a queue. Each request that the service receives takes the next fault
+// from the queue, if any. Use Fault{} to script a request that should be handled normally.
+func (s *MockEventsService) QueueFaults(faults ...Fault) {
+	s.lock.Lock()
+	s.faultQueue = append(s.faultQueue, faults...)
+	s.lock.Unlock()
+}
+
+// SetFault applies a fault to every request for which no fault has been queued, until ClearFaults
+// is called.
+func (s *MockEventsService) SetFault(fault Fault) {
+	s.lock.Lock()
+	s.fault = fault
+	s.lock.Unlock()
+}
+
+// ClearFaults removes all queued faults and any fault set with SetFault.
+func (s *MockEventsService) ClearFaults() {
+	s.lock.Lock()
+	s.faultQueue = nil
+	s.fault = Fault{}
+	s.lock.Unlock()
+}
+
+// Requests returns every request that has been received so far.
+func (s *MockEventsService) Requests() []ReceivedRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]ReceivedRequest(nil), s.requests...)
+}
+
+// Payloads returns every distinct payload that has been accepted so far.
+func (s *MockEventsService) Payloads() []ReceivedRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]ReceivedRequest(nil), s.payloads...)
+}
+
+// PayloadByID returns the accepted analytics payload with the given payload ID, if any.
+func (s *MockEventsService) PayloadByID(id string) (ReceivedRequest, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if i, ok := s.payloadIDs[id]; ok {
+		return s.payloads[i], true
+	}
+	return ReceivedRequest{}, false
+}
+
+// AwaitPayload waits for the next accepted payload that has not already been returned by
+// AwaitPayload. If none arrives within DefaultAwaitTimeout, it causes the test to fail and stop.
+func (s *MockEventsService) AwaitPayload(t TestingT) ReceivedRequest {
+	if h, ok := t.(helperT); ok {
+		h.Helper()
+	}
+	deadline := time.NewTimer(DefaultAwaitTimeout)
+	defer deadline.Stop()
+	for {
+		s.lock.Lock()
+		if s.payloadsRead < len(s.payloads) {
+			p := s.payloads[s.payloadsRead]
+			s.payloadsRead++
+			s.lock.Unlock()
+			return p
+		}
+		receivedCh := s.receivedCh
+		s.lock.Unlock()
+		select {
+		case <-receivedCh:
+		case <-deadline.C:
+			t.Errorf("timed out waiting for events payload")
+			t.FailNow()
+			return ReceivedRequest{}
+		}
+	}
+}
+
+func (s *MockEventsService) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	req := ReceivedRequest{Header: r.Header.Clone(), Body: body}
+	switch r.URL.Path {
+	case bulkPath:
+		req.Kind = ldevents.AnalyticsEventDataKind
+	case diagnosticPath:
+		req.Kind = ldevents.DiagnosticEventDataKind
+	}
+
+	s.lock.Lock()
+	fault := s.fault
+	if len(s.faultQueue) > 0 {
+		fault = s.faultQueue[0]
+		s.faultQueue = s.faultQueue[1:]
+	}
+	authorization, serverTimeFn := s.authorization, s.serverTimeFn
+	s.lock.Unlock()
+
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			fault.DropConnection = true // the client gave up, so there is nobody to respond to
+		}
+	}
+
+	switch {
+	case fault.DropConnection:
+		req.Status = 0
+	case fault.Status != 0:
+		req.Status = fault.Status
+	case r.URL.Path == bulkPath:
+		var events []json.RawMessage
+		_, schemaErr := strconv.Atoi(r.Header.Get(eventSchemaHeader))
+		req.Status = validateRequest(r, authorization, schemaErr == nil && json.Unmarshal(body, &events) == nil)
+	case r.URL.Path == diagnosticPath:
+		var event map[string]json.RawMessage
+		req.Status = validateRequest(r, authorization, json.Unmarshal(body, &event) == nil)
+	default:
+		req.Status = http.StatusNotFound
+	}
+
+	s.lock.Lock()
+	s.requests = append(s.requests, req)
+	if req.Status == http.StatusAccepted {
+		id := req.PayloadID()
+		if _, seen := s.payloadIDs[id]; !seen || id == "" {
+			if id != "" {
+				s.payloadIDs[id] = len(s.payloads)
+			}
+			s.payloads = append(s.payloads, req)
+		}
+	}
+	close(s.receivedCh)
+	s.receivedCh = make(chan struct{})
+	s.lock.Unlock()
+
+	if req.Status == 0 {
+		if h, ok := w.(http.Hijacker); ok {
+			if conn, _, err := h.Hijack(); err == nil {
+				_ = conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler) // aborts the response without logging a stack trace
+	}
+	// The time is read after any delay, so that the Date header reflects when the response was sent.
+	w.Header().Set("Date", serverTimeFn().UTC().Format(http.TimeFormat))
+	if fault.RetryAfter > 0 {
+		seconds := (fault.RetryAfter + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.Itoa(int(seconds)))
+	}
+	w.WriteHeader(req.Status)
+}
+
+func validateRequest(r *http.Request, authorization string, bodyIsValid bool) int {
+	switch {
+	case r.Method != http.MethodPost:
+		return http.StatusMethodNotAllowed
+	case authorization != "" && r.Header.Get("Authorization") != authorization:
+		return http.StatusUnauthorized
+	case !bodyIsValid:
+		return http.StatusBadRequest
+	default:
+		return http.StatusAccepted
+	}
+}
@@ -0,0 +1,230 @@
+package ldeventstest
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	ldevents "github.com/launchdarkly/go-sdk-events/v3"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	m "github.com/launchdarkly/go-test-helpers/v3/matchers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var arbitraryPayload = []byte(`[{"kind":"custom","key":"a"},{"kind":"custom","key":"b"}]`) //nolint:gochecknoglobals
+
+func sendToMockService(s *MockEventsService, kind ldevents.EventDataKind, data []byte) ldevents.EventSenderResult {
+	return ldevents.SendEventDataWithRetry(s.SenderConfig(), kind, "", data, 1)
+}
+
+func TestMockEventsServiceAcceptsAnalyticsEvents(t *testing.T) {
+	s := NewMockEventsService()
+	defer s.Close()
+	serverTime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	s.SetServerTime(func() time.Time { return serverTime })
+
+	result := sendToMockService(s, ldevents.AnalyticsEventDataKind, arbitraryPayload)
+	assert.Equal(t, ldevents.EventSenderResult{Success: true, TimeFromServer: ldtime.UnixMillisFromTime(serverTime)},
+		result)
+
+	p := s.AwaitPayload(t)
+	assert.Equal(t, ldevents.AnalyticsEventDataKind, p.Kind)
+	assert.Equal(t, http.StatusAccepted, p.Status)
+	assert.Equal(t, "4", p.SchemaVersion())
+	assert.NotEqual(t, "", p.PayloadID())
+	m.In(t).Assert(p.Events(), m.Items(CustomEventWithEventKey("a"), CustomEventWithEventKey("b")))
+
+	byID, ok := s.PayloadByID(p.PayloadID())
+	assert.True(t, ok)
+	assert.Equal(t, p, byID)
+}
+
+func TestMockEventsServiceAcceptsDiagnosticEvents(t *testing.T) {
+	s := NewMockEventsService()
+	defer s.Close()
+
+	result := sendToMockService(s, ldevents.DiagnosticEventDataKind, []byte(`{"kind":"diagnostic"}`))
+	assert.True(t, result.Success)
+
+	p := s.AwaitPayload(t)
+	assert.Equal(t, ldevents.DiagnosticEventDataKind, p.Kind)
+	m.In(t).Assert(p.Events(), m.Items(EventKindIs("diagnostic")))
+}
+
+func TestMockEventsServiceRejectsInvalidRequests(t *testing.T) {
+	s := NewMockEventsService()
+	defer s.Close()
+
+	post := func(path string, header http.Header, body string) int {
+		req, _ := http.NewRequest("POST", s.URL()+path, bytes.NewBufferString(body))
+		for k, vv := range header {
+			req.Header[k] = vv
+		}
+		resp, err := s.Client().Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	schema := http.Header{eventSchemaHeader: {"4"}}
+
+	assert.Equal(t, http.StatusBadRequest, post(bulkPath, nil, `[]`))
+	assert.Equal(t, http.StatusBadRequest, post(bulkPath, http.Header{eventSchemaHeader: {"x"}}, `[]`))
+	assert.Equal(t, http.StatusBadRequest, post(bulkPath, schema, `{}`))
+	assert.Equal(t, http.StatusBadRequest, post(diagnosticPath, nil, `[]`))
+	assert.Equal(t, http.StatusNotFound, post("/other", schema, `[]`))
+
+	resp, err := s.Client().Get(s.URL() + bulkPath)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	assert.Len(t, s.Requests(), 6)
+	assert.Len(t, s.Payloads(), 0)
+}
+
+func TestMockEventsServiceRequireAuthorization(t *testing.T) {
+	s := NewMockEventsService()
+	defer s.Close()
+	s.RequireAuthorization("good-key")
+
+	badSender := ldevents.NewServerSideEventSender(s.SenderConfig(), "bad-key")
+	result := badSender.SendEventData(ldevents.AnalyticsEventDataKind, arbitraryPayload, 2)
+	assert.True(t, result.MustShutDown)
+
+	goodSender := ldevents.NewServerSideEventSender(s.SenderConfig(), "good-key")
+	result = goodSender.SendEventData(ldevents.AnalyticsEventDataKind, arbitraryPayload, 2)
+	assert.True(t, result.Success)
+	assert.Len(t, s.Payloads(), 1)
+}
+
+func TestMockEventsServiceRetriedPayloadIsRecordedOnce(t *testing.T) {
+	for name, fault := range map[string]Fault{
+		"503":                FaultServiceUnavailable(),
+		"429":                FaultTooManyRequests(time.Second),
+		"dropped connection": FaultDropConnection(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewMockEventsService()
+			defer s.Close()
+			s.QueueFaults(fault)
+
+			result := sendToMockService(s, ldevents.AnalyticsEventDataKind, arbitraryPayload)
+			assert.True(t, result.Success)
+
+			requests := s.Requests()
+			require.Len(t, requests, 2)
+			assert.Equal(t, fault.Status, requests[0].Status)
+			assert.Equal(t, ldevents.AnalyticsEventDataKind, requests[0].Kind)
+			assert.Equal(t, http.StatusAccepted, requests[1].Status)
+			assert.Equal(t, requests[0].PayloadID(), requests[1].PayloadID())
+			assert.Len(t, s.Payloads(), 1)
+		})
+	}
+}
+
+func TestMockEventsServiceTooManyRequestsHasRetryAfterHeader(t *testing.T) {
+	s := NewMockEventsService()
+	defer s.Close()
+	s.QueueFaults(FaultTooManyRequests(30 * time.Second))
+
+	resp, err := s.Client().Post(s.URL()+bulkPath, "application/json", bytes.NewBufferString(`[]`))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+}
+
+func TestMockEventsServiceSubSecondRetryAfterIsRoundedUp(t *testing.T) {
+	s := NewMockEventsService()
+	defer s.Close()
+	s.QueueFaults(FaultTooManyRequests(500 * time.Millisecond))
+
+	resp, err := s.Client().Post(s.URL()+bulkPath, "application/json", bytes.NewBufferString(`[]`))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+}
+
+func TestMockEventsServicePersistentFaults(t *testing.T) {
+	s := NewMockEventsService()
+	defer s.Close()
+
+	s.SetFault(FaultServiceUnavailable())
+	result := sendToMockService(s, ldevents.AnalyticsEventDataKind, arbitraryPayload)
+	assert.Equal(t, ldevents.EventSenderResult{}, result)
+	assert.Len(t, s.Requests(), 2)
+
+	s.SetFault(FaultPayloadTooLarge())
+	result = sendToMockService(s, ldevents.AnalyticsEventDataKind, arbitraryPayload)
+	assert.Equal(t, ldevents.EventSenderResult{}, result)
+	assert.Len(t, s.Requests(), 3) // 413 is not retried
+
+	s.SetFault(FaultUnauthorized())
+	result = sendToMockService(s, ldevents.AnalyticsEventDataKind, arbitraryPayload)
+	assert.Equal(t, ldevents.EventSenderResult{MustShutDown: true}, result)
+
+	s.ClearFaults()
+	result = sendToMockService(s, ldevents.AnalyticsEventDataKind, arbitraryPayload)
+	assert.True(t, result.Success)
+}
+
+func TestMockEventsServiceSlowResponse(t *testing.T) {
+	s := NewMockEventsService()
+	defer s.Close()
+	s.QueueFaults(FaultSlowResponse(time.Second), FaultSlowResponse(time.Second))
+
+	config := s.SenderConfig()
+	client := *s.Client()
+	client.Timeout = 50 * time.Millisecond
+	config.Client = &client
+	result := ldevents.SendEventDataWithRetry(config, ldevents.AnalyticsEventDataKind, "", arbitraryPayload, 2)
+	assert.False(t, result.Success)
+	assert.Len(t, s.Payloads(), 0)
+}
+
+func TestMockEventsServiceSlowResponseDateIsAfterDelay(t *testing.T) {
+	s := NewMockEventsService()
+	defer s.Close()
+	delay := 50 * time.Millisecond
+	s.QueueFaults(FaultSlowResponse(delay))
+	serverTimesCh := make(chan time.Time, 1)
+	s.SetServerTime(func() time.Time {
+		now := time.Now()
+		serverTimesCh <- now
+		return now
+	})
+
+	start := time.Now()
+	result := sendToMockService(s, ldevents.AnalyticsEventDataKind, arbitraryPayload)
+	require.True(t, result.Success)
+	assert.GreaterOrEqual(t, (<-serverTimesCh).Sub(start), delay)
+}
+
+func TestMockEventsServiceWithEventProcessor(t *testing.T) {
+	s := NewMockEventsService()
+	defer s.Close()
+	s.QueueFaults(FaultServiceUnavailable())
+
+	ep := ldevents.NewDefaultEventProcessor(ldevents.EventsConfiguration{
+		Capacity:         100,
+		EventSender:      ldevents.NewServerSideEventSender(s.SenderConfig(), "sdk-key"),
+		FlushInterval:    time.Hour,
+		UserKeysCapacity: 100,
+	})
+	defer ep.Close()
+
+	context := ldevents.Context(ldcontext.New("user-key"))
+	ep.RecordIdentifyEvent(ldevents.NewEventFactory(false, nil).NewIdentifyEventData(context, ldvalue.OptionalInt{}))
+	require.True(t, ep.FlushBlocking(time.Second))
+
+	p := s.AwaitPayload(t)
+	assert.Equal(t, "sdk-key", p.Header.Get("Authorization"))
+	m.In(t).Assert(p.Events(), m.Items(IdentifyEventForContextKey("user-key")))
+}
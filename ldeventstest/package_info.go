@@ -0,0 +1,10 @@
+// Package ldeventstest provides helpers for testing code that uses the ldevents package: an
+// EventSender that records what it is given, an EventSender that injects faults into another one,
+// a Clock whose time is controlled by the test, a mock events service, and matchers for the JSON
+// representation of events.
+//
+// The matchers are built on the matchers package in github.com/launchdarkly/go-test-helpers/v3,
+// so that package is a dependency of ldeventstest, but not of ldevents. The module already required
+// it for its own tests, so this does not add anything to the build of an application that only
+// imports ldevents.
+package ldeventstest
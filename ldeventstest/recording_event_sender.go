@@ -0,0 +1,248 @@
+package ldeventstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	ldevents "github.com/launchdarkly/go-sdk-events/v3"
+
+	"github.com/launchdarkly/go-test-helpers/v3/jsonhelpers"
+	m "github.com/launchdarkly/go-test-helpers/v3/matchers"
+)
+
+// DefaultAwaitTimeout is the default value for RecordingEventSender.AwaitTimeout.
+const DefaultAwaitTimeout = time.Second
+
+// RecordingEventSender is an implementation of ldevents.EventSender that records every payload it
+// is given, instead of delivering it anywhere. It is safe for concurrent use.
+//
+// Analytics event payloads are split into individual events. Each event can be retrieved either
+// with Events, which returns everything received so far, or with one of the Await methods, which
+// consume events in the order they were received and wait for more if necessary.
+type RecordingEventSender struct {
+	// AwaitTimeout is the maximum time that the Await methods will wait. If it is zero,
+	// DefaultAwaitTimeout is used. It should not be changed while the sender is in use.
+	AwaitTimeout time.Duration
+
+	events           []json.RawMessage
+	diagnosticEvents []json.RawMessage
+	eventsRead       int
+	diagnosticsRead  int
+	payloadCount     int
+	result           ldevents.EventSenderResult
+	gateCh           <-chan struct{}
+	waitingCh        chan<- struct{}
+	receivedCh       chan struct{}
+	lock             sync.Mutex
+}
+
+// NewRecordingEventSender creates a RecordingEventSender. By default, it reports every payload as
+// successfully delivered.
+func NewRecordingEventSender() *RecordingEventSender {
+	return &RecordingEventSender{
+		result:     ldevents.EventSenderResult{Success: true},
+		receivedCh: make(chan struct{}),
+	}
+}
+
+// SendEventData records a payload. It is called by the event processor.
+//
+// If the payload is analytics event data that is not a JSON array, SendEventData panics, since that
+// indicates a bug in whatever component produced it.
+func (s *RecordingEventSender) SendEventData(
+	kind ldevents.EventDataKind,
+	data []byte,
+	eventCount int,
+) ldevents.EventSenderResult {
+	var elements []json.RawMessage
+	if kind == ldevents.AnalyticsEventDataKind {
+		if err := json.Unmarshal(data, &elements); err != nil {
+			panic(fmt.Errorf("analytics event payload was not a JSON array: %w", err))
+		}
+	}
+
+	s.lock.Lock()
+	if kind == ldevents.DiagnosticEventDataKind {
+		s.diagnosticEvents = append(s.diagnosticEvents, data)
+	} else {
+		s.events = append(s.events, elements...)
+		s.payloadCount++
+	}
+	// Wake up anyone who is waiting for events, by closing the current channel and replacing it.
+	close(s.receivedCh)
+	s.receivedCh = make(chan struct{})
+	gateCh, waitingCh := s.gateCh, s.waitingCh
+	result := s.result
+	s.lock.Unlock()
+
+	if gateCh != nil {
+		if waitingCh != nil {
+			waitingCh <- struct{}{}
+		}
+		<-gateCh
+	}
+
+	return result
+}
+
+// SetResult changes the result that SendEventData will return for subsequent payloads.
+func (s *RecordingEventSender) SetResult(result ldevents.EventSenderResult) {
+	s.lock.Lock()
+	s.result = result
+	s.lock.Unlock()
+}
+
+// SetGate causes subsequent calls to SendEventData to block after recording their payload, so
+// that a test can control when each delivery completes. Each blocked call first sends a value to
+// waitingCh, if it is non-nil, and then waits to receive a value from gateCh. Passing a nil
+// gateCh removes the gate.
+func (s *RecordingEventSender) SetGate(gateCh <-chan struct{}, waitingCh chan<- struct{}) {
+	s.lock.Lock()
+	s.gateCh = gateCh
+	s.waitingCh = waitingCh
+	s.lock.Unlock()
+}
+
+// PayloadCount returns the number of analytics event payloads received so far.
+func (s *RecordingEventSender) PayloadCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.payloadCount
+}
+
+// Events returns all analytics events received so far, regardless of whether they have been
+// consumed by an Await method.
+func (s *RecordingEventSender) Events() []json.RawMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]json.RawMessage(nil), s.events...)
+}
+
+// DiagnosticEvents returns all diagnostic events received so far, regardless of whether they have
+// been consumed by an Await method.
+func (s *RecordingEventSender) DiagnosticEvents() []json.RawMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]json.RawMessage(nil), s.diagnosticEvents...)
+}
+
+// TryAwaitEvents waits until count analytics events are available that have not yet been
+// consumed, and then consumes and returns them. If the timeout elapses first, it returns
+// whatever events were available, without consuming them, and false.
+func (s *RecordingEventSender) TryAwaitEvents(count int) ([]json.RawMessage, bool) {
+	return s.tryAwait(count, false)
+}
+
+// AwaitEvents is the same as TryAwaitEvents, except that if the timeout elapses, it causes the
+// test to fail and stop.
+func (s *RecordingEventSender) AwaitEvents(t m.TestingT, count int) []json.RawMessage {
+	if h, ok := t.(helperT); ok {
+		h.Helper()
+	}
+	events, ok := s.TryAwaitEvents(count)
+	if !ok {
+		t.Errorf("timed out waiting for analytics events: wanted %d, got %d: %s",
+			count, len(events), jsonhelpers.ToJSONString(events))
+		t.FailNow()
+	}
+	return events
+}
+
+// AwaitEvent waits for and consumes a single analytics event. If the timeout elapses, it causes
+// the test to fail and stop.
+func (s *RecordingEventSender) AwaitEvent(t m.TestingT) json.RawMessage {
+	if h, ok := t.(helperT); ok {
+		h.Helper()
+	}
+	return s.AwaitEvents(t, 1)[0]
+}
+
+// AwaitDiagnosticEvent waits for and consumes a single diagnostic event. If the timeout elapses,
+// it causes the test to fail and stop.
+func (s *RecordingEventSender) AwaitDiagnosticEvent(t m.TestingT) json.RawMessage {
+	if h, ok := t.(helperT); ok {
+		h.Helper()
+	}
+	events, ok := s.tryAwait(1, true)
+	if !ok {
+		t.Errorf("timed out waiting for diagnostic event")
+		t.FailNow()
+	}
+	return events[0]
+}
+
+// AssertEventsReceived waits for and consumes one analytics event for each of the matchers, and
+// then verifies that the events match them in any order.
+func (s *RecordingEventSender) AssertEventsReceived(t m.TestingT, matchers ...m.Matcher) {
+	if h, ok := t.(helperT); ok {
+		h.Helper()
+	}
+	events := s.AwaitEvents(t, len(matchers))
+	m.In(t).Assert(events, m.ItemsInAnyOrder(matchers...))
+}
+
+// AssertNoMoreEvents verifies that there are no analytics events that have not been consumed.
+// It does not wait.
+func (s *RecordingEventSender) AssertNoMoreEvents(t m.TestingT) {
+	if h, ok := t.(helperT); ok {
+		h.Helper()
+	}
+	s.lock.Lock()
+	unread := s.events[s.eventsRead:]
+	s.lock.Unlock()
+	if len(unread) != 0 {
+		t.Errorf("expected no more analytics events, but got: %s", jsonhelpers.ToJSONString(unread))
+	}
+}
+
+// AssertNoMoreDiagnosticEvents verifies that there are no diagnostic events that have not been
+// consumed. It does not wait.
+func (s *RecordingEventSender) AssertNoMoreDiagnosticEvents(t m.TestingT) {
+	if h, ok := t.(helperT); ok {
+		h.Helper()
+	}
+	s.lock.Lock()
+	unread := s.diagnosticEvents[s.diagnosticsRead:]
+	s.lock.Unlock()
+	if len(unread) != 0 {
+		t.Errorf("expected no more diagnostic events, but got: %s", jsonhelpers.ToJSONString(unread))
+	}
+}
+
+func (s *RecordingEventSender) tryAwait(count int, diagnostic bool) ([]json.RawMessage, bool) {
+	timeout := s.AwaitTimeout
+	if timeout <= 0 {
+		timeout = DefaultAwaitTimeout
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.lock.Lock()
+		all, read := s.events, &s.eventsRead
+		if diagnostic {
+			all, read = s.diagnosticEvents, &s.diagnosticsRead
+		}
+		if len(all)-*read >= count {
+			ret := append([]json.RawMessage(nil), all[*read:*read+count]...)
+			*read += count
+			s.lock.Unlock()
+			return ret, true
+		}
+		unread := append([]json.RawMessage(nil), all[*read:]...)
+		receivedCh := s.receivedCh
+		s.lock.Unlock()
+
+		select {
+		case <-receivedCh:
+		case <-deadline.C:
+			return unread, false
+		}
+	}
+}
+
+// helperT is implemented by testing.T and similar types, to exclude helper functions from stacktraces.
+type helperT interface {
+	Helper()
+}
@@ -0,0 +1,123 @@
+package ldeventstest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	ldevents "github.com/launchdarkly/go-sdk-events/v3"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeT implements matchers.TestingT to let us verify that assertions fail without failing the real test.
+type fakeT struct {
+	failed bool
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) { t.failed = true }
+func (t *fakeT) FailNow()                                  {}
+
+func TestRecordingEventSenderSplitsAnalyticsPayloads(t *testing.T) {
+	s := NewRecordingEventSender()
+	result := s.SendEventData(ldevents.AnalyticsEventDataKind, []byte(`[{"kind":"a"},{"kind":"b"}]`), 2)
+	assert.Equal(t, ldevents.EventSenderResult{Success: true}, result)
+
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`{"kind":"a"}`), json.RawMessage(`{"kind":"b"}`)}, s.Events())
+	assert.Equal(t, 1, s.PayloadCount())
+	assert.Len(t, s.DiagnosticEvents(), 0)
+}
+
+func TestRecordingEventSenderRecordsDiagnosticEvents(t *testing.T) {
+	s := NewRecordingEventSender()
+	s.SendEventData(ldevents.DiagnosticEventDataKind, []byte(`{"kind":"diagnostic"}`), 1)
+
+	assert.Equal(t, json.RawMessage(`{"kind":"diagnostic"}`), s.AwaitDiagnosticEvent(t))
+	s.AssertNoMoreDiagnosticEvents(t)
+	assert.Equal(t, 0, s.PayloadCount())
+}
+
+func TestRecordingEventSenderAwaitConsumesEventsInOrder(t *testing.T) {
+	s := NewRecordingEventSender()
+	s.SendEventData(ldevents.AnalyticsEventDataKind, []byte(`[{"kind":"a"},{"kind":"b"}]`), 2)
+	go s.SendEventData(ldevents.AnalyticsEventDataKind, []byte(`[{"kind":"c"}]`), 1)
+
+	assert.Equal(t, json.RawMessage(`{"kind":"a"}`), s.AwaitEvent(t))
+	events := s.AwaitEvents(t, 2)
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`{"kind":"b"}`), json.RawMessage(`{"kind":"c"}`)}, events)
+	s.AssertNoMoreEvents(t)
+	assert.Len(t, s.Events(), 3)
+}
+
+func TestRecordingEventSenderAwaitTimesOut(t *testing.T) {
+	s := NewRecordingEventSender()
+	s.AwaitTimeout = 10 * time.Millisecond
+	s.SendEventData(ldevents.AnalyticsEventDataKind, []byte(`[{"kind":"a"}]`), 1)
+
+	events, ok := s.TryAwaitEvents(2)
+	assert.False(t, ok)
+	assert.Len(t, events, 1)
+
+	ft := &fakeT{}
+	s.AwaitEvents(ft, 2)
+	assert.True(t, ft.failed)
+
+	ft = &fakeT{}
+	s.AssertNoMoreEvents(ft)
+	assert.True(t, ft.failed) // the event was not consumed by the failed await
+}
+
+func TestRecordingEventSenderSetResult(t *testing.T) {
+	s := NewRecordingEventSender()
+	s.SetResult(ldevents.EventSenderResult{MustShutDown: true})
+	result := s.SendEventData(ldevents.AnalyticsEventDataKind, []byte(`[]`), 0)
+	assert.Equal(t, ldevents.EventSenderResult{MustShutDown: true}, result)
+}
+
+func TestRecordingEventSenderGate(t *testing.T) {
+	s := NewRecordingEventSender()
+	gateCh, waitingCh := make(chan struct{}), make(chan struct{}, 1)
+	s.SetGate(gateCh, waitingCh)
+
+	doneCh := make(chan struct{})
+	go func() {
+		s.SendEventData(ldevents.AnalyticsEventDataKind, []byte(`[{"kind":"a"}]`), 1)
+		close(doneCh)
+	}()
+
+	<-waitingCh
+	_ = s.AwaitEvent(t) // the payload is recorded before the gate
+	select {
+	case <-doneCh:
+		require.Fail(t, "SendEventData should not have returned")
+	case <-time.After(20 * time.Millisecond):
+	}
+	gateCh <- struct{}{}
+	<-doneCh
+}
+
+func TestRecordingEventSenderWithEventProcessor(t *testing.T) {
+	s := NewRecordingEventSender()
+	ep := ldevents.NewDefaultEventProcessor(ldevents.EventsConfiguration{
+		Capacity:         100,
+		EventSender:      s,
+		FlushInterval:    time.Hour,
+		UserKeysCapacity: 100,
+	})
+	defer ep.Close()
+
+	context := ldevents.Context(ldcontext.New("user-key"))
+	ep.RecordCustomEvent(ldevents.NewEventFactory(false, nil).NewCustomEventData(
+		"event-key", context, ldvalue.Null(), true, 2.5, ldvalue.OptionalInt{}))
+	ep.Flush()
+
+	s.AssertEventsReceived(t,
+		IndexEventForContextKey("user-key"),
+		CustomEventWithMetricValue("event-key", 2.5),
+	)
+	s.AssertNoMoreEvents(t)
+}
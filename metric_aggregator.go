@@ -0,0 +1,188 @@
+package ldevents
+
+import (
+	"math"
+	"sort"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+)
+
+// Aggregates the metric values of custom events for the keys in
+// EventsConfiguration.AggregatedMetricEventKeys. Like eventSummarizer, it is not thread-safe, because
+// it is only used from the event-processing goroutine.
+//
+// Aggregated values do not count toward EventsConfiguration.Capacity. Since the aggregated data does
+// not refer to individual contexts, it cannot be used in experiments. Custom events with these keys
+// but no metric value are sent as usual.
+type metricAggregator struct {
+	keys   map[string]struct{}
+	bounds []float64
+	state  metricSummary
+}
+
+// MetricSummary is the data that was aggregated for EventsConfiguration.AggregatedMetricEventKeys
+// during one flush interval. See EventsConfiguration.OnMetricSummary.
+type MetricSummary struct {
+	// StartDate and EndDate are the times of the first and last aggregated events.
+	StartDate ldtime.UnixMillisecondTime
+	EndDate   ldtime.UnixMillisecondTime
+	// HistogramBounds are the upper bounds of the histogram buckets, in ascending order.
+	HistogramBounds []float64
+	// Metrics has an entry for each event key and context kind, sorted by key and then by kind.
+	Metrics []MetricAggregate
+}
+
+// MetricAggregate is the aggregated data for one event key and context kind in a MetricSummary.
+//
+// If some of the events were sampled, each event that was kept is counted as many times as its
+// sampling ratio, so Count, Sum, and Histogram are estimates of the totals for all of the events.
+type MetricAggregate struct {
+	Key         string
+	ContextKind ldcontext.Kind
+	Count       int
+	Sum         float64
+	Min         float64
+	Max         float64
+	// Histogram[i] is the number of values that were greater than HistogramBounds[i-1] and no
+	// greater than HistogramBounds[i]. The last element counts the values greater than the last bound.
+	Histogram []int
+}
+
+// metricSummary is the aggregated data for one flush. It is output as a single event of kind
+// MetricSummaryEventKind if EventsConfiguration.SendMetricSummaryEvents is true.
+type metricSummary struct {
+	metrics   map[metricKey]*metricAggregate
+	bounds    []float64
+	startDate ldtime.UnixMillisecondTime
+	endDate   ldtime.UnixMillisecondTime
+}
+
+type metricKey struct {
+	key         string
+	contextKind ldcontext.Kind
+}
+
+type metricAggregate struct {
+	count int
+	sum   float64
+	min   float64
+	max   float64
+	// bucketCounts[i] is the number of values that were greater than bounds[i-1] and no greater than
+	// bounds[i]. The last bucket counts the values greater than the last bound.
+	bucketCounts []int
+}
+
+// defaultMetricHistogramBounds returns the histogram bounds that are used if
+// EventsConfiguration.MetricHistogramBounds is empty: a 1-2-5 series from 1 to 100000, which suits
+// both latencies in milliseconds and monetary amounts.
+func defaultMetricHistogramBounds() []float64 {
+	var bounds []float64
+	for scale := 1.0; scale <= 100000; scale *= 10 {
+		bounds = append(bounds, scale, 2*scale, 5*scale)
+	}
+	return bounds[:len(bounds)-2]
+}
+
+func newMetricAggregator(keys []string, bounds []float64) metricAggregator {
+	a := metricAggregator{keys: make(map[string]struct{}, len(keys))}
+	for _, key := range keys {
+		a.keys[key] = struct{}{}
+	}
+	if len(bounds) == 0 {
+		a.bounds = defaultMetricHistogramBounds()
+	} else {
+		a.bounds = append([]float64(nil), bounds...)
+		sort.Float64s(a.bounds)
+	}
+	a.reset()
+	return a
+}
+
+// shouldAggregate returns true if the event's metric value should be aggregated rather than sent
+// in an individual event. Values that cannot be represented in JSON are never aggregated.
+func (a *metricAggregator) shouldAggregate(evt CustomEventData) bool {
+	if !evt.HasMetric || math.IsNaN(evt.MetricValue) || math.IsInf(evt.MetricValue, 0) {
+		return false
+	}
+	_, ok := a.keys[evt.Key]
+	return ok
+}
+
+// aggregate adds the event's metric value to the aggregate for its key and for each kind of context
+// it has, so a multi-kind context is counted once for each kind. The weight is the event's sampling
+// ratio, since the event stands for that many events of which only one was kept.
+func (a *metricAggregator) aggregate(evt CustomEventData, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	for i := 0; i < evt.Context.context.IndividualContextCount(); i++ {
+		if ic := evt.Context.context.IndividualContextByIndex(i); ic.IsDefined() {
+			agg := a.state.aggregateFor(metricKey{key: evt.Key, contextKind: ic.Kind()})
+			agg.add(evt.MetricValue, weight, a.bounds)
+		}
+	}
+	if a.state.startDate == 0 || evt.CreationDate < a.state.startDate {
+		a.state.startDate = evt.CreationDate
+	}
+	if evt.CreationDate > a.state.endDate {
+		a.state.endDate = evt.CreationDate
+	}
+}
+
+// Returns a snapshot of the current aggregated data.
+func (a *metricAggregator) snapshot() metricSummary {
+	return a.state
+}
+
+func (a *metricAggregator) reset() {
+	a.state = metricSummary{metrics: make(map[metricKey]*metricAggregate), bounds: a.bounds}
+}
+
+func (s metricSummary) hasData() bool {
+	return len(s.metrics) != 0
+}
+
+// export returns a copy of the data as a MetricSummary.
+func (s metricSummary) export() MetricSummary {
+	ret := MetricSummary{
+		StartDate:       s.startDate,
+		EndDate:         s.endDate,
+		HistogramBounds: append([]float64(nil), s.bounds...),
+		Metrics:         make([]MetricAggregate, 0, len(s.metrics)),
+	}
+	for _, key := range metricKeys(s.metrics, true) {
+		agg := s.metrics[key]
+		ret.Metrics = append(ret.Metrics, MetricAggregate{
+			Key:         key.key,
+			ContextKind: key.contextKind,
+			Count:       agg.count,
+			Sum:         agg.sum,
+			Min:         agg.min,
+			Max:         agg.max,
+			Histogram:   append([]int(nil), agg.bucketCounts...),
+		})
+	}
+	return ret
+}
+
+func (s metricSummary) aggregateFor(key metricKey) *metricAggregate {
+	agg := s.metrics[key]
+	if agg == nil {
+		agg = &metricAggregate{bucketCounts: make([]int, len(s.bounds)+1)}
+		s.metrics[key] = agg
+	}
+	return agg
+}
+
+func (m *metricAggregate) add(value float64, weight int, bounds []float64) {
+	if m.count == 0 || value < m.min {
+		m.min = value
+	}
+	if m.count == 0 || value > m.max {
+		m.max = value
+	}
+	m.count += weight
+	m.sum += value * float64(weight)
+	m.bucketCounts[sort.SearchFloat64s(bounds, value)] += weight
+}
@@ -0,0 +1,108 @@
+package ldevents
+
+import (
+	"math"
+	"testing"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeMetricEvent(key string, context ldcontext.Context, creationDate ldtime.UnixMillisecondTime,
+	value float64) CustomEventData {
+	return CustomEventData{
+		BaseEvent:   BaseEvent{CreationDate: creationDate, Context: Context(context)},
+		Key:         key,
+		Data:        ldvalue.Null(),
+		HasMetric:   true,
+		MetricValue: value,
+	}
+}
+
+func TestDefaultMetricHistogramBounds(t *testing.T) {
+	assert.Equal(t, []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000, 50000, 100000},
+		defaultMetricHistogramBounds())
+	assert.Equal(t, defaultMetricHistogramBounds(), newMetricAggregator(nil, nil).bounds)
+}
+
+func TestMetricAggregatorShouldAggregate(t *testing.T) {
+	a := newMetricAggregator([]string{"latency"}, nil)
+	user := ldcontext.New("u")
+
+	assert.True(t, a.shouldAggregate(makeMetricEvent("latency", user, 0, 1)))
+	assert.False(t, a.shouldAggregate(makeMetricEvent("other", user, 0, 1)))
+	withoutMetric := makeMetricEvent("latency", user, 0, 0)
+	withoutMetric.HasMetric = false
+	assert.False(t, a.shouldAggregate(withoutMetric))
+	assert.False(t, a.shouldAggregate(makeMetricEvent("latency", user, 0, math.NaN())))
+	assert.False(t, a.shouldAggregate(makeMetricEvent("latency", user, 0, math.Inf(1))))
+}
+
+func TestMetricAggregatorAggregate(t *testing.T) {
+	a := newMetricAggregator([]string{"latency", "cart"}, []float64{100, 10, 50})
+	user, org := ldcontext.New("u"), ldcontext.NewWithKind("org", "o")
+	multi := ldcontext.NewMulti(user, org)
+	for _, e := range []CustomEventData{
+		makeMetricEvent("latency", user, 2000, 5),
+		makeMetricEvent("latency", user, 1000, 10),
+		makeMetricEvent("latency", multi, 3000, 75),
+		makeMetricEvent("latency", user, 1500, 500),
+		makeMetricEvent("cart", org, 1200, -2.5),
+	} {
+		a.aggregate(e, 1)
+	}
+	data := a.snapshot()
+
+	assert.Equal(t, metricSummary{
+		bounds:    []float64{10, 50, 100},
+		startDate: 1000,
+		endDate:   3000,
+		metrics: map[metricKey]*metricAggregate{
+			{"latency", ldcontext.DefaultKind}: {
+				count: 4, sum: 590, min: 5, max: 500, bucketCounts: []int{2, 0, 1, 1},
+			},
+			{"latency", "org"}: {
+				count: 1, sum: 75, min: 75, max: 75, bucketCounts: []int{0, 0, 1, 0},
+			},
+			{"cart", "org"}: {
+				count: 1, sum: -2.5, min: -2.5, max: -2.5, bucketCounts: []int{1, 0, 0, 0},
+			},
+		},
+	}, data)
+
+	a.reset()
+	assert.False(t, a.snapshot().hasData())
+	assert.True(t, data.hasData())
+}
+
+func TestMetricAggregatorScalesSampledValues(t *testing.T) {
+	a := newMetricAggregator([]string{"latency"}, []float64{10})
+	user := ldcontext.New("u")
+	a.aggregate(makeMetricEvent("latency", user, 1000, 5), 1)
+	a.aggregate(makeMetricEvent("latency", user, 2000, 20), 10)
+	a.aggregate(makeMetricEvent("latency", user, 3000, 2), 0) // treated as 1
+
+	assert.Equal(t, &metricAggregate{count: 12, sum: 207, min: 2, max: 20, bucketCounts: []int{2, 10}},
+		a.snapshot().metrics[metricKey{"latency", ldcontext.DefaultKind}])
+}
+
+func TestMetricSummaryExport(t *testing.T) {
+	a := newMetricAggregator([]string{"latency", "cart"}, []float64{10})
+	user, org := ldcontext.New("u"), ldcontext.NewWithKind("org", "o")
+	a.aggregate(makeMetricEvent("latency", ldcontext.NewMulti(user, org), 2000, 5), 1)
+	a.aggregate(makeMetricEvent("cart", user, 1000, 50), 1)
+
+	assert.Equal(t, MetricSummary{
+		StartDate:       1000,
+		EndDate:         2000,
+		HistogramBounds: []float64{10},
+		Metrics: []MetricAggregate{
+			{Key: "cart", ContextKind: ldcontext.DefaultKind, Count: 1, Sum: 50, Min: 50, Max: 50, Histogram: []int{0, 1}},
+			{Key: "latency", ContextKind: "org", Count: 1, Sum: 5, Min: 5, Max: 5, Histogram: []int{1, 0}},
+			{Key: "latency", ContextKind: ldcontext.DefaultKind, Count: 1, Sum: 5, Min: 5, Max: 5, Histogram: []int{1, 0}},
+		},
+	}, a.snapshot().export())
+}
@@ -0,0 +1,478 @@
+package ldevents
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldmigration"
+)
+
+// DefaultMigrationOpWindow is the default value for MigrationOpAggregatorConfig.Window.
+const DefaultMigrationOpWindow = 5 * time.Minute
+
+// Each window is divided into this many slots, so that old data expires in steps of a tenth of the
+// window rather than all at once.
+const migrationOpWindowSlots = 10
+
+// The largest latency that has its own histogram bucket; see makeMigrationOpLatencyBounds.
+const migrationOpMaxLatencyBoundMillis = 10 * 60 * 1000
+
+// MigrationOpKey identifies a set of migration operations whose measurements are aggregated
+// together by MigrationOpAggregator.
+type MigrationOpKey struct {
+	FlagKey string
+	Op      ldmigration.Operation
+	Origin  ldmigration.Origin
+}
+
+// MigrationOpStats contains the measurements for one MigrationOpKey within the current window of a
+// MigrationOpAggregator.
+type MigrationOpStats struct {
+	// Invocations is the number of operations that invoked this origin.
+	Invocations int
+	// Errors is the number of those operations in which this origin reported an error.
+	Errors int
+	// LatencySamples is the number of operations that measured the latency of this origin.
+	LatencySamples int
+	// LatencyP50, LatencyP90, and LatencyP99 are percentiles of those latencies. They are
+	// approximate: the actual percentile may be up to 20% lower.
+	LatencyP50 time.Duration
+	LatencyP90 time.Duration
+	LatencyP99 time.Duration
+	// ConsistencyChecks is the number of operations that invoked this origin and compared the
+	// results of the old and new origins. Since both origins take part in a check, the consistency
+	// measurements for the old and new origins of the same flag and operation are the same.
+	ConsistencyChecks int
+	// Inconsistencies is the number of those checks that found the results were different.
+	Inconsistencies int
+}
+
+// ErrorRate returns the fraction of invocations that reported an error, or zero if there were no
+// invocations.
+func (s MigrationOpStats) ErrorRate() float64 {
+	if s.Invocations == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Invocations)
+}
+
+// ConsistencyRatio returns the fraction of consistency checks that found the results were the
+// same, or 1 if there were no checks.
+func (s MigrationOpStats) ConsistencyRatio() float64 {
+	if s.ConsistencyChecks == 0 {
+		return 1
+	}
+	return float64(s.ConsistencyChecks-s.Inconsistencies) / float64(s.ConsistencyChecks)
+}
+
+// MigrationOpMetric identifies a value computed from MigrationOpStats, for use in a
+// MigrationOpThreshold.
+type MigrationOpMetric string
+
+const (
+	// MigrationOpErrorRate is the value of MigrationOpStats.ErrorRate.
+	MigrationOpErrorRate MigrationOpMetric = "errorRate"
+	// MigrationOpConsistencyRatio is the value of MigrationOpStats.ConsistencyRatio.
+	MigrationOpConsistencyRatio MigrationOpMetric = "consistencyRatio"
+	// MigrationOpLatencyP50 is the value of MigrationOpStats.LatencyP50, in milliseconds.
+	MigrationOpLatencyP50 MigrationOpMetric = "latencyP50"
+	// MigrationOpLatencyP90 is the value of MigrationOpStats.LatencyP90, in milliseconds.
+	MigrationOpLatencyP90 MigrationOpMetric = "latencyP90"
+	// MigrationOpLatencyP99 is the value of MigrationOpStats.LatencyP99, in milliseconds.
+	MigrationOpLatencyP99 MigrationOpMetric = "latencyP99"
+)
+
+// MigrationOpThreshold describes a condition that causes MigrationOpAggregator to raise an alert.
+//
+// For instance, to be alerted if more than 1% of operations on the new origin fail:
+//
+//	MigrationOpThreshold{Origin: ldmigration.New, Metric: MigrationOpErrorRate, Limit: 0.01}
+//
+// or if fewer than 99.9% of consistency checks for the flag "my-migration" succeed:
+//
+//	MigrationOpThreshold{FlagKey: "my-migration", Metric: MigrationOpConsistencyRatio, Limit: 0.999,
+//		Below: true}
+type MigrationOpThreshold struct {
+	// FlagKey, Op, and Origin restrict the threshold to matching keys. An empty value matches
+	// anything.
+	FlagKey string
+	Op      ldmigration.Operation
+	Origin  ldmigration.Origin
+	// Metric is the value to check.
+	Metric MigrationOpMetric
+	// The threshold is crossed if the metric is greater than Limit, or, if Below is true, less than
+	// Limit.
+	Limit float64
+	Below bool
+	// MinSamples is the number of measurements of the metric that the window must have before the
+	// threshold is checked, so that a single failure does not count as a 100% error rate. Values less
+	// than 1 are treated as 1.
+	MinSamples int
+}
+
+// MigrationOpAlert describes a threshold that was crossed. See MigrationOpAggregatorConfig.OnAlert.
+type MigrationOpAlert struct {
+	// Threshold is the threshold that was crossed.
+	Threshold MigrationOpThreshold
+	// Key identifies the operations whose measurements crossed it.
+	Key MigrationOpKey
+	// Value is the value of the threshold's metric.
+	Value float64
+	// Stats are all of the measurements for the key.
+	Stats MigrationOpStats
+}
+
+// MigrationOpAggregatorConfig contains options for NewMigrationOpAggregator.
+type MigrationOpAggregatorConfig struct {
+	// The length of the sliding window over which measurements are aggregated. If zero,
+	// DefaultMigrationOpWindow is used.
+	Window time.Duration
+	// Conditions that cause OnAlert to be called.
+	Thresholds []MigrationOpThreshold
+	// OnAlert is called when one of the Thresholds is crossed for a key. It is not called again for
+	// that threshold and key until the metric has first gone back within the threshold.
+	//
+	// Thresholds are checked whenever an operation is recorded, but OnAlert is called from a separate
+	// goroutine, so that a slow callback cannot hold up Record or the event processor that calls it.
+	// Alerts are delivered one at a time, in the order they were raised.
+	OnAlert func(MigrationOpAlert)
+	// The source of the current time. If nil, SystemClock is used.
+	Clock Clock
+}
+
+// MigrationOpAggregator aggregates the measurements of migration operations by flag key, operation,
+// and origin, over a sliding window. It can check the aggregated measurements against thresholds,
+// so that an application can react to a problem with a migration, for instance by halting it,
+// without waiting for the data to reach LaunchDarkly.
+//
+// To aggregate the operations that are passed to an event processor, set
+// EventsConfiguration.MigrationOpAggregator. The aggregator sees every operation, regardless of
+// the sampling ratio of the events. It is safe for concurrent use, and uses a fixed amount of
+// memory for each key; a key that has had no operations for a whole window is discarded.
+type MigrationOpAggregator struct {
+	config        MigrationOpAggregatorConfig
+	clock         Clock
+	slotMillis    int64
+	latencyBounds []int
+	series        map[MigrationOpKey]*migrationOpSeries
+	crossed       map[migrationOpCrossedKey]struct{}
+	evictedIndex  int64 // the slot index at which expired series were last discarded
+	lock          sync.Mutex
+	// pendingAlerts are waiting to be passed to OnAlert by the goroutine that delivers them, which
+	// only runs while there are any. alertsGroup counts that goroutine, so that tests can wait for it.
+	pendingAlerts []MigrationOpAlert
+	delivering    bool
+	alertsGroup   sync.WaitGroup
+}
+
+type migrationOpCrossedKey struct {
+	threshold int // index into config.Thresholds
+	key       MigrationOpKey
+}
+
+type migrationOpSeries struct {
+	slots [migrationOpWindowSlots]migrationOpSlot
+}
+
+// migrationOpSlot holds the measurements for one part of the window. The index is the number of
+// slot lengths since the epoch, so a slot whose index is too old is treated as empty.
+type migrationOpSlot struct {
+	index             int64
+	invocations       int
+	errors            int
+	consistencyChecks int
+	inconsistencies   int
+	latencyCounts     []int // see makeMigrationOpLatencyBounds; nil if there are no latency measurements
+	latencyMaxMillis  int
+}
+
+// NewMigrationOpAggregator creates a MigrationOpAggregator.
+func NewMigrationOpAggregator(config MigrationOpAggregatorConfig) *MigrationOpAggregator {
+	window := config.Window
+	if window <= 0 {
+		window = DefaultMigrationOpWindow
+	}
+	slotMillis := window.Milliseconds() / migrationOpWindowSlots
+	if slotMillis < 1 {
+		slotMillis = 1
+	}
+	return &MigrationOpAggregator{
+		config:        config,
+		clock:         clockOrDefault(config.Clock),
+		slotMillis:    slotMillis,
+		latencyBounds: makeMigrationOpLatencyBounds(),
+		series:        make(map[MigrationOpKey]*migrationOpSeries),
+		crossed:       make(map[migrationOpCrossedKey]struct{}),
+	}
+}
+
+// Record adds the measurements from a migration operation, and checks whether that causes any
+// thresholds to be crossed. An origin counts as invoked if it has any measurements.
+func (a *MigrationOpAggregator) Record(evt MigrationOpEventData) {
+	origins := make(map[ldmigration.Origin]struct{}, 2)
+	for _, originSet := range []map[ldmigration.Origin]struct{}{evt.Invoked, evt.Error} {
+		for origin := range originSet {
+			origins[origin] = struct{}{}
+		}
+	}
+	for origin := range evt.Latency {
+		origins[origin] = struct{}{}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	nowIndex := a.nowIndex()
+	if nowIndex != a.evictedIndex {
+		a.evictExpired(nowIndex)
+	}
+	var alerts []MigrationOpAlert
+	for origin := range origins {
+		key := MigrationOpKey{FlagKey: evt.FlagKey, Op: evt.Op, Origin: origin}
+		series := a.series[key]
+		if series == nil {
+			series = &migrationOpSeries{}
+			a.series[key] = series
+		}
+		slot := series.slotAt(nowIndex)
+		slot.invocations++
+		if _, ok := evt.Error[origin]; ok {
+			slot.errors++
+		}
+		if latency, ok := evt.Latency[origin]; ok {
+			slot.addLatency(latency, a.latencyBounds)
+		}
+		if evt.ConsistencyCheck != nil {
+			slot.consistencyChecks++
+			if !evt.ConsistencyCheck.Consistent() {
+				slot.inconsistencies++
+			}
+		}
+		alerts = append(alerts, a.checkThresholds(key, series.stats(nowIndex, a.latencyBounds))...)
+	}
+
+	if len(alerts) != 0 && a.config.OnAlert != nil {
+		a.pendingAlerts = append(a.pendingAlerts, alerts...)
+		if !a.delivering {
+			a.delivering = true
+			a.alertsGroup.Add(1)
+			go a.deliverAlerts()
+		}
+	}
+}
+
+// deliverAlerts passes pending alerts to OnAlert until there are none left.
+func (a *MigrationOpAggregator) deliverAlerts() {
+	defer a.alertsGroup.Done()
+	for {
+		a.lock.Lock()
+		alerts := a.pendingAlerts
+		a.pendingAlerts = nil
+		if len(alerts) == 0 {
+			a.delivering = false
+			a.lock.Unlock()
+			return
+		}
+		a.lock.Unlock()
+		for _, alert := range alerts {
+			a.config.OnAlert(alert)
+		}
+	}
+}
+
+// evictExpired discards the series that have no measurements within the window ending at nowIndex,
+// and the record of which thresholds they had crossed. It must be called with the lock held.
+func (a *MigrationOpAggregator) evictExpired(nowIndex int64) {
+	for key, series := range a.series {
+		if series.latestIndex() <= nowIndex-migrationOpWindowSlots {
+			delete(a.series, key)
+		}
+	}
+	for crossedKey := range a.crossed {
+		if _, ok := a.series[crossedKey.key]; !ok {
+			delete(a.crossed, crossedKey)
+		}
+	}
+	a.evictedIndex = nowIndex
+}
+
+// Stats returns the measurements for a key within the current window, or false if there are none.
+func (a *MigrationOpAggregator) Stats(key MigrationOpKey) (MigrationOpStats, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if series := a.series[key]; series != nil {
+		if stats := series.stats(a.nowIndex(), a.latencyBounds); stats.Invocations > 0 {
+			return stats, true
+		}
+	}
+	return MigrationOpStats{}, false
+}
+
+// AllStats returns the measurements for every key that has any within the current window.
+func (a *MigrationOpAggregator) AllStats() map[MigrationOpKey]MigrationOpStats {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	nowIndex := a.nowIndex()
+	ret := make(map[MigrationOpKey]MigrationOpStats)
+	for key, series := range a.series {
+		if stats := series.stats(nowIndex, a.latencyBounds); stats.Invocations > 0 {
+			ret[key] = stats
+		}
+	}
+	return ret
+}
+
+func (a *MigrationOpAggregator) nowIndex() int64 {
+	return int64(unixMillisNow(a.clock)) / a.slotMillis
+}
+
+// checkThresholds must be called with the lock held.
+func (a *MigrationOpAggregator) checkThresholds(key MigrationOpKey, stats MigrationOpStats) []MigrationOpAlert {
+	var alerts []MigrationOpAlert
+	for i, threshold := range a.config.Thresholds {
+		if !threshold.matches(key) {
+			continue
+		}
+		crossedKey := migrationOpCrossedKey{threshold: i, key: key}
+		value, samples := threshold.Metric.valueOf(stats)
+		minSamples := threshold.MinSamples
+		if minSamples < 1 {
+			minSamples = 1
+		}
+		isCrossed := samples >= minSamples &&
+			((threshold.Below && value < threshold.Limit) || (!threshold.Below && value > threshold.Limit))
+		_, wasCrossed := a.crossed[crossedKey]
+		switch {
+		case isCrossed && !wasCrossed:
+			a.crossed[crossedKey] = struct{}{}
+			alerts = append(alerts, MigrationOpAlert{Threshold: threshold, Key: key, Value: value, Stats: stats})
+		case !isCrossed && wasCrossed:
+			delete(a.crossed, crossedKey)
+		}
+	}
+	return alerts
+}
+
+func (t MigrationOpThreshold) matches(key MigrationOpKey) bool {
+	return (t.FlagKey == "" || t.FlagKey == key.FlagKey) &&
+		(t.Op == "" || t.Op == key.Op) &&
+		(t.Origin == "" || t.Origin == key.Origin)
+}
+
+// valueOf returns the value of the metric, and the number of measurements it is based on.
+func (m MigrationOpMetric) valueOf(stats MigrationOpStats) (float64, int) {
+	switch m {
+	case MigrationOpErrorRate:
+		return stats.ErrorRate(), stats.Invocations
+	case MigrationOpConsistencyRatio:
+		return stats.ConsistencyRatio(), stats.ConsistencyChecks
+	case MigrationOpLatencyP50:
+		return float64(stats.LatencyP50.Milliseconds()), stats.LatencySamples
+	case MigrationOpLatencyP90:
+		return float64(stats.LatencyP90.Milliseconds()), stats.LatencySamples
+	case MigrationOpLatencyP99:
+		return float64(stats.LatencyP99.Milliseconds()), stats.LatencySamples
+	default:
+		return 0, 0
+	}
+}
+
+func (s *migrationOpSeries) slotAt(index int64) *migrationOpSlot {
+	slot := &s.slots[index%migrationOpWindowSlots]
+	if slot.index != index {
+		*slot = migrationOpSlot{index: index}
+	}
+	return slot
+}
+
+func (s *migrationOpSeries) latestIndex() int64 {
+	latest := s.slots[0].index
+	for i := 1; i < len(s.slots); i++ {
+		if s.slots[i].index > latest {
+			latest = s.slots[i].index
+		}
+	}
+	return latest
+}
+
+func (s *migrationOpSeries) stats(nowIndex int64, latencyBounds []int) MigrationOpStats {
+	var stats MigrationOpStats
+	var latencyCounts []int
+	latencyMaxMillis := 0
+	for i := range s.slots {
+		slot := &s.slots[i]
+		if slot.index > nowIndex || slot.index <= nowIndex-migrationOpWindowSlots {
+			continue
+		}
+		stats.Invocations += slot.invocations
+		stats.Errors += slot.errors
+		stats.ConsistencyChecks += slot.consistencyChecks
+		stats.Inconsistencies += slot.inconsistencies
+		if slot.latencyCounts != nil {
+			if latencyCounts == nil {
+				latencyCounts = make([]int, len(slot.latencyCounts))
+			}
+			for j, count := range slot.latencyCounts {
+				latencyCounts[j] += count
+				stats.LatencySamples += count
+			}
+			if slot.latencyMaxMillis > latencyMaxMillis {
+				latencyMaxMillis = slot.latencyMaxMillis
+			}
+		}
+	}
+	if stats.LatencySamples > 0 {
+		percentile := func(p float64) time.Duration {
+			return latencyPercentile(latencyCounts, latencyBounds, stats.LatencySamples, latencyMaxMillis, p)
+		}
+		stats.LatencyP50, stats.LatencyP90, stats.LatencyP99 = percentile(0.5), percentile(0.9), percentile(0.99)
+	}
+	return stats
+}
+
+func (s *migrationOpSlot) addLatency(millis int, bounds []int) {
+	if s.latencyCounts == nil {
+		s.latencyCounts = make([]int, len(bounds)+1)
+	}
+	i := 0
+	for i < len(bounds) && millis > bounds[i] {
+		i++
+	}
+	s.latencyCounts[i]++
+	if millis > s.latencyMaxMillis {
+		s.latencyMaxMillis = millis
+	}
+}
+
+// latencyPercentile returns the upper bound of the histogram bucket that contains the given
+// percentile, or the largest latency if that is lower.
+func latencyPercentile(counts, bounds []int, total, maxMillis int, percentile float64) time.Duration {
+	rank := int(math.Ceil(percentile * float64(total)))
+	cumulative := 0
+	for i, count := range counts {
+		cumulative += count
+		if cumulative >= rank {
+			if i < len(bounds) && bounds[i] < maxMillis {
+				return time.Duration(bounds[i]) * time.Millisecond
+			}
+			break
+		}
+	}
+	return time.Duration(maxMillis) * time.Millisecond
+}
+
+// makeMigrationOpLatencyBounds returns the upper bounds, in milliseconds, of the buckets of the
+// latency histogram. Each is 20% greater than the last, up to migrationOpMaxLatencyBoundMillis, so
+// there are about 75 of them.
+func makeMigrationOpLatencyBounds() []int {
+	var bounds []int
+	for bound := 1; bound <= migrationOpMaxLatencyBoundMillis; {
+		bounds = append(bounds, bound)
+		next := int(math.Ceil(float64(bound) * 1.2))
+		if next == bound {
+			next++
+		}
+		bound = next
+	}
+	return bounds
+}
@@ -0,0 +1,264 @@
+package ldevents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldmigration"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type migrationOpBuilder struct {
+	evt MigrationOpEventData
+}
+
+func newMigrationOp(flagKey string, op ldmigration.Operation) *migrationOpBuilder {
+	return &migrationOpBuilder{evt: MigrationOpEventData{
+		BaseEvent: BaseEvent{CreationDate: fakeTime, Context: basicContext()},
+		FlagKey:   flagKey,
+		Op:        op,
+		Invoked:   map[ldmigration.Origin]struct{}{},
+		Error:     map[ldmigration.Origin]struct{}{},
+		Latency:   map[ldmigration.Origin]int{},
+	}}
+}
+
+func (b *migrationOpBuilder) invoked(origin ldmigration.Origin, latencyMillis int) *migrationOpBuilder {
+	b.evt.Invoked[origin] = struct{}{}
+	if latencyMillis >= 0 {
+		b.evt.Latency[origin] = latencyMillis
+	}
+	return b
+}
+
+func (b *migrationOpBuilder) failed(origin ldmigration.Origin) *migrationOpBuilder {
+	b.evt.Error[origin] = struct{}{}
+	return b
+}
+
+func (b *migrationOpBuilder) consistent(consistent bool) *migrationOpBuilder {
+	b.evt.ConsistencyCheck = ldmigration.NewConsistencyCheck(consistent, 1)
+	return b
+}
+
+func TestMigrationOpLatencyBounds(t *testing.T) {
+	bounds := makeMigrationOpLatencyBounds()
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 8, 10, 12, 15}, bounds[:10])
+	for i := 1; i < len(bounds); i++ {
+		assert.Greater(t, bounds[i], bounds[i-1])
+		assert.LessOrEqual(t, float64(bounds[i]), float64(bounds[i-1])*1.2+1)
+	}
+	assert.LessOrEqual(t, bounds[len(bounds)-1], migrationOpMaxLatencyBoundMillis)
+	assert.Less(t, len(bounds), 80)
+}
+
+func TestMigrationOpAggregatorStats(t *testing.T) {
+	a := NewMigrationOpAggregator(MigrationOpAggregatorConfig{Clock: newTestClock(fakeTime)})
+	for i := 1; i <= 100; i++ {
+		op := newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.Old, i).invoked(ldmigration.New, -1)
+		if i%10 == 0 {
+			op.failed(ldmigration.New)
+		}
+		if i%4 == 0 {
+			op.consistent(i%20 != 0)
+		}
+		a.Record(op.evt)
+	}
+	a.Record(newMigrationOp("flag", ldmigration.Write).invoked(ldmigration.Old, 2000).evt)
+	a.Record(newMigrationOp("flag", ldmigration.Write).failed(ldmigration.New).evt)
+
+	oldReads, ok := a.Stats(MigrationOpKey{"flag", ldmigration.Read, ldmigration.Old})
+	require.True(t, ok)
+	assert.Equal(t, MigrationOpStats{
+		Invocations:       100,
+		LatencySamples:    100,
+		LatencyP50:        58 * time.Millisecond,  // the upper bound of the bucket (48, 58]
+		LatencyP90:        100 * time.Millisecond, // the bucket (84, 101] is capped at the largest latency
+		LatencyP99:        100 * time.Millisecond,
+		ConsistencyChecks: 25,
+		Inconsistencies:   5,
+	}, oldReads)
+	assert.Equal(t, 0.8, oldReads.ConsistencyRatio())
+	assert.Equal(t, 0.0, oldReads.ErrorRate())
+
+	newReads, ok := a.Stats(MigrationOpKey{"flag", ldmigration.Read, ldmigration.New})
+	require.True(t, ok)
+	assert.Equal(t, MigrationOpStats{Invocations: 100, Errors: 10, ConsistencyChecks: 25, Inconsistencies: 5},
+		newReads)
+	assert.Equal(t, 0.1, newReads.ErrorRate())
+
+	_, ok = a.Stats(MigrationOpKey{"other-flag", ldmigration.Read, ldmigration.New})
+	assert.False(t, ok)
+
+	assert.Equal(t, map[MigrationOpKey]MigrationOpStats{
+		{"flag", ldmigration.Read, ldmigration.Old}: oldReads,
+		{"flag", ldmigration.Read, ldmigration.New}: newReads,
+		{"flag", ldmigration.Write, ldmigration.Old}: {
+			Invocations: 1, LatencySamples: 1,
+			LatencyP50: 2 * time.Second, LatencyP90: 2 * time.Second, LatencyP99: 2 * time.Second,
+		},
+		{"flag", ldmigration.Write, ldmigration.New}: {Invocations: 1, Errors: 1},
+	}, a.AllStats())
+}
+
+func TestMigrationOpAggregatorStatsWithoutMeasurements(t *testing.T) {
+	var stats MigrationOpStats
+	assert.Equal(t, 0.0, stats.ErrorRate())
+	assert.Equal(t, 1.0, stats.ConsistencyRatio())
+}
+
+func TestMigrationOpAggregatorWindowSlides(t *testing.T) {
+	clock := newTestClock(fakeTime)
+	a := NewMigrationOpAggregator(MigrationOpAggregatorConfig{Window: 10 * time.Second, Clock: clock})
+	key := MigrationOpKey{"flag", ldmigration.Read, ldmigration.Old}
+	record := func(latencyMillis int) {
+		a.Record(newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.Old, latencyMillis).evt)
+	}
+
+	record(1000)
+	clock.advance(5 * time.Second)
+	record(5)
+	record(5)
+	stats, _ := a.Stats(key)
+	assert.Equal(t, 3, stats.Invocations)
+	assert.Equal(t, time.Second, stats.LatencyP99)
+
+	clock.advance(5 * time.Second) // the first operation has now left the window
+	stats, _ = a.Stats(key)
+	assert.Equal(t, 2, stats.Invocations)
+	assert.Equal(t, 5*time.Millisecond, stats.LatencyP99)
+
+	clock.advance(5 * time.Second)
+	_, ok := a.Stats(key)
+	assert.False(t, ok)
+	assert.Len(t, a.AllStats(), 0)
+
+	record(7)
+	stats, _ = a.Stats(key)
+	assert.Equal(t, 1, stats.Invocations)
+}
+
+func TestMigrationOpAggregatorDiscardsExpiredKeys(t *testing.T) {
+	clock := newTestClock(fakeTime)
+	a := NewMigrationOpAggregator(MigrationOpAggregatorConfig{
+		Window:     10 * time.Second,
+		Clock:      clock,
+		Thresholds: []MigrationOpThreshold{{Metric: MigrationOpErrorRate, Limit: 0.5}},
+		OnAlert:    func(MigrationOpAlert) {},
+	})
+	for _, flagKey := range []string{"flag1", "flag2", "flag3"} {
+		a.Record(newMigrationOp(flagKey, ldmigration.Read).invoked(ldmigration.Old, -1).failed(ldmigration.Old).evt)
+	}
+	clock.advance(5 * time.Second)
+	a.Record(newMigrationOp("flag4", ldmigration.Read).invoked(ldmigration.Old, -1).evt)
+	assert.Len(t, a.series, 4)
+	assert.Len(t, a.crossed, 3)
+
+	clock.advance(5 * time.Second)
+	a.Record(newMigrationOp("flag4", ldmigration.Read).invoked(ldmigration.Old, -1).evt)
+	assert.Len(t, a.series, 1)
+	assert.Len(t, a.crossed, 0)
+	a.alertsGroup.Wait()
+}
+
+func TestMigrationOpAggregatorDoesNotWaitForOnAlert(t *testing.T) {
+	alertCh, releaseCh := make(chan MigrationOpAlert, 10), make(chan struct{})
+	a := NewMigrationOpAggregator(MigrationOpAggregatorConfig{
+		Thresholds: []MigrationOpThreshold{{Metric: MigrationOpErrorRate, Limit: 0.5}},
+		OnAlert: func(alert MigrationOpAlert) {
+			<-releaseCh
+			alertCh <- alert
+		},
+	})
+	for _, flagKey := range []string{"flag1", "flag2"} {
+		a.Record(newMigrationOp(flagKey, ldmigration.Read).invoked(ldmigration.Old, -1).failed(ldmigration.Old).evt)
+	}
+
+	close(releaseCh)
+	for _, flagKey := range []string{"flag1", "flag2"} {
+		select {
+		case alert := <-alertCh:
+			assert.Equal(t, flagKey, alert.Key.FlagKey)
+		case <-time.After(time.Second):
+			require.Fail(t, "timed out waiting for alert")
+		}
+	}
+}
+
+func TestMigrationOpAggregatorThresholds(t *testing.T) {
+	clock := newTestClock(fakeTime)
+	var alerts []MigrationOpAlert
+	errorRateThreshold := MigrationOpThreshold{Origin: ldmigration.New, Metric: MigrationOpErrorRate, Limit: 0.25,
+		MinSamples: 4}
+	consistencyThreshold := MigrationOpThreshold{FlagKey: "flag", Op: ldmigration.Read,
+		Metric: MigrationOpConsistencyRatio, Limit: 0.9, Below: true}
+	latencyThreshold := MigrationOpThreshold{Origin: ldmigration.Old, Metric: MigrationOpLatencyP50, Limit: 1000}
+	a := NewMigrationOpAggregator(MigrationOpAggregatorConfig{
+		Window:     10 * time.Second,
+		Clock:      clock,
+		Thresholds: []MigrationOpThreshold{errorRateThreshold, consistencyThreshold, latencyThreshold},
+		OnAlert:    func(alert MigrationOpAlert) { alerts = append(alerts, alert) },
+	})
+	newKey := MigrationOpKey{"flag", ldmigration.Read, ldmigration.New}
+	oldKey := MigrationOpKey{"flag", ldmigration.Read, ldmigration.Old}
+	received := func() []MigrationOpAlert {
+		a.alertsGroup.Wait()
+		return alerts
+	}
+
+	// Not enough samples yet for the error rate threshold
+	for i := 0; i < 3; i++ {
+		a.Record(newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.New, -1).failed(ldmigration.New).evt)
+	}
+	assert.Len(t, received(), 0)
+
+	a.Record(newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.New, -1).evt)
+	if assert.Len(t, received(), 1) {
+		assert.Equal(t, errorRateThreshold, alerts[0].Threshold)
+		assert.Equal(t, newKey, alerts[0].Key)
+		assert.Equal(t, 0.75, alerts[0].Value)
+		assert.Equal(t, 4, alerts[0].Stats.Invocations)
+	}
+
+	// The alert is not repeated while the threshold is still crossed
+	a.Record(newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.New, -1).evt)
+	assert.Len(t, received(), 1)
+
+	// After the failures leave the window, it is rearmed
+	clock.advance(10 * time.Second)
+	for i := 0; i < 4; i++ {
+		a.Record(newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.New, -1).evt)
+	}
+	assert.Len(t, received(), 1)
+	a.Record(newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.New, -1).failed(ldmigration.New).evt)
+	assert.Len(t, received(), 1) // 1 of 5 is not more than 25%
+	a.Record(newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.New, -1).failed(ldmigration.New).evt)
+	if assert.Len(t, received(), 2) {
+		assert.Equal(t, newKey, alerts[1].Key)
+		assert.Equal(t, 2.0/6, alerts[1].Value)
+	}
+
+	// A consistency check counts for both origins
+	a.Record(newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.Old, 5).invoked(ldmigration.New, -1).
+		consistent(false).evt)
+	if assert.Len(t, received(), 4) {
+		assert.ElementsMatch(t, []MigrationOpKey{oldKey, newKey}, []MigrationOpKey{alerts[2].Key, alerts[3].Key})
+		assert.Equal(t, consistencyThreshold, alerts[2].Threshold)
+		assert.Equal(t, 0.0, alerts[2].Value)
+	}
+
+	// Thresholds only apply to matching keys
+	a.Record(newMigrationOp("flag", ldmigration.Write).invoked(ldmigration.Old, 5).consistent(false).evt)
+	a.Record(newMigrationOp("other-flag", ldmigration.Read).invoked(ldmigration.Old, 5).consistent(false).evt)
+	assert.Len(t, received(), 4)
+
+	a.Record(newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.Old, 2000).evt)
+	a.Record(newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.Old, 2000).evt)
+	if assert.Len(t, received(), 5) {
+		assert.Equal(t, latencyThreshold, alerts[4].Threshold)
+		assert.Equal(t, oldKey, alerts[4].Key)
+		assert.Equal(t, 2000.0, alerts[4].Value)
+	}
+}
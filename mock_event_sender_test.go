@@ -71,6 +71,19 @@ func (ms *mockEventSender) getPayloadCount() int {
 	return ms.payloadCount
 }
 
+// takeEvents returns all events that the mock sender has received so far. Since SynchronousEventProcessor
+// calls the sender on the caller's goroutine, there is never any need to wait.
+func (ms *mockEventSender) takeEvents() []json.RawMessage {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ret := ms.events
+	ms.events = nil
+	for len(ms.eventsCh) > 0 {
+		<-ms.eventsCh
+	}
+	return ret
+}
+
 func (ms *mockEventSender) awaitEvent(t *testing.T) json.RawMessage {
 	event, ok := ms.tryAwaitEvent()
 	if !ok {
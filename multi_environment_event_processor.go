@@ -0,0 +1,578 @@
+package ldevents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldlog"
+)
+
+// DefaultMultiEnvironmentInboxCapacity is the default value for MultiEnvironmentEventsConfiguration.InboxCapacity.
+const DefaultMultiEnvironmentInboxCapacity = 10000
+
+// DefaultMultiEnvironmentFlushQueueCapacity is the default value for
+// MultiEnvironmentEventsConfiguration.FlushQueueCapacity.
+const DefaultMultiEnvironmentFlushQueueCapacity = 100
+
+// MultiEnvironmentEventsConfiguration contains options affecting the behavior of a
+// MultiEnvironmentEventProcessor as a whole. Options that can vary between environments are
+// specified separately for each environment in an EventsConfiguration.
+type MultiEnvironmentEventsConfiguration struct {
+	// EventSenderConfiguration is used to create the EventSender for any environment whose
+	// EventsConfiguration does not specify one. All such environments will share its HTTP client.
+	EventSenderConfiguration EventSenderConfiguration
+	// The time between flushes of every environment's event buffer. The FlushInterval in each
+	// environment's EventsConfiguration is ignored.
+	FlushInterval time.Duration
+	// The interval at which every environment's set of known context keys is reset. The
+	// UserKeysFlushInterval in each environment's EventsConfiguration is ignored.
+	UserKeysFlushInterval time.Duration
+	// The interval at which periodic diagnostic events will be sent for environments that have a
+	// DiagnosticsManager. The DiagnosticRecordingInterval in each environment's EventsConfiguration
+	// is ignored.
+	DiagnosticRecordingInterval time.Duration
+	// The source of the current time and of the timers for periodic tasks. If nil, SystemClock is
+	// used. The Clock in each environment's EventsConfiguration is ignored.
+	Clock Clock
+	// The number of flush workers shared by all environments, or 0 to use the same number as
+	// NewDefaultEventProcessor.
+	FlushWorkers int
+	// The number of flush payloads that can be waiting for a free worker. If this is exceeded, an
+	// environment's events are retained in its buffer until the next flush.
+	FlushQueueCapacity int
+	// The number of inputs (events and control messages, across all environments) that can be
+	// waiting to be processed. If this is exceeded, events will be discarded.
+	InboxCapacity int
+	// The destination for log output that is not specific to one environment.
+	Loggers ldlog.Loggers
+}
+
+// EnvironmentStats contains cumulative event counters for one environment of a
+// MultiEnvironmentEventProcessor.
+type EnvironmentStats struct {
+	// EventsRecorded is the number of events that were passed to the environment's EventProcessor.
+	EventsRecorded int
+	// EventsFlushed is the number of output events, including index and summary events, that have
+	// been handed to a flush worker for delivery.
+	EventsFlushed int
+	// EventsDropped is the number of events that were discarded because the buffer was full.
+	EventsDropped int
+	// DeduplicatedContexts is the number of times an index event was not generated because the
+	// context had been seen recently.
+	DeduplicatedContexts int
+	// Disabled is true if the events service has indicated that no more events should be sent for
+	// this environment, normally because the SDK key is invalid.
+	Disabled bool
+}
+
+// MultiEnvironmentEventProcessor manages analytics events for many environments within one
+// process, such as in the Relay Proxy.
+//
+// Each environment has its own event buffer, summary state, context deduplication cache, and
+// disabled state, exactly as if it had its own instance of NewDefaultEventProcessor. However,
+// all environments share a single event-processing goroutine, a single set of timers, and a
+// single pool of flush workers.
+type MultiEnvironmentEventProcessor interface {
+	// AddEnvironment starts processing events for a new environment, returning an EventProcessor
+	// that records events for that environment. It returns an error if an environment with the
+	// same identifier already exists.
+	//
+	// If config.EventSender is nil, the environment uses an EventSender created by
+	// NewServerSideEventSender from the shared EventSenderConfiguration, with the identifier as
+	// the SDK key.
+	AddEnvironment(id string, config EventsConfiguration) (EventProcessor, error)
+
+	// Environment returns the EventProcessor for an existing environment, or nil if there is none.
+	Environment(id string) EventProcessor
+
+	// RemoveEnvironment flushes any buffered events for an environment and then stops processing
+	// events for it. It blocks until the final flush has completed. It returns false if there
+	// was no such environment.
+	//
+	// Calling Close on an environment's EventProcessor has the same effect.
+	RemoveEnvironment(id string) bool
+
+	// EnvironmentStats returns the current event counters for an environment. The second return
+	// value is false if there was no such environment.
+	EnvironmentStats(id string) (EnvironmentStats, bool)
+
+	// Close shuts down all event processor activity, after first ensuring that all events for
+	// every environment have been delivered.
+	Close() error
+}
+
+type multiEnvironmentEventProcessor struct {
+	inboxCh       chan eventDispatcherMessage
+	closedCh      chan struct{}
+	inboxFullOnce sync.Once
+	closeOnce     sync.Once
+	loggers       ldlog.Loggers
+}
+
+// multiEnvironmentDispatcher is the counterpart of eventDispatcher for a
+// multiEnvironmentEventProcessor. All of its state, including the state of each environment's
+// eventDispatcher, is owned by the goroutine that runs runMainLoop.
+type multiEnvironmentDispatcher struct {
+	config       MultiEnvironmentEventsConfiguration
+	workers      *flushWorkerPool
+	environments map[string]*multiEnvironment
+	closedCh     chan<- struct{}
+}
+
+type multiEnvironment struct {
+	id         string
+	dispatcher *eventDispatcher
+	removed    bool
+	// idleNotifier is here, rather than in multiEnvironmentHandle, so that every handle for the
+	// same environment shares it.
+	idleNotifier idleNotifier
+	// stats accumulates counters that the dispatcher has since reset for diagnostic events; see
+	// currentStats.
+	stats EnvironmentStats
+}
+
+func (env *multiEnvironment) currentStats() EnvironmentStats {
+	stats := env.stats
+	stats.EventsFlushed = env.dispatcher.eventsFlushed
+	stats.EventsDropped += env.dispatcher.outbox.droppedEvents
+	stats.DeduplicatedContexts += env.dispatcher.deduplicatedContexts
+	stats.Disabled = env.dispatcher.disabled
+	return stats
+}
+
+// multiEnvironmentHandle is the EventProcessor returned for each environment.
+type multiEnvironmentHandle struct {
+	owner *multiEnvironmentEventProcessor
+	env   *multiEnvironment
+}
+
+type environmentMessage struct {
+	env     *multiEnvironment
+	message eventDispatcherMessage
+}
+
+type addEnvironmentMessage struct {
+	id      string
+	config  EventsConfiguration
+	replyCh chan *multiEnvironment
+}
+
+type getEnvironmentMessage struct {
+	id      string
+	replyCh chan *multiEnvironment
+}
+
+type removeEnvironmentMessage struct {
+	id      string
+	env     *multiEnvironment // if non-nil, only remove the environment if it is still this one
+	replyCh chan bool
+}
+
+type environmentStatsMessage struct {
+	id      string
+	replyCh chan *EnvironmentStats
+}
+
+// NewMultiEnvironmentEventProcessor creates an instance of MultiEnvironmentEventProcessor. It
+// initially has no environments.
+func NewMultiEnvironmentEventProcessor(config MultiEnvironmentEventsConfiguration) MultiEnvironmentEventProcessor {
+	if config.FlushWorkers <= 0 {
+		config.FlushWorkers = maxFlushWorkers
+	}
+	if config.FlushQueueCapacity <= 0 {
+		config.FlushQueueCapacity = DefaultMultiEnvironmentFlushQueueCapacity
+	}
+	if config.InboxCapacity <= 0 {
+		config.InboxCapacity = DefaultMultiEnvironmentInboxCapacity
+	}
+	inboxCh := make(chan eventDispatcherMessage, config.InboxCapacity)
+	closedCh := make(chan struct{})
+	md := &multiEnvironmentDispatcher{
+		config:       config,
+		workers:      newFlushWorkerPool(config.FlushWorkers, config.FlushQueueCapacity),
+		environments: make(map[string]*multiEnvironment),
+		closedCh:     closedCh,
+	}
+	go md.runMainLoop(inboxCh)
+	return &multiEnvironmentEventProcessor{
+		inboxCh:  inboxCh,
+		closedCh: closedCh,
+		loggers:  config.Loggers,
+	}
+}
+
+func (ep *multiEnvironmentEventProcessor) AddEnvironment(
+	id string,
+	config EventsConfiguration,
+) (EventProcessor, error) {
+	m := addEnvironmentMessage{id: id, config: config, replyCh: make(chan *multiEnvironment, 1)}
+	env, ok := ep.postMessageAndAwaitEnvironment(m, m.replyCh)
+	if !ok {
+		return nil, fmt.Errorf("event processor has been closed")
+	}
+	if env == nil {
+		return nil, fmt.Errorf("environment %q already exists", id)
+	}
+	return multiEnvironmentHandle{owner: ep, env: env}, nil
+}
+
+func (ep *multiEnvironmentEventProcessor) Environment(id string) EventProcessor {
+	m := getEnvironmentMessage{id: id, replyCh: make(chan *multiEnvironment, 1)}
+	if env, ok := ep.postMessageAndAwaitEnvironment(m, m.replyCh); ok && env != nil {
+		return multiEnvironmentHandle{owner: ep, env: env}
+	}
+	return nil
+}
+
+func (ep *multiEnvironmentEventProcessor) RemoveEnvironment(id string) bool {
+	return ep.removeEnvironment(removeEnvironmentMessage{id: id, replyCh: make(chan bool, 1)})
+}
+
+func (ep *multiEnvironmentEventProcessor) removeEnvironment(m removeEnvironmentMessage) bool {
+	if !ep.postBlockingMessageToInbox(m) {
+		return false
+	}
+	select {
+	case removed := <-m.replyCh:
+		return removed
+	case <-ep.closedCh:
+		return false
+	}
+}
+
+func (ep *multiEnvironmentEventProcessor) EnvironmentStats(id string) (EnvironmentStats, bool) {
+	m := environmentStatsMessage{id: id, replyCh: make(chan *EnvironmentStats, 1)}
+	if ep.postBlockingMessageToInbox(m) {
+		select {
+		case stats := <-m.replyCh:
+			if stats != nil {
+				return *stats, true
+			}
+		case <-ep.closedCh:
+		}
+	}
+	return EnvironmentStats{}, false
+}
+
+func (ep *multiEnvironmentEventProcessor) Close() error {
+	ep.closeOnce.Do(func() {
+		m := shutdownEventsMessage{replyCh: make(chan struct{})}
+		ep.inboxCh <- m
+		<-m.replyCh
+	})
+	return nil
+}
+
+func (ep *multiEnvironmentEventProcessor) postMessageAndAwaitEnvironment(
+	m eventDispatcherMessage,
+	replyCh <-chan *multiEnvironment,
+) (*multiEnvironment, bool) {
+	if !ep.postBlockingMessageToInbox(m) {
+		return nil, false
+	}
+	select {
+	case env := <-replyCh:
+		return env, true
+	case <-ep.closedCh:
+		return nil, false
+	}
+}
+
+// postBlockingMessageToInbox is used for control messages, which, unlike analytics events, should
+// not be dropped if the inbox is full. It returns false if the processor has been closed.
+func (ep *multiEnvironmentEventProcessor) postBlockingMessageToInbox(m eventDispatcherMessage) bool {
+	select {
+	case ep.inboxCh <- m:
+		return true
+	case <-ep.closedCh:
+		return false
+	}
+}
+
+func (ep *multiEnvironmentEventProcessor) postNonBlockingMessageToInbox(m eventDispatcherMessage) {
+	select {
+	case ep.inboxCh <- m:
+		return
+	default: // COVERAGE: no way to simulate this condition in unit tests
+	}
+	// See comments in defaultEventProcessor.postNonBlockingMessageToInbox.
+	ep.inboxFullOnce.Do(func() { // COVERAGE: no way to simulate this condition in unit tests
+		ep.loggers.Warn("Events are being produced faster than they can be processed; some events will be dropped")
+	})
+}
+
+func (h multiEnvironmentHandle) RecordEvaluation(ed EvaluationData) {
+	h.owner.postNonBlockingMessageToInbox(environmentMessage{env: h.env, message: sendEventMessage{event: ed}})
+}
+
+func (h multiEnvironmentHandle) RecordIdentifyEvent(e IdentifyEventData) {
+	h.owner.postNonBlockingMessageToInbox(environmentMessage{env: h.env, message: sendEventMessage{event: e}})
+}
+
+func (h multiEnvironmentHandle) RecordCustomEvent(e CustomEventData) {
+	h.owner.postNonBlockingMessageToInbox(environmentMessage{env: h.env, message: sendEventMessage{event: e}})
+}
+
+func (h multiEnvironmentHandle) RecordMigrationOpEvent(e MigrationOpEventData) {
+	h.owner.postNonBlockingMessageToInbox(environmentMessage{env: h.env, message: sendEventMessage{event: e}})
+}
+
+func (h multiEnvironmentHandle) RecordRawEvent(data json.RawMessage) {
+	h.owner.postNonBlockingMessageToInbox(
+		environmentMessage{env: h.env, message: sendEventMessage{event: rawEvent{data: data}}})
+}
+
+func (h multiEnvironmentHandle) Flush() {
+	h.owner.postNonBlockingMessageToInbox(environmentMessage{env: h.env, message: flushEventsMessage{}})
+}
+
+func (h multiEnvironmentHandle) FlushBlocking(timeout time.Duration) bool {
+	m := flushEventsMessage{replyCh: make(chan struct{}, 1)}
+	if !h.owner.postBlockingMessageToInbox(environmentMessage{env: h.env, message: m}) {
+		return true // there is nothing left to flush
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	select {
+	case <-m.replyCh:
+		return true
+	case <-h.owner.closedCh:
+		return true
+	case <-deadline:
+		return false
+	}
+}
+
+func (h multiEnvironmentHandle) WaitForIdle(ctx context.Context) error {
+	return waitForIdle(ctx, h.owner.closedCh, h.sync, h.busy)
+}
+
+func (h multiEnvironmentHandle) Idle() <-chan struct{} {
+	return h.env.idleNotifier.idle(h.WaitForIdle)
+}
+
+// busy returns true if, after a sync, there are events waiting to be processed for any environment,
+// a flush in progress for this environment, or a flush result waiting to be processed.
+func (h multiEnvironmentHandle) busy() bool {
+	ed := h.env.dispatcher
+	return len(h.owner.inboxCh) > 0 || ed.workersGroup.inProgress() || len(ed.workers.senderResultCh) > 0
+}
+
+// sync first waits for this environment's flushes to complete, without blocking the main loop, and
+// then has the main loop process the results of those flushes, which by then are waiting in the
+// shared result channel.
+func (h multiEnvironmentHandle) sync(ctx context.Context) error {
+	flushed := syncEventsMessage{replyCh: make(chan struct{}, 1)}
+	err := postAndAwaitSync(ctx, h.owner.inboxCh, h.owner.closedCh,
+		environmentMessage{env: h.env, message: flushed}, flushed.replyCh)
+	if err != nil {
+		return err
+	}
+	resultsProcessed := syncEventsMessage{replyCh: make(chan struct{}, 1)}
+	return postAndAwaitSync(ctx, h.owner.inboxCh, h.owner.closedCh, resultsProcessed, resultsProcessed.replyCh)
+}
+
+func (h multiEnvironmentHandle) ClockSkew() (time.Duration, bool) {
+	return h.env.dispatcher.clockSkew.estimate()
+}
+
+func (h multiEnvironmentHandle) ContextLimitStats() ContextLimitStats {
+	return h.env.dispatcher.formatter.contextFormatter.limitStats.get()
+}
+
+func (h multiEnvironmentHandle) SummaryOverflowEvaluations() int {
+	return h.env.dispatcher.outbox.summarizer.overflowStats.get()
+}
+
+func (h multiEnvironmentHandle) Close() error {
+	h.owner.removeEnvironment(removeEnvironmentMessage{id: h.env.id, env: h.env, replyCh: make(chan bool, 1)})
+	return nil
+}
+
+func (md *multiEnvironmentDispatcher) runMainLoop(inboxCh <-chan eventDispatcherMessage) {
+	if err := recover(); err != nil { // COVERAGE: no way to simulate this condition in unit tests
+		md.config.Loggers.Errorf("Unexpected panic in event processing thread: %+v", err)
+	}
+
+	flushInterval := md.config.FlushInterval
+	if flushInterval <= 0 { // COVERAGE: no way to test this logic in unit tests
+		flushInterval = DefaultFlushInterval
+	}
+	userKeysFlushInterval := md.config.UserKeysFlushInterval
+	if userKeysFlushInterval <= 0 { // COVERAGE: no way to test this logic in unit tests
+		userKeysFlushInterval = DefaultUserKeysFlushInterval
+	}
+	diagnosticsInterval := md.config.DiagnosticRecordingInterval
+	if diagnosticsInterval < MinimumDiagnosticRecordingInterval { // COVERAGE: no way to test this logic in unit tests
+		diagnosticsInterval = DefaultDiagnosticRecordingInterval
+	}
+	clock := clockOrDefault(md.config.Clock)
+	flushTicker := clock.NewTicker(flushInterval)
+	usersResetTicker := clock.NewTicker(userKeysFlushInterval)
+	diagnosticsTicker := clock.NewTicker(diagnosticsInterval)
+
+	for {
+		select {
+		case message := <-inboxCh:
+			switch m := message.(type) {
+			case environmentMessage:
+				md.handleEnvironmentMessage(m.env, m.message)
+			case addEnvironmentMessage:
+				m.replyCh <- md.addEnvironment(m.id, m.config)
+			case getEnvironmentMessage:
+				m.replyCh <- md.environments[m.id]
+			case removeEnvironmentMessage:
+				md.removeEnvironment(m)
+			case syncEventsMessage:
+				md.workers.drainResults()
+				m.replyCh <- struct{}{}
+			case environmentStatsMessage:
+				if env := md.environments[m.id]; env != nil {
+					stats := env.currentStats()
+					m.replyCh <- &stats
+				} else {
+					m.replyCh <- nil
+				}
+			case shutdownEventsMessage:
+				flushTicker.Stop()
+				usersResetTicker.Stop()
+				diagnosticsTicker.Stop()
+				md.shutdown()
+				m.replyCh <- struct{}{}
+				return
+			}
+		case r := <-md.workers.senderResultCh:
+			r.dispatcher.handleSenderResult(r.result)
+		case <-flushTicker.C():
+			for _, env := range md.environments {
+				env.dispatcher.triggerFlush()
+			}
+		case <-usersResetTicker.C():
+			for _, env := range md.environments {
+				env.dispatcher.userKeys.clear()
+			}
+		case <-diagnosticsTicker.C():
+			for _, env := range md.environments {
+				droppedEvents, deduplicatedContexts := env.dispatcher.sendStatsEvent()
+				env.stats.EventsDropped += droppedEvents
+				env.stats.DeduplicatedContexts += deduplicatedContexts
+			}
+		}
+	}
+}
+
+func (md *multiEnvironmentDispatcher) handleEnvironmentMessage(env *multiEnvironment, message eventDispatcherMessage) {
+	switch m := message.(type) {
+	case sendEventMessage:
+		if env.removed {
+			return
+		}
+		env.stats.EventsRecorded++
+		env.dispatcher.processEvent(m.event)
+	case flushEventsMessage:
+		if !env.removed {
+			env.dispatcher.triggerFlush()
+		}
+		if m.replyCh != nil {
+			// Unlike eventDispatcher, we don't block the main loop while waiting, since that would
+			// hold up every other environment.
+			replyWhenFlushed(env.dispatcher, m.replyCh)
+		}
+	case syncEventsMessage:
+		replyWhenFlushed(env.dispatcher, m.replyCh)
+	}
+}
+
+// replyWhenFlushed waits on a separate goroutine for all of the dispatcher's in-progress flushes
+// to complete, and then sends a reply.
+func replyWhenFlushed(ed *eventDispatcher, replyCh chan<- struct{}) {
+	go func() {
+		ed.workersGroup.Wait()
+		replyCh <- struct{}{}
+	}()
+}
+
+func (md *multiEnvironmentDispatcher) addEnvironment(id string, config EventsConfiguration) *multiEnvironment {
+	if _, exists := md.environments[id]; exists {
+		return nil
+	}
+	if config.EventSender == nil {
+		config.EventSender = NewServerSideEventSender(md.config.EventSenderConfiguration, id)
+	}
+	config.Clock = md.config.Clock
+	env := &multiEnvironment{
+		id:         id,
+		dispatcher: newEventDispatcher(config, md.workers),
+	}
+	md.environments[id] = env
+	return env
+}
+
+func (md *multiEnvironmentDispatcher) removeEnvironment(m removeEnvironmentMessage) {
+	env := md.environments[m.id]
+	if env == nil || (m.env != nil && m.env != env) {
+		m.replyCh <- false
+		return
+	}
+	md.finalFlush(env.dispatcher)
+	env.removed = true
+	delete(md.environments, m.id)
+	go func() {
+		env.dispatcher.workersGroup.Wait()
+		m.replyCh <- true
+	}()
+}
+
+// finalFlush is like triggerFlush, except that if no flush worker is available, it waits for one
+// instead of keeping the events in the buffer, since an environment that is being removed will not
+// get another chance to flush them. It keeps handling flush results while it waits, so that no
+// worker can be blocked on reporting one.
+func (md *multiEnvironmentDispatcher) finalFlush(ed *eventDispatcher) {
+	payload := ed.preparePayload()
+	if payload == nil {
+		return
+	}
+	ed.workersGroup.Add(1) // Increment the count of active flushes
+	for {
+		select {
+		case md.workers.flushCh <- payload:
+			ed.payloadQueued(payload)
+			return
+		case r := <-md.workers.senderResultCh:
+			r.dispatcher.handleSenderResult(r.result)
+		}
+	}
+}
+
+func (md *multiEnvironmentDispatcher) shutdown() {
+	dispatchers := make([]*eventDispatcher, 0, len(md.environments))
+	for _, env := range md.environments {
+		md.finalFlush(env.dispatcher)
+		dispatchers = append(dispatchers, env.dispatcher)
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		for _, ed := range dispatchers {
+			ed.workersGroup.Wait() // Wait for all in-progress flushes to complete
+		}
+		close(doneCh)
+	}()
+	// Keep consuming results while we wait, so that no worker can be blocked on reporting one.
+	for waiting := true; waiting; {
+		select {
+		case r := <-md.workers.senderResultCh:
+			r.dispatcher.handleSenderResult(r.result)
+		case <-doneCh:
+			waiting = false
+		}
+	}
+	md.workers.close()
+	close(md.closedCh)
+}
@@ -0,0 +1,339 @@
+package ldevents
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	m "github.com/launchdarkly/go-test-helpers/v3/matchers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func basicMultiEnvironmentConfig() MultiEnvironmentEventsConfiguration {
+	return MultiEnvironmentEventsConfiguration{
+		FlushInterval:         1 * time.Hour,
+		UserKeysFlushInterval: 1 * time.Hour,
+	}
+}
+
+func addEnvironmentWithSender(
+	t *testing.T,
+	mep MultiEnvironmentEventProcessor,
+	id string,
+	config EventsConfiguration,
+) (EventProcessor, *mockEventSender) {
+	sender := newMockEventSender()
+	config.EventSender = sender
+	ep, err := mep.AddEnvironment(id, config)
+	require.NoError(t, err)
+	require.NotNil(t, ep)
+	return ep, sender
+}
+
+func TestMultiEnvironmentEventsAreDeliveredToEachEnvironmentsSender(t *testing.T) {
+	mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+	defer mep.Close()
+
+	ep1, es1 := addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+	ep2, es2 := addEnvironmentWithSender(t, mep, "env2", basicConfigWithoutPrivateAttrs())
+
+	ep1.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(Context(ldcontext.New("a")), ldvalue.OptionalInt{}))
+	ep2.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(Context(ldcontext.New("b")), ldvalue.OptionalInt{}))
+	assert.True(t, ep1.FlushBlocking(time.Second))
+	assert.True(t, ep2.FlushBlocking(time.Second))
+
+	assertEventsReceived(t, es1, identifyEventForContextKey("a"))
+	es1.assertNoMoreEvents(t)
+	assertEventsReceived(t, es2, identifyEventForContextKey("b"))
+	es2.assertNoMoreEvents(t)
+}
+
+func TestMultiEnvironmentContextDeduplicationIsPerEnvironment(t *testing.T) {
+	mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+	defer mep.Close()
+
+	ep1, es1 := addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+	ep2, es2 := addEnvironmentWithSender(t, mep, "env2", basicConfigWithoutPrivateAttrs())
+
+	context := basicContext()
+	for _, ep := range []EventProcessor{ep1, ep1, ep2} {
+		ep.RecordCustomEvent(defaultEventFactory.NewCustomEventData("key", context, ldvalue.Null(), false, 0,
+			ldvalue.OptionalInt{}))
+	}
+	ep1.Flush()
+	ep2.Flush()
+
+	assertEventsReceived(t, es1, anyIndexEvent(), anyCustomEvent(), anyCustomEvent())
+	es1.assertNoMoreEvents(t)
+	assertEventsReceived(t, es2, anyIndexEvent(), anyCustomEvent())
+	es2.assertNoMoreEvents(t)
+
+	stats1, ok := mep.EnvironmentStats("env1")
+	require.True(t, ok)
+	assert.Equal(t, EnvironmentStats{EventsRecorded: 2, EventsFlushed: 3, DeduplicatedContexts: 1}, stats1)
+	stats2, ok := mep.EnvironmentStats("env2")
+	require.True(t, ok)
+	assert.Equal(t, EnvironmentStats{EventsRecorded: 1, EventsFlushed: 2}, stats2)
+}
+
+func TestMultiEnvironmentSummariesArePerEnvironment(t *testing.T) {
+	mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+	defer mep.Close()
+
+	ep1, es1 := addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+	ep2, es2 := addEnvironmentWithSender(t, mep, "env2", basicConfigWithoutPrivateAttrs())
+
+	flag1 := FlagEventProperties{Key: "flag1", Version: 1}
+	flag2 := FlagEventProperties{Key: "flag2", Version: 2}
+	context := basicContext()
+	ep1.RecordEvaluation(defaultEventFactory.NewEvaluationData(flag1, context, testEvalDetailWithoutReason,
+		false, ldvalue.Null(), "", ldvalue.OptionalInt{}, false))
+	ep2.RecordEvaluation(defaultEventFactory.NewEvaluationData(flag2, context, testEvalDetailWithoutReason,
+		false, ldvalue.Null(), "", ldvalue.OptionalInt{}, false))
+	ep1.Flush()
+	ep2.Flush()
+
+	assertEventsReceived(t, es1, anyIndexEvent(), m.AllOf(
+		summaryEventWithFlag(flag1, summaryCounterPropsFromEval(testEvalDetailWithoutReason, 1)),
+		m.JSONProperty("features").Should(m.JSONMap().Should(m.Length().Should(m.Equal(1)))),
+	))
+	assertEventsReceived(t, es2, anyIndexEvent(), m.AllOf(
+		summaryEventWithFlag(flag2, summaryCounterPropsFromEval(testEvalDetailWithoutReason, 1)),
+		m.JSONProperty("features").Should(m.JSONMap().Should(m.Length().Should(m.Equal(1)))),
+	))
+}
+
+func TestMultiEnvironmentPeriodicFlushAppliesToAllEnvironments(t *testing.T) {
+	config := basicMultiEnvironmentConfig()
+	config.FlushInterval = 10 * time.Millisecond
+	mep := NewMultiEnvironmentEventProcessor(config)
+	defer mep.Close()
+
+	ep1, es1 := addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+	ep2, es2 := addEnvironmentWithSender(t, mep, "env2", basicConfigWithoutPrivateAttrs())
+
+	ep1.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(Context(ldcontext.New("a")), ldvalue.OptionalInt{}))
+	ep2.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(Context(ldcontext.New("b")), ldvalue.OptionalInt{}))
+
+	assertEventsReceived(t, es1, identifyEventForContextKey("a"))
+	assertEventsReceived(t, es2, identifyEventForContextKey("b"))
+}
+
+func TestMultiEnvironmentDisabledStateIsPerEnvironment(t *testing.T) {
+	mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+	defer mep.Close()
+
+	ep1, es1 := addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+	ep2, es2 := addEnvironmentWithSender(t, mep, "env2", basicConfigWithoutPrivateAttrs())
+	es1.result = EventSenderResult{MustShutDown: true}
+
+	ie := defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{})
+	ep1.RecordIdentifyEvent(ie)
+	ep1.FlushBlocking(time.Second)
+	es1.awaitEvent(t)
+
+	ep1.RecordIdentifyEvent(ie)
+	ep1.FlushBlocking(time.Second)
+	es1.assertNoMoreEvents(t)
+
+	ep2.RecordIdentifyEvent(ie)
+	ep2.FlushBlocking(time.Second)
+	assertEventsReceived(t, es2, anyIdentifyEvent())
+
+	stats1, _ := mep.EnvironmentStats("env1")
+	assert.True(t, stats1.Disabled)
+	stats2, _ := mep.EnvironmentStats("env2")
+	assert.False(t, stats2.Disabled)
+}
+
+func TestMultiEnvironmentAddEnvironmentTwiceIsAnError(t *testing.T) {
+	mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+	defer mep.Close()
+
+	_, _ = addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+	ep, err := mep.AddEnvironment("env1", basicConfigWithoutPrivateAttrs())
+	assert.Error(t, err)
+	assert.Nil(t, ep)
+}
+
+func TestMultiEnvironmentEnvironmentLookup(t *testing.T) {
+	mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+	defer mep.Close()
+
+	_, es := addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+	assert.Nil(t, mep.Environment("env2"))
+
+	ep := mep.Environment("env1")
+	require.NotNil(t, ep)
+	ep.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}))
+	ep.Flush()
+	assertEventsReceived(t, es, anyIdentifyEvent())
+}
+
+func TestMultiEnvironmentRemoveEnvironmentFlushesAndStopsProcessing(t *testing.T) {
+	mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+	defer mep.Close()
+
+	ep, es := addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+	ie := defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{})
+	ep.RecordIdentifyEvent(ie)
+
+	assert.True(t, mep.RemoveEnvironment("env1"))
+	assertEventsReceived(t, es, anyIdentifyEvent())
+	assert.False(t, mep.RemoveEnvironment("env1"))
+	assert.Nil(t, mep.Environment("env1"))
+	_, ok := mep.EnvironmentStats("env1")
+	assert.False(t, ok)
+
+	ep.RecordIdentifyEvent(ie)
+	assert.True(t, ep.FlushBlocking(time.Second))
+	es.assertNoMoreEvents(t)
+}
+
+func TestMultiEnvironmentClosingEnvironmentHandleRemovesOnlyThatEnvironment(t *testing.T) {
+	mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+	defer mep.Close()
+
+	oldEP, _ := addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+	require.NoError(t, oldEP.Close())
+	newEP, es := addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+
+	require.NoError(t, oldEP.Close()) // must not remove the new environment with the same identifier
+	newEP.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}))
+	newEP.Flush()
+	assertEventsReceived(t, es, anyIdentifyEvent())
+}
+
+func TestMultiEnvironmentCloseFlushesAllEnvironments(t *testing.T) {
+	mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+
+	ep1, es1 := addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+	ep2, es2 := addEnvironmentWithSender(t, mep, "env2", basicConfigWithoutPrivateAttrs())
+	ie := defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{})
+	ep1.RecordIdentifyEvent(ie)
+	ep2.RecordIdentifyEvent(ie)
+	require.NoError(t, mep.Close())
+
+	assertEventsReceived(t, es1, anyIdentifyEvent())
+	assertEventsReceived(t, es2, anyIdentifyEvent())
+
+	// operations after closing must not block
+	_, err := mep.AddEnvironment("env3", basicConfigWithoutPrivateAttrs())
+	assert.Error(t, err)
+	assert.True(t, ep1.FlushBlocking(time.Second))
+	assert.NoError(t, ep1.Close())
+}
+
+func TestMultiEnvironmentSharesFlushWorkersAcrossEnvironments(t *testing.T) {
+	mep := NewMultiEnvironmentEventProcessor(basicMultiEnvironmentConfig())
+	defer mep.Close()
+
+	// FlushBlocking and RemoveEnvironment wait on short-lived goroutines, which may not have exited
+	// yet when they return, so this waits for the count to come down. It doesn't use
+	// require.Eventually, because that runs the condition on another goroutine. Goroutines left over
+	// from earlier tests may also exit in the meantime, so the count can end up lower than expected.
+	waitForGoroutineCount := func(max int) {
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > max && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine(), max)
+	}
+
+	ep0, _ := addEnvironmentWithSender(t, mep, "env0", basicConfigWithoutPrivateAttrs())
+	ep0.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}))
+	require.True(t, ep0.FlushBlocking(time.Second))
+	before := runtime.NumGoroutine()
+
+	ids := []string{"env1", "env2", "env3", "env4", "env5", "env6", "env7", "env8"}
+	for _, id := range ids {
+		ep, _ := addEnvironmentWithSender(t, mep, id, basicConfigWithoutPrivateAttrs())
+		ep.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{}))
+		require.True(t, ep.FlushBlocking(time.Second))
+	}
+	// If each environment had its own workers, there would be at least one more goroutine for each
+	// of them.
+	waitForGoroutineCount(before + 2)
+
+	for _, id := range ids {
+		require.True(t, mep.RemoveEnvironment(id))
+	}
+	waitForGoroutineCount(before)
+}
+
+func TestMultiEnvironmentRemoveEnvironmentWaitsForFlushWorker(t *testing.T) {
+	config := basicMultiEnvironmentConfig()
+	config.FlushWorkers = 1
+	config.FlushQueueCapacity = 1
+	mep := NewMultiEnvironmentEventProcessor(config)
+	defer mep.Close()
+
+	ep, es := addEnvironmentWithSender(t, mep, "env1", basicConfigWithoutPrivateAttrs())
+	senderGateCh := make(chan struct{})
+	senderWaitingCh := make(chan struct{}, 10)
+	es.setGate(senderGateCh, senderWaitingCh)
+
+	// The first payload occupies the only worker, and the second one fills the queue.
+	for _, key := range []string{"a", "b"} {
+		ep.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(Context(ldcontext.New(key)),
+			ldvalue.OptionalInt{}))
+		ep.Flush()
+		if key == "a" {
+			<-senderWaitingCh
+		}
+	}
+	ep.RecordIdentifyEvent(defaultEventFactory.NewIdentifyEventData(Context(ldcontext.New("c")),
+		ldvalue.OptionalInt{}))
+
+	// The removal is posted directly, rather than with RemoveEnvironment, so that we can tell when
+	// the main loop has taken it: once the inbox is empty, the main loop is handling the removal,
+	// which cannot finish until the worker is released.
+	owner := mep.(*multiEnvironmentEventProcessor)
+	removedCh := make(chan bool, 1)
+	owner.inboxCh <- removeEnvironmentMessage{id: "env1", replyCh: removedCh}
+	for len(owner.inboxCh) > 0 {
+		runtime.Gosched()
+	}
+	select {
+	case <-removedCh:
+		require.Fail(t, "environment was removed before its events were delivered")
+	default:
+	}
+	close(senderGateCh)
+
+	assert.True(t, <-removedCh)
+	assertEventsReceived(t, es, identifyEventForContextKey("a"), identifyEventForContextKey("b"),
+		identifyEventForContextKey("c"))
+	es.assertNoMoreEvents(t)
+}
+
+func TestMultiEnvironmentDefaultSenderUsesEnvironmentIDAsSDKKey(t *testing.T) {
+	requestsCh := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsCh <- r
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	config := basicMultiEnvironmentConfig()
+	config.EventSenderConfiguration = EventSenderConfiguration{Client: server.Client(), BaseURI: server.URL}
+	mep := NewMultiEnvironmentEventProcessor(config)
+	defer mep.Close()
+
+	ep, err := mep.AddEnvironment("sdk-key-1", basicConfigWithoutPrivateAttrs())
+	require.NoError(t, err)
+	ep.RecordRawEvent(json.RawMessage(`{"kind":"raw"}`))
+	require.True(t, ep.FlushBlocking(time.Second))
+
+	r := <-requestsCh
+	assert.Equal(t, "/bulk", r.URL.Path)
+	assert.Equal(t, "sdk-key-1", r.Header.Get("Authorization"))
+}
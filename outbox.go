@@ -2,23 +2,40 @@ package ldevents
 
 import (
 	"github.com/launchdarkly/go-sdk-common/v3/ldlog"
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
 )
 
 type eventsOutbox struct {
 	events           []anyEventOutput
 	summarizer       eventSummarizer
+	metrics          metricAggregator
+	sendMetrics      bool
 	capacity         int
 	capacityExceeded bool
 	droppedEvents    int
 	loggers          ldlog.Loggers
 }
 
-func newEventsOutbox(capacity int, loggers ldlog.Loggers) *eventsOutbox {
+func newEventsOutbox(config EventsConfiguration) *eventsOutbox {
+	summarizer := newEventSummarizer()
+	summarizer.summarizeReasons = config.SummarizeReasons
+	summarizer.summarizeUniqueContexts = config.SummarizeUniqueContexts
+	summarizer.flagsCapacity = config.SummaryFlagsCapacity
+	summarizer.countersPerFlagCapacity = config.SummaryCountersPerFlagCapacity
+	if config.SummaryBucketInterval > 0 {
+		summarizer.bucketMillis = ldtime.UnixMillisecondTime(config.SummaryBucketInterval.Milliseconds())
+	}
+	metricKeys := config.AggregatedMetricEventKeys
+	if !config.SendMetricSummaryEvents && config.OnMetricSummary == nil {
+		metricKeys = nil // the aggregated data would have nowhere to go
+	}
 	return &eventsOutbox{
-		events:     make([]anyEventOutput, 0, capacity),
-		summarizer: newEventSummarizer(),
-		capacity:   capacity,
-		loggers:    loggers,
+		events:      make([]anyEventOutput, 0, config.Capacity),
+		summarizer:  summarizer,
+		metrics:     newMetricAggregator(metricKeys, config.MetricHistogramBounds),
+		sendMetrics: config.SendMetricSummaryEvents,
+		capacity:    config.Capacity,
+		loggers:     config.Loggers,
 	}
 }
 
@@ -39,15 +56,25 @@ func (b *eventsOutbox) addToSummary(ed EvaluationData) {
 	b.summarizer.summarizeEvent(ed)
 }
 
+func (b *eventsOutbox) mergeSummary(summary eventSummary) {
+	b.summarizer.mergeSummary(summary)
+}
+
 func (b *eventsOutbox) getPayload() flushPayload {
 	var copied []anyEventOutput
-	if len(b.events) > 0 {
-		copied = make([]anyEventOutput, len(b.events))
+	metrics := b.metrics.snapshot()
+	sendMetrics := b.sendMetrics && metrics.hasData()
+	if len(b.events) > 0 || sendMetrics {
+		copied = make([]anyEventOutput, len(b.events), len(b.events)+1)
 		copy(copied, b.events)
+		if sendMetrics {
+			copied = append(copied, metrics)
+		}
 	}
 	return flushPayload{
-		events:  copied,
-		summary: b.summarizer.snapshot(),
+		events:    copied,
+		summaries: b.summarizer.snapshots(),
+		metrics:   metrics,
 	}
 }
 
@@ -57,4 +84,5 @@ func (b *eventsOutbox) clear() {
 	}
 	b.events = b.events[0:0]
 	b.summarizer.reset()
+	b.metrics.reset()
 }
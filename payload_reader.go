@@ -0,0 +1,534 @@
+package ldevents
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/launchdarkly/go-jsonstream/v3/jreader"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldmigration"
+	"github.com/launchdarkly/go-sdk-common/v3/ldreason"
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+)
+
+// In this file we parse analytics event payloads, in the format that eventOutputFormatter produces,
+// into values that describe each event as it appears in the payload. These are not the same types
+// as the inputs to EventProcessor, since the output does not have all of the same information:
+// for instance, a custom event only has the kinds and keys of its context.
+
+// ParsedFeatureEvent is a feature event or debug event from an analytics event payload. See
+// ParseEventPayload.
+type ParsedFeatureEvent struct {
+	// Debug is true if the kind of the event was FeatureDebugEventKind rather than
+	// FeatureRequestEventKind.
+	Debug        bool
+	CreationDate ldtime.UnixMillisecondTime
+	Key          string
+	Version      ldvalue.OptionalInt
+	// Context is the context as it appears in the event. Its private attributes are the attributes
+	// that were listed in redactedAttributes.
+	Context       ldcontext.EventOutputContext
+	Variation     ldvalue.OptionalInt
+	Value         ldvalue.Value
+	Default       ldvalue.Value
+	PrereqOf      ldvalue.OptionalString
+	Reason        ldreason.EvaluationReason
+	SamplingRatio ldvalue.OptionalInt
+}
+
+// ParsedCustomEvent is a custom event from an analytics event payload. See ParseEventPayload.
+type ParsedCustomEvent struct {
+	CreationDate ldtime.UnixMillisecondTime
+	Key          string
+	// ContextKeys has the key of each individual context, by kind.
+	ContextKeys   map[ldcontext.Kind]string
+	Data          ldvalue.Value
+	HasMetric     bool
+	MetricValue   float64
+	SamplingRatio ldvalue.OptionalInt
+}
+
+// ParsedIdentifyEvent is an identify event from an analytics event payload. See ParseEventPayload.
+type ParsedIdentifyEvent struct {
+	CreationDate ldtime.UnixMillisecondTime
+	// Context is the context as it appears in the event; see ParsedFeatureEvent.Context.
+	Context       ldcontext.EventOutputContext
+	SamplingRatio ldvalue.OptionalInt
+}
+
+// ParsedIndexEvent is an index event from an analytics event payload. See ParseEventPayload.
+type ParsedIndexEvent struct {
+	CreationDate ldtime.UnixMillisecondTime
+	// Context is the context as it appears in the event; see ParsedFeatureEvent.Context.
+	Context ldcontext.EventOutputContext
+}
+
+// ParsedMigrationOpEvent is a migration operation event from an analytics event payload. See
+// ParseEventPayload.
+type ParsedMigrationOpEvent struct {
+	CreationDate ldtime.UnixMillisecondTime
+	Op           ldmigration.Operation
+	// ContextKeys has the key of each individual context, by kind.
+	ContextKeys      map[ldcontext.Kind]string
+	FlagKey          string
+	Version          ldvalue.OptionalInt
+	Evaluation       ldreason.EvaluationDetail
+	Default          ldmigration.Stage
+	SamplingRatio    ldvalue.OptionalInt
+	ConsistencyCheck *ldmigration.ConsistencyCheck
+	Invoked          map[ldmigration.Origin]struct{}
+	Error            map[ldmigration.Origin]struct{}
+	Latency          map[ldmigration.Origin]int
+}
+
+// ParsedSummaryEvent is a summary event from an analytics event payload. See ParseEventPayload.
+type ParsedSummaryEvent struct {
+	StartDate ldtime.UnixMillisecondTime
+	EndDate   ldtime.UnixMillisecondTime
+	// Features has the summary of each flag, by flag key.
+	Features map[string]ParsedFlagSummary
+}
+
+// ParsedMetricSummaryEvent is a metric summary event from an analytics event payload; see
+// EventsConfiguration.SendMetricSummaryEvents and ParseEventPayload.
+type ParsedMetricSummaryEvent struct {
+	StartDate       ldtime.UnixMillisecondTime
+	EndDate         ldtime.UnixMillisecondTime
+	HistogramBounds []float64
+	// Metrics are in the order they appear in the event.
+	Metrics []MetricAggregate
+}
+
+// ParsedFlagSummary is the summary of one flag in a ParsedSummaryEvent.
+type ParsedFlagSummary struct {
+	Default ldvalue.Value
+	// Counters are in order of variation and then version, with undefined values first.
+	Counters []ParsedSummaryCounter
+	// ContextKinds are in alphabetical order.
+	ContextKinds []ldcontext.Kind
+	// Reasons are in order of reason kind and then error kind; see EventsConfiguration.SummarizeReasons.
+	Reasons []ParsedReasonCount
+}
+
+// ParsedSummaryCounter is one counter in a ParsedFlagSummary.
+type ParsedSummaryCounter struct {
+	Variation ldvalue.OptionalInt
+	// Version is empty if the counter was for an unknown flag.
+	Version ldvalue.OptionalInt
+	Value   ldvalue.Value
+	Count   int
+	// ContextCount and ContextSketch are only set if the summary counted unique contexts; see
+	// EventsConfiguration.SummarizeUniqueContexts. ContextCount is the contextCount property of the
+	// counter, or the estimate from ContextSketch if there was no such property.
+	ContextCount  ldvalue.OptionalInt
+	ContextSketch string
+}
+
+// ParsedReasonCount is the number of evaluations in a ParsedFlagSummary that had a particular kind
+// of reason.
+type ParsedReasonCount struct {
+	Kind      ldreason.EvalReasonKind
+	ErrorKind ldreason.EvalErrorKind
+	Count     int
+}
+
+// ParseEventPayload parses an analytics event payload, as it would be sent to the /bulk endpoint of
+// the events service: a JSON array of events. Each element of the result is a ParsedFeatureEvent,
+// ParsedCustomEvent, ParsedIdentifyEvent, ParsedIndexEvent, ParsedSummaryEvent,
+// ParsedMetricSummaryEvent, or ParsedMigrationOpEvent, depending on the kind of the event. An event
+// of any other kind, or with no kind, is returned as it is, as a json.RawMessage.
+//
+// It returns an error if the payload is not a JSON array of objects, or if an event of one of the
+// known kinds is malformed.
+func ParseEventPayload(data []byte) ([]interface{}, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return nil, err
+	}
+	ret := make([]interface{}, 0, len(elements))
+	for i, element := range elements {
+		event, err := parseEvent(element)
+		if err != nil {
+			return nil, fmt.Errorf("event %d in payload: %w", i, err)
+		}
+		ret = append(ret, event)
+	}
+	return ret, nil
+}
+
+func parseEvent(data json.RawMessage) (interface{}, error) {
+	kind, err := readEventKind(data)
+	if err != nil {
+		return nil, err
+	}
+	r := jreader.NewReader(data)
+	var event interface{}
+	switch kind {
+	case FeatureRequestEventKind, FeatureDebugEventKind:
+		event = readFeatureEvent(&r)
+	case CustomEventKind:
+		event = readCustomEvent(&r)
+	case IdentifyEventKind:
+		event = readIdentifyEvent(&r)
+	case IndexEventKind:
+		event = readIndexEvent(&r)
+	case MigrationOpEventKind:
+		event = readMigrationOpEvent(&r)
+	case MetricSummaryEventKind:
+		event = readMetricSummaryEvent(&r)
+	case SummaryEventKind:
+		summary, ok := readSummaryEvent(data)
+		if !ok {
+			return nil, fmt.Errorf("malformed summary event")
+		}
+		contextCounts, err := readSummaryContextCounts(data)
+		if err != nil {
+			return nil, err
+		}
+		return makeParsedSummaryEvent(summary, contextCounts), nil
+	default:
+		return data, nil
+	}
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// readEventKind returns the kind property of an event, or "" if it has none.
+func readEventKind(data []byte) (string, error) {
+	r := jreader.NewReader(data)
+	kind := ""
+	for obj := r.Object(); obj.Next(); {
+		if string(obj.Name()) == "kind" {
+			kind, _ = r.StringOrNull()
+		}
+	}
+	return kind, r.Error()
+}
+
+func readFeatureEvent(r *jreader.Reader) ParsedFeatureEvent {
+	var e ParsedFeatureEvent
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "kind":
+			e.Debug = r.String() == FeatureDebugEventKind
+		case "creationDate":
+			e.CreationDate = ldtime.UnixMillisecondTime(r.Float64())
+		case "key":
+			e.Key = r.String()
+		case "version":
+			e.Version.ReadFromJSONReader(r)
+		case "context":
+			ldcontext.ContextSerialization.UnmarshalFromJSONReaderEventOutput(r, &e.Context)
+		case "variation":
+			e.Variation.ReadFromJSONReader(r)
+		case "value":
+			e.Value.ReadFromJSONReader(r)
+		case "default":
+			e.Default.ReadFromJSONReader(r)
+		case "prereqOf":
+			e.PrereqOf.ReadFromJSONReader(r)
+		case "reason":
+			e.Reason.ReadFromJSONReader(r)
+		case "samplingRatio":
+			e.SamplingRatio.ReadFromJSONReader(r)
+		}
+	}
+	return e
+}
+
+func readCustomEvent(r *jreader.Reader) ParsedCustomEvent {
+	var e ParsedCustomEvent
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "creationDate":
+			e.CreationDate = ldtime.UnixMillisecondTime(r.Float64())
+		case "key":
+			e.Key = r.String()
+		case "contextKeys":
+			e.ContextKeys = readContextKeys(r)
+		case "data":
+			e.Data.ReadFromJSONReader(r)
+		case "metricValue":
+			e.MetricValue, e.HasMetric = r.Float64OrNull()
+		case "samplingRatio":
+			e.SamplingRatio.ReadFromJSONReader(r)
+		}
+	}
+	return e
+}
+
+func readIdentifyEvent(r *jreader.Reader) ParsedIdentifyEvent {
+	var e ParsedIdentifyEvent
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "creationDate":
+			e.CreationDate = ldtime.UnixMillisecondTime(r.Float64())
+		case "context":
+			ldcontext.ContextSerialization.UnmarshalFromJSONReaderEventOutput(r, &e.Context)
+		case "samplingRatio":
+			e.SamplingRatio.ReadFromJSONReader(r)
+		}
+	}
+	return e
+}
+
+func readIndexEvent(r *jreader.Reader) ParsedIndexEvent {
+	var e ParsedIndexEvent
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "creationDate":
+			e.CreationDate = ldtime.UnixMillisecondTime(r.Float64())
+		case "context":
+			ldcontext.ContextSerialization.UnmarshalFromJSONReaderEventOutput(r, &e.Context)
+		}
+	}
+	return e
+}
+
+func readMigrationOpEvent(r *jreader.Reader) ParsedMigrationOpEvent {
+	var e ParsedMigrationOpEvent
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "creationDate":
+			e.CreationDate = ldtime.UnixMillisecondTime(r.Float64())
+		case "operation":
+			e.Op = ldmigration.Operation(r.String())
+		case "contextKeys":
+			e.ContextKeys = readContextKeys(r)
+		case "samplingRatio":
+			e.SamplingRatio.ReadFromJSONReader(r)
+		case "evaluation":
+			readMigrationOpEvaluation(r, &e)
+		case "default": // eventOutputFormatter writes this within "evaluation", but we accept it here too
+			e.Default = ldmigration.Stage(r.String())
+		case "measurements":
+			for arr := r.Array(); arr.Next(); {
+				readMigrationOpMeasurement(r, &e)
+			}
+		}
+	}
+	return e
+}
+
+func readMigrationOpEvaluation(r *jreader.Reader, e *ParsedMigrationOpEvent) {
+	var value ldvalue.Value
+	var variation ldvalue.OptionalInt
+	var reason ldreason.EvaluationReason
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "key":
+			e.FlagKey = r.String()
+		case "value":
+			value.ReadFromJSONReader(r)
+		case "reason":
+			reason.ReadFromJSONReader(r)
+		case "default":
+			e.Default = ldmigration.Stage(r.String())
+		case "variation":
+			variation.ReadFromJSONReader(r)
+		case "version":
+			e.Version.ReadFromJSONReader(r)
+		}
+	}
+	e.Evaluation = ldreason.EvaluationDetail{Value: value, VariationIndex: variation, Reason: reason}
+}
+
+func readMigrationOpMeasurement(r *jreader.Reader, e *ParsedMigrationOpEvent) {
+	key := ""
+	consistent, consistencySamplingRatio := false, 1
+	var origins map[ldmigration.Origin]struct{}
+	var latencies map[ldmigration.Origin]int
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "key":
+			key = r.String()
+		case "value":
+			consistent = r.Bool()
+		case "samplingRatio":
+			consistencySamplingRatio = r.Int()
+		case "values":
+			// The values are booleans for "invoked" and "error", and numbers for "latency_ms", but the
+			// key might not have been read yet.
+			for valuesObj := r.Object(); valuesObj.Next(); {
+				origin := ldmigration.Origin(valuesObj.Name())
+				switch v := r.Any(); v.Kind {
+				case jreader.NumberValue:
+					if latencies == nil {
+						latencies = make(map[ldmigration.Origin]int)
+					}
+					latencies[origin] = int(v.Number)
+				case jreader.BoolValue:
+					if v.Bool {
+						if origins == nil {
+							origins = make(map[ldmigration.Origin]struct{})
+						}
+						origins[origin] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+	switch key {
+	case "invoked":
+		e.Invoked = origins
+	case "error":
+		e.Error = origins
+	case "latency_ms":
+		e.Latency = latencies
+	case "consistent":
+		e.ConsistencyCheck = ldmigration.NewConsistencyCheck(consistent, consistencySamplingRatio)
+	}
+}
+
+func readMetricSummaryEvent(r *jreader.Reader) ParsedMetricSummaryEvent {
+	var e ParsedMetricSummaryEvent
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "startDate":
+			e.StartDate = ldtime.UnixMillisecondTime(r.Float64())
+		case "endDate":
+			e.EndDate = ldtime.UnixMillisecondTime(r.Float64())
+		case "histogramBounds":
+			for arr := r.Array(); arr.Next(); {
+				e.HistogramBounds = append(e.HistogramBounds, r.Float64())
+			}
+		case "metrics":
+			for arr := r.Array(); arr.Next(); {
+				e.Metrics = append(e.Metrics, readMetricAggregate(r))
+			}
+		}
+	}
+	return e
+}
+
+func readMetricAggregate(r *jreader.Reader) MetricAggregate {
+	var m MetricAggregate
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "key":
+			m.Key = r.String()
+		case "contextKind":
+			m.ContextKind = ldcontext.Kind(r.String())
+		case "count":
+			m.Count = r.Int()
+		case "sum":
+			m.Sum = r.Float64()
+		case "min":
+			m.Min = r.Float64()
+		case "max":
+			m.Max = r.Float64()
+		case "histogram":
+			for arr := r.Array(); arr.Next(); {
+				m.Histogram = append(m.Histogram, r.Int())
+			}
+		}
+	}
+	return m
+}
+
+// readSummaryContextCounts returns the contextCount property of each summary counter that has one,
+// by flag key and counter key. readSummaryEvent does not keep these, since counts of unique contexts
+// cannot be added together when summaries are merged; for the same reason, a counter that appears
+// more than once for a flag is left out, so that the estimate from the merged sketches is used.
+func readSummaryContextCounts(data []byte) (map[string]map[counterKey]int, error) {
+	ret := make(map[string]map[counterKey]int)
+	seen := make(map[string]map[counterKey]struct{})
+	r := jreader.NewReader(data)
+	for obj := r.Object(); obj.Next(); {
+		if string(obj.Name()) != "features" {
+			continue
+		}
+		for flagsObj := r.Object(); flagsObj.Next(); {
+			flagKey := string(flagsObj.Name())
+			for flagObj := r.Object(); flagObj.Next(); {
+				if string(flagObj.Name()) != "counters" {
+					continue
+				}
+				for arr := r.Array(); arr.Next(); {
+					key, contextCount := readSummaryCounterContextCount(&r)
+					if _, ok := seen[flagKey][key]; ok {
+						delete(ret[flagKey], key)
+						continue
+					}
+					if seen[flagKey] == nil {
+						seen[flagKey] = make(map[counterKey]struct{})
+					}
+					seen[flagKey][key] = struct{}{}
+					if contextCount.IsDefined() {
+						if ret[flagKey] == nil {
+							ret[flagKey] = make(map[counterKey]int)
+						}
+						ret[flagKey][key] = contextCount.IntValue()
+					}
+				}
+			}
+		}
+	}
+	return ret, r.Error()
+}
+
+func readSummaryCounterContextCount(r *jreader.Reader) (counterKey, ldvalue.OptionalInt) {
+	var key counterKey
+	var contextCount ldvalue.OptionalInt
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "variation":
+			key.variation.ReadFromJSONReader(r)
+		case "version":
+			key.version.ReadFromJSONReader(r)
+		case "contextCount":
+			contextCount.ReadFromJSONReader(r)
+		}
+	}
+	return key, contextCount
+}
+
+func readContextKeys(r *jreader.Reader) map[ldcontext.Kind]string {
+	keys := make(map[ldcontext.Kind]string)
+	for obj := r.Object(); obj.Next(); {
+		keys[ldcontext.Kind(obj.Name())] = r.String()
+	}
+	return keys
+}
+
+func makeParsedSummaryEvent(
+	summary eventSummary,
+	contextCounts map[string]map[counterKey]int,
+) ParsedSummaryEvent {
+	ret := ParsedSummaryEvent{
+		StartDate: summary.startDate,
+		EndDate:   summary.endDate,
+		Features:  make(map[string]ParsedFlagSummary, len(summary.flags)),
+	}
+	for flagKey, flag := range summary.flags {
+		parsedFlag := ParsedFlagSummary{
+			Default:      flag.defaultValue,
+			ContextKinds: contextKindKeys(flag.contextKinds, true),
+		}
+		for _, key := range counterKeys(flag.counters, true) {
+			counter := ParsedSummaryCounter{
+				Variation: key.variation,
+				Version:   key.version,
+				Value:     flag.counters[key].flagValue,
+				Count:     flag.counters[key].count,
+			}
+			if sketch := flag.contextSketches[key]; sketch != nil {
+				counter.ContextCount = ldvalue.NewOptionalInt(sketch.estimate())
+				counter.ContextSketch = sketch.encode()
+			}
+			if contextCount, ok := contextCounts[flagKey][key]; ok {
+				counter.ContextCount = ldvalue.NewOptionalInt(contextCount)
+			}
+			parsedFlag.Counters = append(parsedFlag.Counters, counter)
+		}
+		for _, key := range reasonKeys(flag.reasons, true) {
+			parsedFlag.Reasons = append(parsedFlag.Reasons,
+				ParsedReasonCount{Kind: key.kind, ErrorKind: key.errorKind, Count: flag.reasons[key]})
+		}
+		ret.Features[flagKey] = parsedFlag
+	}
+	return ret
+}
@@ -0,0 +1,258 @@
+package ldevents
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldattr"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldmigration"
+	"github.com/launchdarkly/go-sdk-common/v3/ldreason"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	"github.com/launchdarkly/go-jsonstream/v3/jwriter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEventPayloadRoundTrip(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.PrivateAttributes = []ldattr.Ref{ldattr.NewRef("email")}
+	formatter := eventOutputFormatter{contextFormatter: newEventContextFormatter(config), config: config}
+
+	context := ldcontext.NewBuilder("user-key").Name("Red").SetString("email", "x@example.com").Build()
+	multiContext := ldcontext.NewMulti(context, ldcontext.NewWithKind("org", "org-key"))
+	expectedContext := ldcontext.NewBuilder("user-key").Name("Red").Private("email").Build()
+	flag := FlagEventProperties{Key: "flagkey", Version: 11}
+	detail := ldreason.NewEvaluationDetail(ldvalue.String("v"), 1, ldreason.NewEvalReasonFallthrough())
+
+	feature := withReasons.NewEvaluationData(flag, Context(context), detail, false, ldvalue.String("dv"), "",
+		ldvalue.NewOptionalInt(2), false)
+	feature.PrereqOf = ldvalue.NewOptionalString("parent")
+	debug := withoutReasons.NewEvaluationData(flag, Context(multiContext), detail, false, ldvalue.Null(), "",
+		ldvalue.OptionalInt{}, false)
+	debug.debug = true
+	custom := withoutReasons.NewCustomEventData("eventkey", Context(multiContext), ldvalue.Int(3), true, 1.5,
+		ldvalue.OptionalInt{})
+	identify := withoutReasons.NewIdentifyEventData(Context(context), ldvalue.NewOptionalInt(3))
+	index := indexEvent{BaseEvent{CreationDate: fakeTime, Context: Context(context)}}
+	migrationOp := newMigrationOp("flag", ldmigration.Read).invoked(ldmigration.Old, 10).invoked(ldmigration.New, 20).
+		failed(ldmigration.New).consistent(false).evt
+	migrationOp.Version = ldvalue.NewOptionalInt(2)
+	migrationOp.Evaluation = detail
+	migrationOp.Default = ldmigration.Live
+	migrationOp.SamplingRatio = ldvalue.NewOptionalInt(5)
+
+	summarizer := newEventSummarizer()
+	summarizer.summarizeEvent(feature)
+	summarizer.summarizeEvent(feature)
+	summarizer.summarizeEvent(withoutReasons.NewUnknownFlagEvaluationData("unknown", Context(multiContext),
+		ldvalue.Int(0), ldreason.NewEvalReasonError(ldreason.EvalErrorFlagNotFound)))
+
+	data, count := formatter.makeOutputEvents(
+		[]anyEventOutput{feature, debug, custom, identify, index, migrationOp}, summarizer.snapshot())
+	require.Equal(t, 7, count)
+
+	parsed, err := ParseEventPayload(data)
+	require.NoError(t, err)
+	require.Len(t, parsed, 7)
+
+	assert.Equal(t, ParsedFeatureEvent{
+		CreationDate:  fakeTime,
+		Key:           "flagkey",
+		Version:       ldvalue.NewOptionalInt(11),
+		Context:       ldcontext.EventOutputContext{Context: expectedContext},
+		Variation:     ldvalue.NewOptionalInt(1),
+		Value:         ldvalue.String("v"),
+		Default:       ldvalue.String("dv"),
+		PrereqOf:      ldvalue.NewOptionalString("parent"),
+		Reason:        ldreason.NewEvalReasonFallthrough(),
+		SamplingRatio: ldvalue.NewOptionalInt(2),
+	}, parsed[0])
+
+	assert.Equal(t, ParsedFeatureEvent{
+		Debug:        true,
+		CreationDate: fakeTime,
+		Key:          "flagkey",
+		Version:      ldvalue.NewOptionalInt(11),
+		Context: ldcontext.EventOutputContext{
+			Context: ldcontext.NewMulti(expectedContext, ldcontext.NewWithKind("org", "org-key")),
+		},
+		Variation: ldvalue.NewOptionalInt(1),
+		Value:     ldvalue.String("v"),
+		Default:   ldvalue.Null(),
+	}, parsed[1])
+
+	assert.Equal(t, ParsedCustomEvent{
+		CreationDate: fakeTime,
+		Key:          "eventkey",
+		ContextKeys:  map[ldcontext.Kind]string{"user": "user-key", "org": "org-key"},
+		Data:         ldvalue.Int(3),
+		HasMetric:    true,
+		MetricValue:  1.5,
+	}, parsed[2])
+
+	assert.Equal(t, ParsedIdentifyEvent{
+		CreationDate:  fakeTime,
+		Context:       ldcontext.EventOutputContext{Context: expectedContext},
+		SamplingRatio: ldvalue.NewOptionalInt(3),
+	}, parsed[3])
+
+	assert.Equal(t, ParsedIndexEvent{
+		CreationDate: fakeTime,
+		Context:      ldcontext.EventOutputContext{Context: expectedContext},
+	}, parsed[4])
+
+	assert.Equal(t, ParsedMigrationOpEvent{
+		CreationDate:     fakeTime,
+		Op:               ldmigration.Read,
+		ContextKeys:      map[ldcontext.Kind]string{"user": testContextKey},
+		FlagKey:          "flag",
+		Version:          ldvalue.NewOptionalInt(2),
+		Evaluation:       detail,
+		Default:          ldmigration.Live,
+		SamplingRatio:    ldvalue.NewOptionalInt(5),
+		ConsistencyCheck: ldmigration.NewConsistencyCheck(false, 1),
+		Invoked:          map[ldmigration.Origin]struct{}{ldmigration.Old: {}, ldmigration.New: {}},
+		Error:            map[ldmigration.Origin]struct{}{ldmigration.New: {}},
+		Latency:          map[ldmigration.Origin]int{ldmigration.Old: 10, ldmigration.New: 20},
+	}, parsed[5])
+
+	assert.Equal(t, ParsedSummaryEvent{
+		StartDate: fakeTime,
+		EndDate:   fakeTime,
+		Features: map[string]ParsedFlagSummary{
+			"flagkey": {
+				Default: ldvalue.String("dv"),
+				Counters: []ParsedSummaryCounter{
+					{Variation: ldvalue.NewOptionalInt(1), Version: ldvalue.NewOptionalInt(11), Value: ldvalue.String("v"), Count: 2},
+				},
+				ContextKinds: []ldcontext.Kind{"user"},
+			},
+			"unknown": {
+				Default:      ldvalue.Int(0),
+				Counters:     []ParsedSummaryCounter{{Value: ldvalue.Int(0), Count: 1}},
+				ContextKinds: []ldcontext.Kind{"org", "user"},
+			},
+		},
+	}, parsed[6])
+}
+
+func TestParseEventPayloadSummaryExtensions(t *testing.T) {
+	data := `[{"kind":"summary","startDate":1000,"endDate":2000,"features":{"flag":{"default":false,
+		"counters":[{"variation":1,"version":2,"value":true,"count":3},{"variation":0,"version":2,"value":false,"count":1}],
+		"contextKinds":["user"],
+		"reasons":[{"kind":"ERROR","errorKind":"FLAG_NOT_FOUND","count":1},{"kind":"FALLTHROUGH","count":3}]}}}]`
+	parsed, err := ParseEventPayload([]byte(data))
+	require.NoError(t, err)
+	require.Len(t, parsed, 1)
+	assert.Equal(t, ParsedSummaryEvent{
+		StartDate: 1000,
+		EndDate:   2000,
+		Features: map[string]ParsedFlagSummary{
+			"flag": {
+				Default: ldvalue.Bool(false),
+				Counters: []ParsedSummaryCounter{
+					{Variation: ldvalue.NewOptionalInt(0), Version: ldvalue.NewOptionalInt(2), Value: ldvalue.Bool(false), Count: 1},
+					{Variation: ldvalue.NewOptionalInt(1), Version: ldvalue.NewOptionalInt(2), Value: ldvalue.Bool(true), Count: 3},
+				},
+				ContextKinds: []ldcontext.Kind{"user"},
+				Reasons: []ParsedReasonCount{
+					{Kind: ldreason.EvalReasonError, ErrorKind: ldreason.EvalErrorFlagNotFound, Count: 1},
+					{Kind: ldreason.EvalReasonFallthrough, Count: 3},
+				},
+			},
+		},
+	}, parsed[0])
+}
+
+func TestParseEventPayloadSummaryContextCounts(t *testing.T) {
+	sketch := newHyperLogLog()
+	sketch.add("a")
+	sketch.add("b")
+	encoded := sketch.encode()
+	data := `[{"kind":"summary","startDate":1000,"endDate":2000,"features":{"flag":{"default":false,"counters":[
+		{"variation":0,"version":2,"value":false,"count":5,"contextCount":7,"contextSketch":"` + encoded + `"},
+		{"variation":1,"version":2,"value":true,"count":3,"contextSketch":"` + encoded + `"},
+		{"variation":2,"version":2,"value":true,"count":1,"contextCount":1,"contextSketch":"` + encoded + `"},
+		{"variation":2,"version":2,"value":true,"count":1,"contextCount":1,"contextSketch":"` + encoded + `"}]}}}]`
+	parsed, err := ParseEventPayload([]byte(data))
+	require.NoError(t, err)
+	require.Len(t, parsed, 1)
+	counters := parsed[0].(ParsedSummaryEvent).Features["flag"].Counters
+	require.Len(t, counters, 3)
+
+	// The count in the payload is used, and the sketch is only used if there is no count, or if the
+	// counter appears more than once so that the counts cannot be combined
+	assert.Equal(t, ldvalue.NewOptionalInt(7), counters[0].ContextCount)
+	assert.Equal(t, ldvalue.NewOptionalInt(sketch.estimate()), counters[1].ContextCount)
+	assert.Equal(t, ldvalue.NewOptionalInt(sketch.estimate()), counters[2].ContextCount)
+	assert.Equal(t, 2, counters[2].Count)
+	for _, c := range counters {
+		assert.Equal(t, encoded, c.ContextSketch)
+	}
+}
+
+func TestParseEventPayloadMetricSummary(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.CanonicalOutput = true // so that the metrics are in a predictable order
+	formatter := eventOutputFormatter{contextFormatter: newEventContextFormatter(config), config: config}
+	aggregator := newMetricAggregator([]string{"latency"}, []float64{10, 100})
+	context := Context(ldcontext.NewMulti(ldcontext.New("user-key"), ldcontext.NewWithKind("org", "org-key")))
+	aggregator.aggregate(withoutReasons.NewCustomEventData("latency", context, ldvalue.Null(), true, 5,
+		ldvalue.OptionalInt{}), 1)
+	aggregator.aggregate(withoutReasons.NewCustomEventData("latency", context, ldvalue.Null(), true, 50,
+		ldvalue.OptionalInt{}), 2)
+	summary := aggregator.snapshot()
+
+	w := jwriter.NewWriter()
+	obj := w.Object()
+	formatter.writeMetricSummaryFields(&obj, summary)
+	obj.End()
+	require.NoError(t, w.Error())
+
+	parsed, err := ParseEventPayload([]byte(`[` + string(w.Bytes()) + `]`))
+	require.NoError(t, err)
+	require.Len(t, parsed, 1)
+	assert.Equal(t, ParsedMetricSummaryEvent{
+		StartDate:       fakeTime,
+		EndDate:         fakeTime,
+		HistogramBounds: []float64{10, 100},
+		Metrics: []MetricAggregate{
+			{Key: "latency", ContextKind: "org", Count: 3, Sum: 105, Min: 5, Max: 50, Histogram: []int{1, 2, 0}},
+			{Key: "latency", ContextKind: "user", Count: 3, Sum: 105, Min: 5, Max: 50, Histogram: []int{1, 2, 0}},
+		},
+	}, parsed[0])
+}
+
+func TestParseEventPayloadUnknownKinds(t *testing.T) {
+	futureKind := `{"kind":"future","startDate":1000}`
+	noKind := `{"key":"x"}`
+	identify := `{"kind":"identify","creationDate":1000,"context":{"kind":"user","key":"x"}}`
+	parsed, err := ParseEventPayload([]byte(`[` + futureKind + `,` + noKind + `,` + identify + `]`))
+	require.NoError(t, err)
+	require.Len(t, parsed, 3)
+	assert.Equal(t, json.RawMessage(futureKind), parsed[0])
+	assert.Equal(t, json.RawMessage(noKind), parsed[1])
+	assert.Equal(t, ParsedIdentifyEvent{
+		CreationDate: 1000,
+		Context:      ldcontext.EventOutputContext{Context: ldcontext.New("x")},
+	}, parsed[2])
+}
+
+func TestParseEventPayloadErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"kind":"identify"}`,
+		`[1]`,
+		`[{"kind":"feature","key":3}]`,
+		`[{"kind":"identify","context":{"kind":"user"}}]`,
+		`[{"kind":"summary","startDate":1000}]`,
+		`[{"kind":"custom","contextKeys":[]}]`,
+	} {
+		t.Run(data, func(t *testing.T) {
+			_, err := ParseEventPayload([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
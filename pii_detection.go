@@ -0,0 +1,212 @@
+package ldevents
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldattr"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+)
+
+// PIIDetector recognizes string values that appear to contain personal information, so that they
+// can be redacted from output contexts even though nobody made those attributes private. This is a
+// safety net; it does not replace private attributes. See EventsConfiguration.PIIDetectors.
+//
+// Detectors are checked against every string value, including strings within object and array
+// values, other than the kind. Values that are already private or that have an attribute transform
+// are not checked. Since this happens whenever an event is written, it adds to the cost of each
+// flush in proportion to the number of string values.
+type PIIDetector interface {
+	// Name identifies the detector in a PIIDetection.
+	Name() string
+	// Detect returns true if the string contains something that this detector recognizes anywhere
+	// within it, not only if the whole string is such a value.
+	Detect(s string) bool
+}
+
+// PIIDetection describes a value that was redacted because a PIIDetector matched it. See
+// EventsConfiguration.PIIDetectionHandler. It does not include the value itself. A context that
+// appears in several events is reported each time it is written. The handler is called while events
+// are being flushed, possibly from several goroutines at once, so it should return quickly.
+//
+// The context key is also checked, but since every output context must have its key, a match is
+// only reported, with the Attribute "key", and the key is not redacted.
+type PIIDetection struct {
+	// ContextKind is the kind of the individual context that had the value.
+	ContextKind ldcontext.Kind
+	// Attribute is the path of the value, in the same format as the redactedAttributes of an output
+	// context: for instance, "email" or "/addresses/0/street".
+	Attribute string
+	// Detector is the name of the PIIDetector that matched the value. If more than one would have
+	// matched it, this is the first of them in EventsConfiguration.PIIDetectors.
+	Detector string
+}
+
+type piiDetectorFunc struct {
+	name string
+	fn   func(string) bool
+}
+
+// NewPIIDetector creates a PIIDetector from a function.
+func NewPIIDetector(name string, fn func(string) bool) PIIDetector {
+	return piiDetectorFunc{name: name, fn: fn}
+}
+
+func (d piiDetectorFunc) Name() string { return d.name }
+
+func (d piiDetectorFunc) Detect(s string) bool { return d.fn(s) }
+
+// NewPatternPIIDetector creates a PIIDetector that matches strings containing any of the given
+// regular expressions.
+func NewPatternPIIDetector(name string, patterns ...*regexp.Regexp) PIIDetector {
+	return NewPIIDetector(name, func(s string) bool {
+		for _, p := range patterns {
+			if p.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// DefaultPIIDetectors returns the built-in detectors that rarely match anything else:
+// NewEmailPIIDetector, NewPhoneNumberPIIDetector, NewIPAddressPIIDetector, and
+// NewNationalIDPIIDetector. NewCardNumberPIIDetector is not included, since it also matches many
+// numeric IDs.
+func DefaultPIIDetectors() []PIIDetector {
+	return []PIIDetector{
+		NewEmailPIIDetector(),
+		NewPhoneNumberPIIDetector(),
+		NewIPAddressPIIDetector(),
+		NewNationalIDPIIDetector(),
+	}
+}
+
+// NewEmailPIIDetector creates a PIIDetector named "email" that matches email addresses.
+func NewEmailPIIDetector() PIIDetector {
+	return NewPatternPIIDetector("email",
+		regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`))
+}
+
+// An international phone number has at least this many digits, including the country code. Shorter
+// sequences beginning with "+", such as "+1.2.3", are more likely to be versions or score deltas.
+const minInternationalPhoneDigits = 8
+
+// NewPhoneNumberPIIDetector creates a PIIDetector named "phone" that matches phone numbers: either
+// international numbers beginning with "+" that have at least 8 digits, or North American numbers of
+// ten digits that are separated by spaces, dots, or hyphens, or have the area code in parentheses. Ten
+// digits without separators are not matched, since they are more likely to be some other kind of
+// number.
+func NewPhoneNumberPIIDetector() PIIDetector {
+	international := regexp.MustCompile(`(^|[^\w+])\+[1-9]\d{0,2}([ .-]?\(?\d{1,4}\)?){2,5}\b`)
+	northAmerican := regexp.MustCompile(`(^|[^\w+])(\(\d{3}\) ?|\d{3}[ .-])\d{3}[ .-]\d{4}\b`)
+	return NewPIIDetector("phone", func(s string) bool {
+		if northAmerican.MatchString(s) {
+			return true
+		}
+		for _, match := range international.FindAllString(s, -1) {
+			digits := 0
+			for _, r := range match {
+				if r >= '0' && r <= '9' {
+					digits++
+				}
+			}
+			if digits >= minInternationalPhoneDigits {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// NewIPAddressPIIDetector creates a PIIDetector named "ip-address" that matches IPv4 and IPv6
+// addresses.
+func NewIPAddressPIIDetector() PIIDetector {
+	return NewPIIDetector("ip-address", func(s string) bool {
+		tokens := strings.FieldsFunc(s, func(r rune) bool {
+			return !(r == '.' || r == ':' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F'))
+		})
+		for _, token := range tokens {
+			if strings.ContainsAny(token, "0123456789") && net.ParseIP(strings.Trim(token, ".")) != nil {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// NewCardNumberPIIDetector creates a PIIDetector named "card-number" that matches payment card
+// numbers: 13 to 19 digits, which may be separated by spaces or hyphens, that pass the Luhn check.
+// One in ten of any other numbers of that length also pass the check, so this should not be used
+// if contexts have attributes such as numeric IDs or timestamps.
+func NewCardNumberPIIDetector() PIIDetector {
+	candidate := regexp.MustCompile(`\d([ -]?\d){12,18}`)
+	separators := strings.NewReplacer(" ", "", "-", "")
+	return NewPIIDetector("card-number", func(s string) bool {
+		for _, match := range candidate.FindAllString(s, -1) {
+			if luhnValid(separators.Replace(match)) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// NewNationalIDPIIDetector creates a PIIDetector named "national-id" that matches United States
+// Social Security numbers in the usual format, such as "123-45-6789", and United Kingdom National
+// Insurance numbers, such as "AB 12 34 56 C".
+func NewNationalIDPIIDetector() PIIDetector {
+	ssn := regexp.MustCompile(`\b(\d{3})-(\d{2})-(\d{4})\b`)
+	nino := regexp.MustCompile(`(?i)\b[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z] ?\d{2} ?\d{2} ?\d{2} ?[A-D]\b`)
+	return NewPIIDetector("national-id", func(s string) bool {
+		for _, m := range ssn.FindAllStringSubmatch(s, -1) {
+			// These area, group, and serial numbers are never issued
+			if m[1] != "000" && m[1] != "666" && m[1][0] != '9' && m[2] != "00" && m[3] != "0000" {
+				return true
+			}
+		}
+		return nino.MatchString(s)
+	})
+}
+
+func luhnValid(digits string) bool {
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// detectPII returns the name of the first configured detector that matches the string, or "" if
+// none do.
+func (f *eventContextFormatter) detectPII(s string) string {
+	for _, d := range f.piiDetectors {
+		if d.Detect(s) {
+			return d.Name()
+		}
+	}
+	return ""
+}
+
+// reportPII passes the values that were redacted by PII detectors in a context to the handler, along
+// with the key if the detectors match it.
+func (f *eventContextFormatter) reportPII(c *ldcontext.Context, meta contextMeta) {
+	if f.piiDetectionHandler == nil {
+		return
+	}
+	if detector := f.detectPII(c.Key()); detector != "" {
+		f.piiDetectionHandler(PIIDetection{ContextKind: c.Kind(), Attribute: ldattr.KeyAttr, Detector: detector})
+	}
+	for _, d := range meta.detectedPII {
+		d.ContextKind = c.Kind()
+		f.piiDetectionHandler(d)
+	}
+}
@@ -0,0 +1,178 @@
+package ldevents
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldattr"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	"github.com/launchdarkly/go-jsonstream/v3/jwriter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltInPIIDetectors(t *testing.T) {
+	for _, p := range []struct {
+		detector PIIDetector
+		name     string
+		matches  []string
+		others   []string
+	}{
+		{
+			NewEmailPIIDetector(), "email",
+			[]string{"sandy@example.com", "Contact: Sandy.Smith+x@mail.example.co.uk."},
+			[]string{"sandy", "sandy@localhost", "@example.com"},
+		},
+		{
+			NewPhoneNumberPIIDetector(), "phone",
+			[]string{"+44 20 7946 0958", "+49 30 1234", "(415) 555-0100", "call 415.555.0100", "415 555-0100"},
+			[]string{
+				"415-5550", "4155550100", "12345678901234", "order 41555501001", "415-5550100",
+				"+1.2.3", "v +10 20", "+1 234 567",
+			},
+		},
+		{
+			NewIPAddressPIIDetector(), "ip-address",
+			[]string{"192.168.0.1", "from 10.0.0.1.", "2001:db8::1", "fe80::1ff:fe23:4567:890a"},
+			[]string{"256.1.1.1", "12:30:45", "deadbeef", "1.2.3", "::"},
+		},
+		{
+			NewCardNumberPIIDetector(), "card-number",
+			[]string{"4111111111111111", "card 4111 1111 1111 1111", "5500-0000-0000-0004"},
+			[]string{"4111111111111112", "123456789012"},
+		},
+		{
+			NewNationalIDPIIDetector(), "national-id",
+			[]string{"123-45-6789", "ssn: 123-45-6789", "AB 12 34 56 C", "ab123456c"},
+			[]string{"000-12-3456", "666-12-3456", "123-00-6789", "123-45-0000", "123456789", "DQ123456C", "QQ123456C"},
+		},
+	} {
+		t.Run(p.name, func(t *testing.T) {
+			assert.Equal(t, p.name, p.detector.Name())
+			for _, s := range p.matches {
+				assert.True(t, p.detector.Detect(s), s)
+			}
+			for _, s := range p.others {
+				assert.False(t, p.detector.Detect(s), s)
+			}
+		})
+	}
+}
+
+func TestDefaultPIIDetectors(t *testing.T) {
+	var names []string
+	for _, d := range DefaultPIIDetectors() {
+		names = append(names, d.Name())
+	}
+	assert.Equal(t, []string{"email", "phone", "ip-address", "national-id"}, names)
+}
+
+func TestPatternPIIDetector(t *testing.T) {
+	d := NewPatternPIIDetector("employee-id", regexp.MustCompile(`\bE\d{6}\b`), regexp.MustCompile(`^emp:`))
+	assert.Equal(t, "employee-id", d.Name())
+	assert.True(t, d.Detect("id E123456"))
+	assert.True(t, d.Detect("emp:x"))
+	assert.False(t, d.Detect("E12345"))
+}
+
+func TestEventContextFormatterPIIDetection(t *testing.T) {
+	writeContext := func(f eventContextFormatter, c ldcontext.Context) string {
+		w := jwriter.NewWriter()
+		ec := Context(c)
+		f.WriteContext(&w, &ec)
+		require.NoError(t, w.Error())
+		return string(w.Bytes())
+	}
+
+	t.Run("values are redacted and reported", func(t *testing.T) {
+		var detections []PIIDetection
+		f := newEventContextFormatter(EventsConfiguration{
+			PIIDetectors:        DefaultPIIDetectors(),
+			PIIDetectionHandler: func(d PIIDetection) { detections = append(detections, d) },
+			CanonicalOutput:     true,
+		})
+		c := ldcontext.NewBuilder("sandy@example.com").
+			Kind("org").
+			Name("Sandy").
+			SetString("notes", "call 415-555-0100").
+			SetInt("age", 30).
+			SetValue("addresses", ldvalue.Parse([]byte(`[{"city":"SF","ip":"10.0.0.1"},"123-45-6789"]`))).
+			Build()
+		assert.Equal(t,
+			`{"kind":"org","key":"sandy@example.com","addresses":[{"city":"SF"}],"age":30,"name":"Sandy",`+
+				`"_meta":{"redactedAttributes":["/addresses/0/ip","/addresses/1","notes"]}}`,
+			writeContext(f, c))
+		assert.ElementsMatch(t, []PIIDetection{
+			{ContextKind: "org", Attribute: "key", Detector: "email"}, // reported, but not redacted
+			{ContextKind: "org", Attribute: "/addresses/0/ip", Detector: "ip-address"},
+			{ContextKind: "org", Attribute: "/addresses/1", Detector: "national-id"},
+			{ContextKind: "org", Attribute: "notes", Detector: "phone"},
+		}, detections)
+	})
+
+	t.Run("first matching detector is reported", func(t *testing.T) {
+		var detections []PIIDetection
+		f := newEventContextFormatter(EventsConfiguration{
+			PIIDetectors: []PIIDetector{
+				NewPIIDetector("everything", func(string) bool { return true }),
+				NewEmailPIIDetector(),
+			},
+			PIIDetectionHandler: func(d PIIDetection) { detections = append(detections, d) },
+		})
+		c := ldcontext.NewBuilder("my-key").SetString("email", "sandy@example.com").Build()
+		assert.Equal(t, `{"kind":"user","key":"my-key","_meta":{"redactedAttributes":["email"]}}`, writeContext(f, c))
+		assert.Equal(t, []PIIDetection{
+			{ContextKind: "user", Attribute: "key", Detector: "everything"},
+			{ContextKind: "user", Attribute: "email", Detector: "everything"},
+		}, detections)
+	})
+
+	t.Run("private and transformed values are not checked", func(t *testing.T) {
+		var detections []PIIDetection
+		f := newEventContextFormatter(EventsConfiguration{
+			PrivateAttributes: []ldattr.Ref{ldattr.NewRef("email")},
+			AttributeTransforms: []PrivateAttributeTransform{
+				{Attribute: ldattr.NewRef("work"), Transform: NewEmailDomainTransform()},
+			},
+			PIIDetectors:        DefaultPIIDetectors(),
+			PIIDetectionHandler: func(d PIIDetection) { detections = append(detections, d) },
+			CanonicalOutput:     true,
+		})
+		c := ldcontext.NewBuilder("my-key").
+			SetString("email", "sandy@example.com").
+			SetString("work", "sandy@example.com").
+			Build()
+		assert.Equal(t,
+			`{"kind":"user","key":"my-key","work":"example.com",`+
+				`"_meta":{"redactedAttributes":["email"],"transformedAttributes":{"work":"email-domain"}}}`,
+			writeContext(f, c))
+		assert.Len(t, detections, 0)
+	})
+
+	t.Run("no handler", func(t *testing.T) {
+		f := newEventContextFormatter(EventsConfiguration{PIIDetectors: DefaultPIIDetectors()})
+		c := ldcontext.NewBuilder("my-key").SetString("ip", "10.0.0.1").Build()
+		assert.Equal(t, `{"kind":"user","key":"my-key","_meta":{"redactedAttributes":["ip"]}}`, writeContext(f, c))
+	})
+
+	t.Run("values are reported once if attributes are dropped", func(t *testing.T) {
+		// The context is written more than once to find out which attributes to drop
+		var detections []PIIDetection
+		f := newEventContextFormatter(EventsConfiguration{
+			ContextMaxSize:      90,
+			PIIDetectors:        DefaultPIIDetectors(),
+			PIIDetectionHandler: func(d PIIDetection) { detections = append(detections, d) },
+		})
+		c := ldcontext.NewBuilder("my-key").
+			SetString("ip", "10.0.0.1").
+			SetString("big", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx").
+			Build()
+		assert.Equal(t,
+			`{"kind":"user","key":"my-key","_meta":{"redactedAttributes":["ip"],"droppedAttributes":["big"]}}`,
+			writeContext(f, c))
+		assert.Equal(t, []PIIDetection{{ContextKind: "user", Attribute: "ip", Detector: "ip-address"}}, detections)
+	})
+}
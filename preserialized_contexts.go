@@ -0,0 +1,64 @@
+package ldevents
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/launchdarkly/go-jsonstream/v3/jreader"
+	"github.com/launchdarkly/go-jsonstream/v3/jwriter"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+)
+
+// hasRedactionRules returns true if the configuration could cause any value in a context to be
+// redacted or changed, other than by redacting an anonymous context.
+func (f *eventContextFormatter) hasRedactionRules() bool {
+	return f.allAttributesPrivate || f.privateAttributes != nil || f.allowedAttributes != nil ||
+		f.attributeTransforms != nil || f.kindFormatters != nil || f.piiDetectors != nil || f.limits.any()
+}
+
+// writePreserializedContext writes a context that was given to PreserializedContext. Unless
+// EventsConfiguration.RedactPreserializedContexts is enabled, it is written unchanged. That option
+// is for the Relay Proxy, so that it can enforce its own configuration on events from SDKs that
+// send contexts in the event schema.
+//
+// Otherwise, the JSON is parsed in the event schema, where the private attributes of the resulting
+// Context are the ones that were already redacted, and then written just like any other context,
+// with those attributes added to redactedAttributes. If there are no rules that could change it,
+// it is still written unchanged: the anonymous attribute can only appear as "anonymous", so if
+// that string is not in the JSON, the context cannot be anonymous.
+func (f *eventContextFormatter) writePreserializedContext(
+	w *jwriter.Writer,
+	data json.RawMessage,
+	redactAnonymous bool,
+) {
+	if !f.redactPreserialized ||
+		(!f.hasRedactionRules() && !(redactAnonymous && bytes.Contains(data, []byte(`"anonymous"`)))) {
+		w.Raw(data)
+		return
+	}
+	var c ldcontext.EventOutputContext
+	r := jreader.NewReader(data)
+	ldcontext.ContextSerialization.UnmarshalFromJSONReaderEventOutput(&r, &c)
+	if err := r.Error(); err != nil {
+		w.AddError(err)
+		return
+	}
+	if c.Multiple() {
+		f.writeContextInternalMulti(w, &c.Context, redactAnonymous, true)
+	} else {
+		f.writeContextInternalSingle(w, &c.Context, true, redactAnonymous, true)
+	}
+}
+
+// removeDuplicateStrings removes all but the first of each string in the slice, in place.
+func removeDuplicateStrings(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	ret := values[:0]
+	for _, v := range values {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,124 @@
+package ldevents
+
+import (
+	"bytes"
+
+	"github.com/launchdarkly/go-jsonstream/v3/jreader"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldreason"
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+)
+
+// In this file we parse summary events that were produced elsewhere, in the same format that
+// writeSummaryEvent produces, so that they can be merged into our own summary. See
+// EventsConfiguration.MergeRawSummaryEvents. This is for the Relay Proxy, which forwards events from
+// other SDKs, so that each flush produces a single summary no matter how many it received. Raw
+// events that are not summary events, or that cannot be parsed, are delivered as they are.
+
+// readSummaryEvent parses a summary event. It returns false if the data is not a summary event, or
+// is malformed; in that case, it should be passed through as it is.
+func readSummaryEvent(data []byte) (eventSummary, bool) {
+	// Most raw events are not summary events, so avoid parsing them if we can.
+	if !bytes.Contains(data, []byte(`"`+SummaryEventKind+`"`)) {
+		return eventSummary{}, false
+	}
+	r := jreader.NewReader(data)
+	summary := newEventSummary()
+	kind := ""
+	requiredProps := []string{"kind", "startDate", "endDate", "features"}
+	for obj := r.Object().WithRequiredProperties(requiredProps); obj.Next(); {
+		switch string(obj.Name()) {
+		case "kind":
+			kind = r.String()
+		case "startDate":
+			summary.startDate = ldtime.UnixMillisecondTime(r.Float64())
+		case "endDate":
+			summary.endDate = ldtime.UnixMillisecondTime(r.Float64())
+		case "features":
+			for flagsObj := r.Object(); flagsObj.Next(); {
+				flagKey := string(flagsObj.Name())
+				summary.flags[flagKey] = readFlagSummary(&r)
+			}
+		}
+	}
+	if r.Error() != nil || r.RequireEOF() != nil || kind != SummaryEventKind {
+		return eventSummary{}, false
+	}
+	return summary, true
+}
+
+func readFlagSummary(r *jreader.Reader) flagSummary {
+	flag := flagSummary{
+		counters:     make(map[counterKey]*counterValue),
+		contextKinds: make(map[ldcontext.Kind]struct{}),
+	}
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "default":
+			flag.defaultValue.ReadFromJSONReader(r)
+		case "counters":
+			for arr := r.Array(); arr.Next(); {
+				readSummaryCounter(r, &flag)
+			}
+		case "contextKinds":
+			for arr := r.Array(); arr.Next(); {
+				flag.contextKinds[ldcontext.Kind(r.String())] = struct{}{}
+			}
+		case "reasons":
+			flag.reasons = make(map[reasonKey]int)
+			for arr := r.Array(); arr.Next(); {
+				var key reasonKey
+				count := 0
+				for reasonObj := r.Object(); reasonObj.Next(); {
+					switch string(reasonObj.Name()) {
+					case "kind":
+						key.kind = ldreason.EvalReasonKind(r.String())
+					case "errorKind":
+						key.errorKind = ldreason.EvalErrorKind(r.String())
+					case "count":
+						count = r.Int()
+					}
+				}
+				flag.reasons[key] += count
+			}
+		}
+	}
+	return flag
+}
+
+func readSummaryCounter(r *jreader.Reader, flag *flagSummary) {
+	var key counterKey
+	value := counterValue{flagValue: ldvalue.Null()}
+	var sketch *hyperLogLog
+	for obj := r.Object(); obj.Next(); {
+		switch string(obj.Name()) {
+		case "variation":
+			key.variation.ReadFromJSONReader(r)
+		case "version":
+			key.version.ReadFromJSONReader(r)
+		case "value":
+			value.flagValue.ReadFromJSONReader(r)
+		case "count":
+			value.count = r.Int()
+		case "contextSketch":
+			// An invalid sketch is ignored, since the rest of the counter is still useful.
+			sketch, _ = decodeHyperLogLog(r.String())
+		}
+	}
+	if existing, ok := flag.counters[key]; ok {
+		existing.count += value.count
+	} else {
+		flag.counters[key] = &value
+	}
+	if sketch != nil {
+		if flag.contextSketches == nil {
+			flag.contextSketches = make(map[counterKey]*hyperLogLog)
+		}
+		if existing, ok := flag.contextSketches[key]; ok {
+			existing.merge(sketch)
+		} else {
+			flag.contextSketches[key] = sketch
+		}
+	}
+}
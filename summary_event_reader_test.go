@@ -0,0 +1,86 @@
+package ldevents
+
+import (
+	"testing"
+
+	"github.com/launchdarkly/go-jsonstream/v3/jwriter"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldreason"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSummaryEventRoundTrip(t *testing.T) {
+	es := newEventSummarizer()
+	es.summarizeReasons = true
+	es.summarizeUniqueContexts = true
+	es.countersPerFlagCapacity = 2
+	factory := NewEventFactory(true, fakeTimeFn)
+	for i, variation := range []int{0, 1, 0, 2} {
+		es.summarizeEvent(factory.NewEvaluationData(FlagEventProperties{Key: "flag1", Version: 1},
+			Context(ldcontext.New(string(rune('a'+i)))),
+			ldreason.NewEvaluationDetail(ldvalue.Int(variation), variation, ldreason.NewEvalReasonFallthrough()),
+			false, ldvalue.Int(-1), "", ldvalue.OptionalInt{}, false))
+	}
+	es.summarizeEvent(factory.NewUnknownFlagEvaluationData("flag2", Context(ldcontext.NewWithKind("org", "x")),
+		ldvalue.String("dv"), ldreason.NewEvalReasonError(ldreason.EvalErrorFlagNotFound)))
+	original := es.snapshot()
+
+	w := jwriter.NewWriter()
+	eventOutputFormatter{}.writeSummaryEvent(&w, original)
+	require.NoError(t, w.Error())
+
+	parsed, ok := readSummaryEvent(w.Bytes())
+	require.True(t, ok)
+	assert.Equal(t, original, parsed)
+}
+
+func TestReadSummaryEventWithoutOptionalProperties(t *testing.T) {
+	data := `{"kind":"summary","startDate":1000,"endDate":2000,"features":{"flag1":{"default":"dv",
+		"counters":[{"version":1,"variation":0,"value":"a","count":2},{"unknown":true,"value":"dv","count":1}],
+		"contextKinds":["user"],"extra":true}},"extra":[1,2]}`
+	parsed, ok := readSummaryEvent([]byte(data))
+	require.True(t, ok)
+	assert.Equal(t, eventSummary{
+		startDate: 1000,
+		endDate:   2000,
+		flags: map[string]flagSummary{
+			"flag1": {
+				defaultValue: ldvalue.String("dv"),
+				contextKinds: map[ldcontext.Kind]struct{}{"user": {}},
+				counters: map[counterKey]*counterValue{
+					{ldvalue.NewOptionalInt(0), ldvalue.NewOptionalInt(1)}: {2, ldvalue.String("a")},
+					{}: {1, ldvalue.String("dv")},
+				},
+			},
+		},
+	}, parsed)
+}
+
+func TestReadSummaryEventIgnoresInvalidSketch(t *testing.T) {
+	data := `{"kind":"summary","startDate":1000,"endDate":2000,"features":{"flag1":{"default":"dv",
+		"counters":[{"version":1,"variation":0,"value":"a","count":2,"contextCount":1,"contextSketch":"AAAA"}],
+		"contextKinds":["user"]}}}`
+	parsed, ok := readSummaryEvent([]byte(data))
+	require.True(t, ok)
+	assert.Nil(t, parsed.flags["flag1"].contextSketches)
+	assert.Len(t, parsed.flags["flag1"].counters, 1)
+}
+
+func TestReadSummaryEventRejectsOtherData(t *testing.T) {
+	for _, data := range []string{
+		`{"kind":"custom","key":"summary","creationDate":1000}`,
+		`{"kind":"summary","startDate":1000,"endDate":2000}`,
+		`{"kind":"summary","startDate":1000,"endDate":2000,"features":{"flag1":{"counters":[{"count":"x"}]}}}`,
+		`{"kind":"summary","startDate":1000,"endDate":2000,"features":{}} {}`,
+		`{"kind":"summary",`,
+		`["summary"]`,
+	} {
+		t.Run(data, func(t *testing.T) {
+			_, ok := readSummaryEvent([]byte(data))
+			assert.False(t, ok)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package ldevents
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/launchdarkly/go-jsonstream/v3/jwriter"
+)
+
+// SynchronousEventProcessor is an implementation of EventProcessor that does all of its work on the
+// caller's goroutine. It is intended for unit tests of code that produces events.
+//
+// It applies exactly the same logic as NewDefaultEventProcessor to decide which events to produce,
+// including context deduplication, sampling, debug events, and summarizing, and it formats the
+// output the same way. However, it has no background goroutines and no timers: events are only
+// delivered to the EventSender when Flush, FlushBlocking, or Close is called, and the set of known
+// context keys is only reset when ResetContextKeys is called.
+//
+// If config.DiagnosticsManager is set, the diagnostic initialization event is delivered when the
+// processor is created. Periodic diagnostic events are never sent.
+//
+// All methods are safe for concurrent use, but calls are serialized.
+type SynchronousEventProcessor struct {
+	dispatcher *eventDispatcher
+	closed     bool
+	lock       sync.Mutex
+}
+
+// NewSynchronousEventProcessor creates an instance of SynchronousEventProcessor.
+func NewSynchronousEventProcessor(config EventsConfiguration) *SynchronousEventProcessor {
+	ed := newEventDispatcherState(config)
+	if config.DiagnosticsManager != nil {
+		w := jwriter.NewWriter()
+		config.DiagnosticsManager.CreateInitEvent().WriteToJSONWriter(&w)
+		_ = config.EventSender.SendEventData(DiagnosticEventDataKind, w.Bytes(), 1)
+	}
+	return &SynchronousEventProcessor{dispatcher: ed}
+}
+
+// RecordEvaluation records evaluation information immediately.
+func (sp *SynchronousEventProcessor) RecordEvaluation(ed EvaluationData) {
+	sp.processEvent(ed)
+}
+
+// RecordIdentifyEvent records an identify event immediately.
+func (sp *SynchronousEventProcessor) RecordIdentifyEvent(e IdentifyEventData) {
+	sp.processEvent(e)
+}
+
+// RecordCustomEvent records a custom event immediately.
+func (sp *SynchronousEventProcessor) RecordCustomEvent(e CustomEventData) {
+	sp.processEvent(e)
+}
+
+// RecordMigrationOpEvent records a migration operation event immediately.
+func (sp *SynchronousEventProcessor) RecordMigrationOpEvent(e MigrationOpEventData) {
+	sp.processEvent(e)
+}
+
+// RecordRawEvent adds an event to the output buffer that is not parsed or transformed in any way.
+func (sp *SynchronousEventProcessor) RecordRawEvent(data json.RawMessage) {
+	sp.processEvent(rawEvent{data: data})
+}
+
+// Flush delivers any buffered events to the EventSender, and does not return until the EventSender
+// has returned.
+func (sp *SynchronousEventProcessor) Flush() {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	sp.flush()
+}
+
+// FlushBlocking is equivalent to Flush. The timeout is ignored, and it always returns true.
+func (sp *SynchronousEventProcessor) FlushBlocking(time.Duration) bool {
+	sp.Flush()
+	return true
+}
+
+// Close delivers any buffered events to the EventSender. Any events recorded after that point are
+// ignored.
+func (sp *SynchronousEventProcessor) Close() error {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	if !sp.closed {
+		sp.flush()
+		sp.closed = true
+	}
+	return nil
+}
+
+// ResetContextKeys clears the set of context keys that the processor has seen, so that the next
+// event for any context will produce a new index event. This is equivalent to the periodic reset
+// that NewDefaultEventProcessor does at EventsConfiguration.UserKeysFlushInterval.
+func (sp *SynchronousEventProcessor) ResetContextKeys() {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	sp.dispatcher.userKeys.clear()
+}
+
+// WaitForIdle returns immediately, since all of the processor's work is done synchronously. See
+// IdleWaiter.
+func (sp *SynchronousEventProcessor) WaitForIdle(context.Context) error {
+	return nil
+}
+
+// Idle returns a channel that is already closed. See IdleWaiter.
+func (sp *SynchronousEventProcessor) Idle() <-chan struct{} {
+	idleCh := make(chan struct{})
+	close(idleCh)
+	return idleCh
+}
+
+// ClockSkew returns the estimated offset of the server clock. See ClockSkewReporter.
+func (sp *SynchronousEventProcessor) ClockSkew() (time.Duration, bool) {
+	return sp.dispatcher.clockSkew.estimate()
+}
+
+// ContextLimitStats returns statistics about the context size limits. See ContextLimitReporter.
+func (sp *SynchronousEventProcessor) ContextLimitStats() ContextLimitStats {
+	return sp.dispatcher.formatter.contextFormatter.limitStats.get()
+}
+
+// SummaryOverflowEvaluations returns the number of evaluations that were left out of summary events.
+// See SummaryOverflowReporter.
+func (sp *SynchronousEventProcessor) SummaryOverflowEvaluations() int {
+	return sp.dispatcher.outbox.summarizer.overflowStats.get()
+}
+
+func (sp *SynchronousEventProcessor) processEvent(evt anyEventInput) {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	if !sp.closed {
+		sp.dispatcher.processEvent(evt)
+	}
+}
+
+// flush does the same thing as eventDispatcher.triggerFlush followed by runFlushTask, except that
+// it does not need to hand off the payload to another goroutine.
+func (sp *SynchronousEventProcessor) flush() {
+	ed := sp.dispatcher
+	if sp.closed || ed.disabled {
+		return
+	}
+	payload := ed.outbox.getPayload()
+	ed.outbox.clear()
+	ed.reportMetricSummary(payload.metrics)
+	formatter := ed.formatter.withClockOffset(ed.outputClockOffset())
+	bytes, count := formatter.makeOutputEvents(payload.events, payload.summaries...)
+	ed.eventsInLastBatch = count
+	ed.eventsFlushed += count
+	if len(bytes) > 0 {
+		result := ed.config.EventSender.SendEventData(AnalyticsEventDataKind, bytes, count)
+		ed.handleSenderResult(result)
+	}
+}
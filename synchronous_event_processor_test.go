@@ -0,0 +1,141 @@
+package ldevents
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/launchdarkly/go-sdk-common/v3/ldtime"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+
+	m "github.com/launchdarkly/go-test-helpers/v3/matchers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createSynchronousEventProcessorAndSender(config EventsConfiguration) (*SynchronousEventProcessor, *mockEventSender) {
+	sender := newMockEventSender()
+	config.EventSender = sender
+	return NewSynchronousEventProcessor(config), sender
+}
+
+func TestSynchronousEventProcessorSendsNothingUntilFlush(t *testing.T) {
+	ep, es := createSynchronousEventProcessorAndSender(basicConfigWithoutPrivateAttrs())
+	defer ep.Close()
+
+	context := basicContext()
+	ie := defaultEventFactory.NewIdentifyEventData(context, ldvalue.OptionalInt{})
+	ep.RecordIdentifyEvent(ie)
+	assert.Len(t, es.takeEvents(), 0)
+
+	ep.Flush()
+	m.In(t).Assert(es.takeEvents(), m.Items(m.JSONEqual(map[string]interface{}{
+		"kind":         "identify",
+		"creationDate": ie.CreationDate,
+		"context":      contextJSON(context, basicConfigWithoutPrivateAttrs()),
+	})))
+	assert.Equal(t, 1, es.getPayloadCount())
+}
+
+func TestSynchronousEventProcessorOutputIsInOrder(t *testing.T) {
+	ep, es := createSynchronousEventProcessorAndSender(basicConfigWithoutPrivateAttrs())
+	defer ep.Close()
+
+	flag := FlagEventProperties{Key: "flagkey", Version: 11, RequireFullEvent: true}
+	context := basicContext()
+	fe := defaultEventFactory.NewEvaluationData(flag, context, testEvalDetailWithoutReason, false, ldvalue.Null(), "",
+		ldvalue.OptionalInt{}, false)
+	ce := defaultEventFactory.NewCustomEventData("eventkey", context, ldvalue.Null(), false, 0, ldvalue.OptionalInt{})
+	ep.RecordEvaluation(fe)
+	ep.RecordCustomEvent(ce)
+	ep.FlushBlocking(0)
+
+	m.In(t).Assert(es.takeEvents(), m.Items(
+		indexEventForContextKey(context.context.Key()),
+		featureEventForFlag(flag),
+		customEventWithEventKey("eventkey"),
+		summaryEventWithFlag(flag, summaryCounterPropsFromEval(testEvalDetailWithoutReason, 1)),
+	))
+}
+
+func TestSynchronousEventProcessorContextKeysAreRetainedUntilReset(t *testing.T) {
+	ep, es := createSynchronousEventProcessorAndSender(basicConfigWithoutPrivateAttrs())
+	defer ep.Close()
+
+	context := basicContext()
+	ce := defaultEventFactory.NewCustomEventData("eventkey", context, ldvalue.Null(), false, 0, ldvalue.OptionalInt{})
+	ep.RecordCustomEvent(ce)
+	ep.Flush()
+	m.In(t).Assert(es.takeEvents(), m.Items(anyIndexEvent(), anyCustomEvent()))
+
+	ep.RecordCustomEvent(ce)
+	ep.Flush()
+	m.In(t).Assert(es.takeEvents(), m.Items(anyCustomEvent()))
+
+	ep.ResetContextKeys()
+	ep.RecordCustomEvent(ce)
+	ep.Flush()
+	m.In(t).Assert(es.takeEvents(), m.Items(anyIndexEvent(), anyCustomEvent()))
+}
+
+func TestSynchronousEventProcessorDebugModeUsesServerTime(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	fakeTimeNow := ldtime.UnixMillisecondTime(1000000)
+	config.Clock = newTestClock(fakeTimeNow)
+	ep, es := createSynchronousEventProcessorAndSender(config)
+	defer ep.Close()
+
+	es.result = EventSenderResult{Success: true, TimeFromServer: fakeTimeNow + 20000}
+	ep.RecordRawEvent(json.RawMessage(`{"kind":"raw"}`))
+	ep.Flush()
+	_ = es.takeEvents()
+
+	// Debug mode would still be active according to the client's clock, but not according to the server's.
+	flag := FlagEventProperties{Key: "flagkey", Version: 11, DebugEventsUntilDate: fakeTimeNow + 10000}
+	fe := defaultEventFactory.NewEvaluationData(flag, basicContext(), testEvalDetailWithoutReason, false, ldvalue.Null(),
+		"", ldvalue.OptionalInt{}, false)
+	ep.RecordEvaluation(fe)
+	ep.Flush()
+	m.In(t).Assert(es.takeEvents(), m.Items(anyIndexEvent(), anySummaryEvent()))
+}
+
+func TestSynchronousEventProcessorStopsSendingAfterUnrecoverableError(t *testing.T) {
+	ep, es := createSynchronousEventProcessorAndSender(basicConfigWithoutPrivateAttrs())
+	defer ep.Close()
+
+	es.result = EventSenderResult{MustShutDown: true}
+	ie := defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{})
+	ep.RecordIdentifyEvent(ie)
+	ep.Flush()
+	assert.Len(t, es.takeEvents(), 1)
+
+	ep.RecordIdentifyEvent(ie)
+	ep.Flush()
+	assert.Len(t, es.takeEvents(), 0)
+}
+
+func TestSynchronousEventProcessorCloseFlushesAndIgnoresLaterEvents(t *testing.T) {
+	ep, es := createSynchronousEventProcessorAndSender(basicConfigWithoutPrivateAttrs())
+
+	ie := defaultEventFactory.NewIdentifyEventData(basicContext(), ldvalue.OptionalInt{})
+	ep.RecordIdentifyEvent(ie)
+	require.NoError(t, ep.Close())
+	assert.Len(t, es.takeEvents(), 1)
+
+	ep.RecordIdentifyEvent(ie)
+	ep.Flush()
+	require.NoError(t, ep.Close())
+	assert.Len(t, es.takeEvents(), 0)
+}
+
+func TestSynchronousEventProcessorSendsDiagnosticInitEvent(t *testing.T) {
+	config := basicConfigWithoutPrivateAttrs()
+	config.DiagnosticsManager = NewDiagnosticsManager(NewDiagnosticID("sdkkey"), ldvalue.Null(), ldvalue.Null(),
+		time.Now(), nil)
+	ep, es := createSynchronousEventProcessorAndSender(config)
+	defer ep.Close()
+
+	require.Len(t, es.diagnosticEvents, 1)
+	m.In(t).Assert(es.diagnosticEvents[0], eventKindIs("diagnostic-init"))
+}